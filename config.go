@@ -0,0 +1,93 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"time"
+)
+
+// Config is a snapshot of the settings of a GoSNMP that identify the agent
+// and how to talk to it, see GoSNMP.Config and FromConfig. It holds no
+// connection, so it can be used to open any number of connections, eg one
+// per worker. The fields are those of GoSNMP of the same name.
+type Config struct {
+	Target             string
+	Port               uint16
+	Transport          string
+	Network            string
+	Community          string
+	Version            SnmpVersion
+	Timeout            time.Duration
+	Retries            int
+	ExponentialTimeout bool
+	MaxOids            int
+	MaxRepetitions     uint32
+	NonRepeaters       int
+
+	MsgFlags           SnmpV3MsgFlags
+	SecurityModel      SnmpV3SecurityModel
+	SecurityParameters SnmpV3SecurityParameters
+	ContextEngineID    string
+	ContextName        string
+
+	Logger Logger
+}
+
+// Config returns the settings of x as a Config. SecurityParameters is a
+// copy, so later changes to x, eg by engine discovery, don't affect it.
+func (x *GoSNMP) Config() Config {
+	c := Config{
+		Target:             x.Target,
+		Port:               x.Port,
+		Transport:          x.Transport,
+		Network:            x.Network,
+		Community:          x.Community,
+		Version:            x.Version,
+		Timeout:            x.Timeout,
+		Retries:            x.Retries,
+		ExponentialTimeout: x.ExponentialTimeout,
+		MaxOids:            x.MaxOids,
+		MaxRepetitions:     x.MaxRepetitions,
+		NonRepeaters:       x.NonRepeaters,
+		MsgFlags:           x.MsgFlags,
+		SecurityModel:      x.SecurityModel,
+		ContextEngineID:    x.ContextEngineID,
+		ContextName:        x.ContextName,
+		Logger:             x.Logger,
+	}
+	if x.SecurityParameters != nil {
+		c.SecurityParameters = x.SecurityParameters.Copy()
+	}
+	return c
+}
+
+// FromConfig returns a GoSNMP with the settings of c, not yet connected:
+// call Connect on it. Its SecurityParameters is a copy of those of c, so
+// that c can be used again.
+func FromConfig(c Config) *GoSNMP {
+	x := &GoSNMP{
+		Target:             c.Target,
+		Port:               c.Port,
+		Transport:          c.Transport,
+		Network:            c.Network,
+		Community:          c.Community,
+		Version:            c.Version,
+		Timeout:            c.Timeout,
+		Retries:            c.Retries,
+		ExponentialTimeout: c.ExponentialTimeout,
+		MaxOids:            c.MaxOids,
+		MaxRepetitions:     c.MaxRepetitions,
+		NonRepeaters:       c.NonRepeaters,
+		MsgFlags:           c.MsgFlags,
+		SecurityModel:      c.SecurityModel,
+		ContextEngineID:    c.ContextEngineID,
+		ContextName:        c.ContextName,
+		Logger:             c.Logger,
+	}
+	if c.SecurityParameters != nil {
+		x.SecurityParameters = c.SecurityParameters.Copy()
+	}
+	return x
+}
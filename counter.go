@@ -0,0 +1,59 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"sync"
+	"time"
+)
+
+// Counter64Delta returns the increase of a Counter64 from prev to cur,
+// allowing for cur having wrapped past 2^64-1 back to zero since prev.
+func Counter64Delta(prev, cur uint64) uint64 {
+	// unsigned subtraction is modulo 2^64, the wrap of the counter
+	return cur - prev
+}
+
+// RateTracker computes the per second rates of Counter64 values polled
+// repeatedly, keyed by their OID. The zero RateTracker is ready to use, and
+// it is safe for concurrent use.
+type RateTracker struct {
+	mu      sync.Mutex
+	samples map[string]rateSample
+}
+
+// rateSample is the previous value of an OID, and when it was polled.
+type rateSample struct {
+	value uint64
+	at    time.Time
+}
+
+// Update records the value of oid polled at the time at, and returns its
+// rate per second since the previous value of oid. ok is false, and the rate
+// zero, for the first value of an OID or when at is not after the time of
+// the previous value. A single wrap of the counter between polls is allowed
+// for, a reset of the agent's counters is not: discard the rate after the
+// agent restarted.
+func (r *RateTracker) Update(oid string, value uint64, at time.Time) (rate float64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.samples == nil {
+		r.samples = make(map[string]rateSample)
+	}
+	prev, found := r.samples[oid]
+	r.samples[oid] = rateSample{value: value, at: at}
+	if !found || !at.After(prev.at) {
+		return 0, false
+	}
+	return float64(Counter64Delta(prev.value, value)) / at.Sub(prev.at).Seconds(), true
+}
+
+// Forget discards the previous value of oid, eg once its row was removed.
+func (r *RateTracker) Forget(oid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.samples, oid)
+}
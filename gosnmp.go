@@ -11,12 +11,19 @@ package gosnmp
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -34,8 +41,9 @@ const (
 	// https://tools.ietf.org/html/rfc2578#section-7.1.3
 	MaxObjectSubIdentifierValue = 4294967295
 
-	// Java SNMP uses 50, snmp-net uses 10
-	defaultMaxRepetitions = 50
+	// DefaultMaxRepetitions is the GETBULK max-repetitions used when
+	// MaxRepetitions is not set. Java SNMP uses 50, snmp-net uses 10
+	DefaultMaxRepetitions = 50
 
 	// "udp" is used regularly, prevent 'goconst' complaints
 	udp = "udp"
@@ -49,13 +57,48 @@ type GoSNMP struct {
 	// Target is an ipv4 address.
 	Target string
 
-	// Port is a port.
+	// Port is a port. If zero Connect uses 161, or 10161 for the "tls"
+	// Transport.
 	Port uint16
 
 	// Transport is the transport protocol to use ("udp" or "tcp"); if unset "udp" will be used.
+	// "tls" runs SNMP over a TLS session on TCP (RFC 6353), usually on port
+	// 10161, see TLSConfig and TransportSecurityModel.
 	Transport string
 
-	// Community is an SNMP Community string.
+	// TLSConfig is the configuration of the TLS client for the "tls"
+	// Transport. If nil the default configuration is used, verifying the
+	// agent's certificate against the system roots for Target. Set
+	// Certificates for mutual TLS, and VerifyPeerCertificate, eg to
+	// VerifyTLSFingerprints, to pin the agent's certificate.
+	TLSConfig *tls.Config
+
+	// Network optionally forces the address family used to resolve and dial
+	// Target: "udp4" or "udp6" (or "tcp4"/"tcp6"). This is useful when Target
+	// resolves to both A and AAAA records but only one family is reachable.
+	// When set it forces its family on Transport; "udp" and "tcp" leave the
+	// choice of family to the resolver. It must agree with Transport, eg
+	// "tcp4" needs Transport "tcp" or "tls", else Connect() fails; an unset
+	// Transport is taken from Network.
+	Network string
+
+	// IPv6TrafficClass if non zero, is the traffic class (0 to 255, the DSCP
+	// and ECN bits) set on the socket Connect() opens to an IPv6 target, for
+	// QoS. It is ignored for IPv4 targets. Setting the IPv6 flow label is not
+	// supported.
+	IPv6TrafficClass int
+
+	// LabSourceAddr if set, has Connect() open a raw socket sending the
+	// datagrams from LabSourceAddr in place of the host's address, eg to
+	// originate traps from many simulated agents. FOR TEST AND LAB USE
+	// ONLY: the source address is spoofed, root or CAP_NET_RAW is needed,
+	// only IPv4 on Linux is supported, and the connection can only send, so
+	// it suits SendTrap but not requests awaiting a response.
+	LabSourceAddr *net.UDPAddr
+
+	// Community is an SNMP Community string. An empty Community is sent as
+	// a zero-length community, as configured on some devices; no default is
+	// substituted for it, see Default for that.
 	Community string
 
 	// Version is an SNMP Version.
@@ -67,18 +110,41 @@ type GoSNMP struct {
 	// Timeout is the timeout for one SNMP request/response.
 	Timeout time.Duration
 
-	// Set the number of retries to attempt.
+	// Set the number of retries to attempt. Zero means a single attempt with
+	// no retries; negative values are treated as zero.
 	Retries int
 
 	// Double timeout in each retry.
 	ExponentialTimeout bool
 
+	// FailOnRequestIDMismatch if set, fails a request with
+	// ErrRequestIDMismatch when a response arrives whose request ID is not
+	// that of the request or of one of its retries. By default such
+	// responses are discarded, eg as late answers to an earlier request,
+	// and reading continues until the timeout.
+	FailOnRequestIDMismatch bool
+
+	// MaxInflightSends if positive, limits the number of requests,
+	// retransmits included, that may await a response on this connection at
+	// once. Further sends block until a slot frees up or Context is done.
+	// This keeps retransmits from piling up when the connection is shared
+	// between goroutines and packets are lost.
+	MaxInflightSends int
+
 	// Logger is the GoSNMP.Logger to use for debugging.
 	// For verbose logging to stdout:
 	// x.Logger = NewLogger(log.New(os.Stdout, "", 0))
 	// For Release builds, you can turn off logging entirely by using the go build tag "gosnmp_nodebug" even if the logger was installed.
 	Logger Logger
 
+	// RedactCommunity if set, logs the community as its first character
+	// followed by asterisks, eg "p*****" for "public".
+	RedactCommunity bool
+
+	// SecurityLogLevel is the level at which the SNMPV3 security parameters
+	// of each request are logged, see LeveledLoggerInterface.
+	SecurityLogLevel LogLevel
+
 	// Message hook methods allow passing in a functions at various points in the packet handling.
 	// For example, this can be used to collect packet timing, add metrics, or implement tracing.
 	/*
@@ -99,12 +165,34 @@ type GoSNMP struct {
 	// OnFinish is called when the request completed.
 	OnFinish func(*GoSNMP)
 
+	// OnFullTreeWalk is called when a walk is given an empty root OID, and
+	// so walks the whole of mib-2 from .1.3.6.1.2.1. Returning an error aborts the
+	// walk with that error before any request is sent.
+	OnFullTreeWalk func(*GoSNMP) error
+
 	// MaxOids is the maximum number of oids allowed in a Get().
 	// (default: MaxOids)
 	MaxOids int
 
+	// ChunkDelay if positive, is the time GetChunked and SetChunked wait
+	// between the requests of up to MaxOids OIDs they split a call into, for
+	// small devices dropping requests sent back to back. The wait ends early
+	// with an error when Context is done.
+	ChunkDelay time.Duration
+
+	// DuplicateOids selects how Get() handles an OID requested more than
+	// once, as agents respond inconsistently to duplicates.
+	// (default: DuplicateOidsAllow)
+	DuplicateOids DuplicateOidHandling
+
+	// MissingVarbinds selects how Get() handles a response with fewer
+	// variable bindings than OIDs requested, which agents must not send.
+	// (default: MissingVarbindsAllow)
+	MissingVarbinds MissingVarbindHandling
+
 	// MaxRepetitions sets the GETBULK max-repetitions used by BulkWalk*
-	// Unless MaxRepetitions is specified it will use defaultMaxRepetitions (50)
+	// and by GetBulk when called with a maxRepetitions of 0.
+	// Unless MaxRepetitions is specified it will use DefaultMaxRepetitions (50)
 	// This may cause issues with some devices, if so set MaxRepetitions lower.
 	// See comments in https://github.com/gosnmp/gosnmp/issues/100
 	MaxRepetitions uint32
@@ -113,12 +201,88 @@ type GoSNMP struct {
 	// (default: 0 as per RFC 1905)
 	NonRepeaters int
 
+	// TableConcurrency if greater than one, is the number of columns
+	// GetTable walks at once, each on its own connection to Target opened
+	// like this one. By default the columns are walked one after the other
+	// on this connection.
+	TableConcurrency int
+
 	// UseUnconnectedUDPSocket if set, changes net.Conn to be unconnected UDP socket.
 	// Some multi-homed network gear isn't smart enough to send SNMP responses
 	// from the address it received the requests on. To work around that,
 	// we open unconnected UDP socket and use sendto/recvfrom.
 	UseUnconnectedUDPSocket bool
 
+	// TransportFallback if set, re-issues a request over TCP to the same
+	// Target and Port when the response over UDP is tooBig or truncated,
+	// for agents that only send large responses over TCP. A new TCP
+	// connection is used for each such request.
+	TransportFallback bool
+
+	// ConcatenatedMessages if set, allows a received datagram to hold several
+	// SNMP messages back to back, as sent by some proxies. The TrapListener
+	// then handles each of them, and SnmpDecodePackets decodes all of them.
+	ConcatenatedMessages bool
+
+	// ReuseWalkBuffers if set, decodes the responses of a walk with a
+	// callback (BulkWalk, Walk, BulkWalkThrottled and All) into the same
+	// buffers, reducing allocations on large walks. Values referring to the
+	// response, such as OctetString []byte, are then only valid until the
+	// WalkFunc returns and must be copied to be retained.
+	ReuseWalkBuffers bool
+
+	// WalkMaxBytes if positive, aborts a walk with ErrWalkMaxBytes once the
+	// responses received for it add up to more than WalkMaxBytes bytes.
+	// This protects against agents streaming enormous values.
+	WalkMaxBytes int64
+
+	// WalkEndSentinel if set, ends a walk at the first PDU whose Type and
+	// Value equal those of WalkEndSentinel, for proprietary tables that mark
+	// their end with a sentinel value rather than leaving the subtree. Its
+	// Name is ignored. The sentinel PDU is not passed to the WalkFunc and no
+	// further requests are sent.
+	WalkEndSentinel *SnmpPDU
+
+	// WalkAllow if not empty, lists the OID prefixes whose PDUs walks
+	// report; PDUs outside of them are skipped.
+	WalkAllow []string
+
+	// WalkDeny lists OID prefixes whose PDUs walks never report, even if
+	// under the walked root or a WalkAllow prefix, eg private configuration
+	// branches.
+	WalkDeny []string
+
+	// WalkSkipDenied if set, continues a walk reaching a WalkDeny subtree
+	// after the subtree, rather than reading through it.
+	WalkSkipDenied bool
+
+	// NextCacheTTL if positive, caches the OIDs found by GETNEXT walks (Walk
+	// and WalkAll) for NextCacheTTL. Walking the same root again within the
+	// TTL then fetches the cached OIDs with GET requests of up to MaxOids
+	// OIDs, followed by a single GETNEXT checking that the table did not
+	// grow, rather than a GETNEXT per value. If the table changed size the
+	// cache entry is dropped and the root walked again. This suits polling
+	// small tables.
+	NextCacheTTL time.Duration
+
+	// Counter32AsGauge32 lists OIDs whose Counter32 values are decoded as
+	// Gauge32, for agents that send gauges with the Counter32 tag. Each entry
+	// also covers the subtree under it, so "." remaps every Counter32.
+	Counter32AsGauge32 []string
+
+	// IntegerAsIPAddress lists OIDs whose Integer values are decoded as an
+	// IPAddress, for agents that return IPv4 addresses as a 32-bit Integer.
+	// Entries cover subtrees like Counter32AsGauge32.
+	IntegerAsIPAddress []string
+
+	// IntegerIPByteOrder is the byte order of the addresses decoded for
+	// IntegerAsIPAddress. (default: binary.BigEndian, network byte order)
+	IntegerIPByteOrder binary.ByteOrder
+
+	// RecordVarbindOffsets if set, records the byte offset and length of
+	// each varbind in received packets in SnmpPacket.VarbindOffsets.
+	RecordVarbindOffsets bool
+
 	// netsnmp has '-C APPOPTS - set various application specific behaviours'
 	//
 	// - 'c: do not check returned OIDs are increasing' - use AppOpts = map[string]interface{"c":true} with
@@ -126,6 +290,21 @@ type GoSNMP struct {
 	// - 'p,i,I,t,E' -> pull requests welcome
 	AppOpts map[string]interface{}
 
+	// OIDRewrite if set, is applied to the OIDs of Get, GetNext and GetBulk
+	// requests and to the root OID of walks just before they are sent, after
+	// the leading zeros of their sub-identifiers are stripped, eg to
+	// map logical OIDs to vendor specific ones. Responses, and the values
+	// passed to walk callbacks, keep the rewritten OIDs. Walks continue from
+	// the last OID received, so OIDRewrite must return OIDs it has already
+	// rewritten unchanged.
+	OIDRewrite func(oid string) string
+
+	// RandomSource is read by Connect() to seed the request and message IDs,
+	// and those of the EngineKeepalive probes, so that responses are hard to
+	// spoof. If unset crypto/rand.Reader is
+	// used; a fixed source is only useful for reproducible tests.
+	RandomSource io.Reader
+
 	// Internal - used to sync requests to responses.
 	requestID uint32
 	random    uint32
@@ -147,11 +326,60 @@ type GoSNMP struct {
 	// ContextName is SNMPV3 ContextName in ScopedPDU
 	ContextName string
 
+	// DiscoveryTimeout and DiscoveryRetries, if set, are the timeout and
+	// number of retries of the SNMPV3 engine discovery probe in place of
+	// Timeout and Retries, eg to give up quickly on agents that don't
+	// respond to it. A negative DiscoveryRetries sends the probe only once.
+	// When discovery fails the request returns ErrEngineDiscoveryFailed.
+	DiscoveryTimeout time.Duration
+	DiscoveryRetries int
+
+	// ShareEngineDiscovery if set, shares the SNMPV3 authoritative engine ID,
+	// boots and time learnt by discovery with other GoSNMP instances that
+	// also set it, keyed by Target and Port. Connections to an engine that
+	// is already known then skip the discovery round-trip. Entries are
+	// invalidated when the agent reports an authentication failure.
+	ShareEngineDiscovery bool
+
+	// EngineCache if set, is used in place of the cache of
+	// ShareEngineDiscovery, eg a NewMemoryEngineCache whose entries expire,
+	// or one shared between processes. Boots and time are still updated
+	// from the agent's notInTimeWindow reports.
+	EngineCache EngineCache
+
+	// EngineKeepalive if positive, is the interval at which a goroutine
+	// started by Connect refreshes the SNMPV3 engine boots and time of
+	// SecurityParameters with a discovery probe, so that the first request
+	// after a long idle period is within the agent's time window. The probes
+	// are sent over a connection of their own. Close stops the goroutine.
+	EngineKeepalive time.Duration
+
 	// Internal - used to sync requests to responses - snmpv3.
 	msgID uint32
 
 	// Internal - we use to send packets if using unconnected socket.
 	uaddr *net.UDPAddr
+
+	// Internal - requests in flight and whether CloseGraceful was called,
+	// accessed atomically.
+	inflight int32
+	closing  int32
+
+	// Internal - semaphore for MaxInflightSends, nil when unlimited.
+	sendSlots chan struct{}
+
+	// Internal - the OIDs of GETNEXT walks, see NextCacheTTL.
+	nextCache *nextCache
+
+	// Internal - closed to stop the EngineKeepalive goroutine, nil if none.
+	keepaliveStop chan struct{}
+
+	// Internal - set by WalkRaw to leave values undecoded.
+	rawValues bool
+
+	// Internal - set by BulkWalkBatches, called by walks with the number of
+	// each response before its PDUs are reported.
+	walkResponse func(requestNum int) error
 }
 
 // Default connection settings
@@ -167,6 +395,40 @@ var Default = &GoSNMP{
 	MaxOids:            MaxOids,
 }
 
+// DuplicateOidHandling selects how Get() handles duplicate OIDs.
+type DuplicateOidHandling uint8
+
+const (
+	// DuplicateOidsAllow sends duplicate OIDs to the agent unchanged.
+	DuplicateOidsAllow DuplicateOidHandling = iota
+
+	// DuplicateOidsReject makes Get() return an error for duplicate OIDs.
+	DuplicateOidsReject
+
+	// DuplicateOidsMerge requests each OID once, and expands the response
+	// Variables to match the positions of the requested OIDs.
+	DuplicateOidsMerge
+)
+
+// MissingVarbindHandling selects how Get() handles a response missing
+// variable bindings.
+type MissingVarbindHandling uint8
+
+const (
+	// MissingVarbindsAllow returns the response as received, so its
+	// Variables no longer line up with the requested OIDs.
+	MissingVarbindsAllow MissingVarbindHandling = iota
+
+	// MissingVarbindsReject makes Get() return the response together with
+	// ErrMissingVarbinds.
+	MissingVarbindsReject
+
+	// MissingVarbindsPad inserts a NoSuchInstance variable for each OID
+	// missing from the response, so that Variables match the positions of
+	// the requested OIDs.
+	MissingVarbindsPad
+)
+
 // SnmpPDU will be used when doing SNMP Set's
 type SnmpPDU struct {
 	// Name is an oid in string format eg ".1.3.6.1.4.9.27"
@@ -176,10 +438,64 @@ type SnmpPDU struct {
 	Type Asn1BER
 
 	// The value to be set by the SNMP set, or the value when
-	// sending a trap
+	// sending a trap. A received IPAddress is a string, eg "192.0.2.1", or
+	// nil if empty, except for the nonstandard 16 byte values some agents
+	// send, which are a []byte of the raw bytes.
 	Value interface{}
 }
 
+// TimeTicksString formats the value of a TimeTicks PDU, in hundredths of a
+// second, as an uptime the way net-snmp does, eg "12 days, 3:04:05.67". ok
+// is false for other types. The raw ticks remain available in Value.
+func (pdu SnmpPDU) TimeTicksString() (uptime string, ok bool) {
+	if pdu.Type != TimeTicks {
+		return "", false
+	}
+	ticks := ToBigInt(pdu.Value).Uint64()
+	days := ticks / 8640000
+	clock := fmt.Sprintf("%d:%02d:%02d.%02d",
+		ticks/360000%24, ticks/6000%60, ticks/100%60, ticks%100)
+	switch days {
+	case 0:
+		return clock, true
+	case 1:
+		return "1 day, " + clock, true
+	}
+	return fmt.Sprintf("%d days, %s", days, clock), true
+}
+
+// Services is the sysServices (SNMPv2-MIB) of a node, the OSI layers of the
+// services it primarily offers.
+type Services struct {
+	Physical    bool // layer 1, eg repeaters
+	Datalink    bool // layer 2, eg bridges
+	Internet    bool // layer 3, eg IP gateways
+	EndToEnd    bool // layer 4, eg IP hosts
+	Application bool // layer 7, eg mail relays
+}
+
+// Services decodes the value of an Integer PDU, such as sysServices.0, as the
+// sysServices bitmask, where layer L sets the bit 2^(L-1): eg 72, a host,
+// is EndToEnd and Application. ok is false for other types and values outside
+// of the 0 to 127 range of sysServices.
+func (pdu SnmpPDU) Services() (services Services, ok bool) {
+	if pdu.Type != Integer {
+		return Services{}, false
+	}
+	value := ToBigInt(pdu.Value)
+	if value.Sign() < 0 || value.Cmp(big.NewInt(127)) > 0 {
+		return Services{}, false
+	}
+	bits := value.Uint64()
+	return Services{
+		Physical:    bits&(1<<0) != 0,
+		Datalink:    bits&(1<<1) != 0,
+		Internet:    bits&(1<<2) != 0,
+		EndToEnd:    bits&(1<<3) != 0,
+		Application: bits&(1<<6) != 0,
+	}, true
+}
+
 // AsnExtensionID mask to identify types > 30 in subsequent byte
 const AsnExtensionID = 0x1F
 
@@ -199,7 +515,7 @@ const (
 	Null              Asn1BER = 0x05
 	ObjectIdentifier  Asn1BER = 0x06
 	ObjectDescription Asn1BER = 0x07
-	IPAddress         Asn1BER = 0x40
+	IPAddress         Asn1BER = 0x40 // IPv4 only, see SnmpPDU.Value
 	Counter32         Asn1BER = 0x41
 	Gauge32           Asn1BER = 0x42
 	TimeTicks         Asn1BER = 0x43
@@ -277,17 +593,17 @@ func (x *GoSNMP) connect(networkSuffix string) error {
 		return err
 	}
 
-	x.Transport += networkSuffix
+	if networkSuffix != "" {
+		x.Transport = strings.TrimRight(x.Transport, "46") + networkSuffix
+	}
 	if err = x.netConnect(); err != nil {
 		return fmt.Errorf("error establishing connection to host: %w", err)
 	}
 
 	if x.random == 0 {
-		n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32)) // returns a uniform random value in [0, 2147483647].
-		if err != nil {
-			return fmt.Errorf("error occurred while generating random: %w", err)
+		if x.random, err = x.newRandom(); err != nil {
+			return err
 		}
-		x.random = uint32(n.Uint64())
 	}
 	// http://tools.ietf.org/html/rfc3412#section-6 - msgID only uses the first 31 bits
 	// msgID INTEGER (0..2147483647)
@@ -297,16 +613,99 @@ func (x *GoSNMP) connect(networkSuffix string) error {
 	x.requestID = x.random
 
 	x.rxBuf = new([rxBufSize]byte)
+	atomic.StoreInt32(&x.closing, 0)
+
+	x.sendSlots = nil
+	if x.MaxInflightSends > 0 {
+		x.sendSlots = make(chan struct{}, x.MaxInflightSends)
+	}
+	x.nextCache = &nextCache{entries: make(map[string]nextCacheEntry)}
 
+	x.stopEngineKeepalive()
+	if x.EngineKeepalive > 0 && x.Version == Version3 {
+		return x.startEngineKeepalive()
+	}
 	return nil
 }
 
+// newRandom returns a uniform random value in [0, 2147483647] from
+// RandomSource, to seed msgID and requestID.
+func (x *GoSNMP) newRandom() (uint32, error) {
+	source := x.RandomSource
+	if source == nil {
+		source = rand.Reader
+	}
+	n, err := rand.Int(source, big.NewInt(math.MaxInt32))
+	if err != nil {
+		return 0, fmt.Errorf("error occurred while generating random: %w", err)
+	}
+	return uint32(n.Uint64()), nil
+}
+
+// Close stops the EngineKeepalive goroutine, if any, and closes the
+// connection.
+func (x *GoSNMP) Close() error {
+	x.stopEngineKeepalive()
+	if x.Conn == nil {
+		return nil
+	}
+	return x.Conn.Close()
+}
+
+// closePollInterval is how often CloseGraceful checks for in-flight requests.
+const closePollInterval = 10 * time.Millisecond
+
+// CloseGraceful closes the connection once requests in flight on it have
+// completed, or when ctx is done, whichever comes first. Requests made after
+// CloseGraceful is called fail without being sent. If ctx is done before the
+// requests complete the connection is still closed, and ctx.Err() returned.
+func (x *GoSNMP) CloseGraceful(ctx context.Context) error {
+	atomic.StoreInt32(&x.closing, 1)
+	x.stopEngineKeepalive()
+
+	var err error
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+WaitLoop:
+	for atomic.LoadInt32(&x.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break WaitLoop
+		case <-ticker.C:
+		}
+	}
+
+	if x.Conn == nil {
+		return err
+	}
+	if closeErr := x.Conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 // Performs the real socket opening network operation. This can be used to do a
 // reconnect (needed for TCP)
 func (x *GoSNMP) netConnect() error {
 	var err error
 	addr := net.JoinHostPort(x.Target, strconv.Itoa(int(x.Port)))
 
+	if x.LabSourceAddr != nil {
+		if !strings.HasPrefix(x.Transport, "udp") {
+			return fmt.Errorf("LabSourceAddr requires a udp transport, not %s", x.Transport)
+		}
+		dst, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			return err
+		}
+		x.Conn, err = dialLabSource(x.LabSourceAddr, dst)
+		return err
+	}
+	if x.tlsTransport() {
+		return x.tlsConnect(addr)
+	}
+
 	switch transport := x.Transport; transport {
 	case "udp", "udp4", "udp6":
 		if x.UseUnconnectedUDPSocket {
@@ -322,20 +721,102 @@ func (x *GoSNMP) netConnect() error {
 				x.uaddr.IP = addr4
 				transport = "udp4"
 			}
-			x.Conn, err = net.ListenUDP(transport, nil)
-			return err
+			lc := net.ListenConfig{Control: x.socketControl}
+			conn, err := lc.ListenPacket(x.Context, transport, "")
+			if err != nil {
+				return err
+			}
+			x.Conn = conn.(*net.UDPConn)
+			return nil
 		}
 	}
-	dialer := net.Dialer{Timeout: x.Timeout}
+	dialer := net.Dialer{Timeout: x.Timeout, Control: x.socketControl}
 	x.Conn, err = dialer.DialContext(x.Context, x.Transport, addr)
 	return err
 }
 
+// socketControl sets the IPv6TrafficClass on IPv6 sockets.
+func (x *GoSNMP) socketControl(network, address string, c syscall.RawConn) error {
+	if x.IPv6TrafficClass != 0 && strings.HasSuffix(network, "6") {
+		if err := setTrafficClass(c, x.IPv6TrafficClass); err != nil {
+			return fmt.Errorf("setting IPv6 traffic class: %w", err)
+		}
+	}
+	return nil
+}
+
+// tlsConnect dials addr over TCP and performs the TLS handshake, for the
+// "tls" Transport.
+func (x *GoSNMP) tlsConnect(addr string) error {
+	dialer := net.Dialer{Timeout: x.Timeout, Control: x.socketControl}
+	conn, err := dialer.DialContext(x.Context, "tcp"+strings.TrimPrefix(x.Transport, "tls"), addr)
+	if err != nil {
+		return err
+	}
+
+	config := x.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName = x.Target
+	}
+	tlsConn := tls.Client(conn, config)
+	if x.Timeout > 0 {
+		_ = tlsConn.SetDeadline(time.Now().Add(x.Timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	if err = x.setTLSSecurityName(tlsConn.ConnectionState()); err != nil {
+		tlsConn.Close()
+		return err
+	}
+	x.Conn = tlsConn
+	return nil
+}
+
+// tlsTransport reports whether Transport is SNMP over TLS.
+func (x *GoSNMP) tlsTransport() bool {
+	return strings.HasPrefix(x.Transport, "tls")
+}
+
+// streamTransport reports whether Transport is a stream, TCP or TLS, whose
+// messages are delimited by their BER length.
+func (x *GoSNMP) streamTransport() bool {
+	return strings.HasPrefix(x.Transport, "tcp") || x.tlsTransport()
+}
+
 func (x *GoSNMP) validateParameters() error {
+	if x.Network != "" {
+		if err := x.applyNetwork(); err != nil {
+			return err
+		}
+	}
+
 	if x.Transport == "" {
 		x.Transport = udp
 	}
 
+	if x.Port == 0 {
+		x.Port = 161
+		if x.tlsTransport() {
+			x.Port = 10161 // snmptls, RFC 6353
+		}
+	}
+
+	if x.Retries < 0 {
+		x.Retries = 0
+	}
+
+	if x.IPv6TrafficClass < 0 || x.IPv6TrafficClass > 255 {
+		return fmt.Errorf("field IPv6TrafficClass %d is not in the range 0 to 255", x.IPv6TrafficClass)
+	}
+
 	if x.MaxOids == 0 {
 		x.MaxOids = MaxOids
 	} else if x.MaxOids < 0 {
@@ -361,6 +842,40 @@ func (x *GoSNMP) validateParameters() error {
 	return nil
 }
 
+// applyNetwork forces the address family of Network on Transport. A set
+// Transport must use the protocol of Network, TCP for TLS, and must not force
+// the other family.
+func (x *GoSNMP) applyNetwork() error {
+	var protocol string
+	switch x.Network {
+	case "udp", "udp4", "udp6":
+		protocol = udp
+	case "tcp", "tcp4", "tcp6":
+		protocol = "tcp"
+	default:
+		return fmt.Errorf("unsupported Network %q, use udp, udp4, udp6, tcp, tcp4 or tcp6", x.Network)
+	}
+	if x.Transport == "" {
+		x.Transport = x.Network
+		return nil
+	}
+
+	family := strings.TrimPrefix(x.Network, protocol)
+	base := strings.TrimRight(x.Transport, "46")
+	transportFamily := strings.TrimPrefix(x.Transport, base)
+	transportProtocol := base
+	if base == "tls" {
+		transportProtocol = "tcp"
+	}
+	if transportProtocol != protocol || family != "" && transportFamily != "" && family != transportFamily {
+		return fmt.Errorf("field Network %q conflicts with Transport %q", x.Network, x.Transport)
+	}
+	if family != "" {
+		x.Transport = base + family
+	}
+	return nil
+}
+
 func (x *GoSNMP) mkSnmpPacket(pdutype PDUType, pdus []SnmpPDU, nonRepeaters uint8, maxRepetitions uint32) *SnmpPacket {
 	var newSecParams SnmpV3SecurityParameters
 	if x.SecurityParameters != nil {
@@ -385,34 +900,341 @@ func (x *GoSNMP) mkSnmpPacket(pdutype PDUType, pdus []SnmpPDU, nonRepeaters uint
 
 // Get sends an SNMP GET request
 func (x *GoSNMP) Get(oids []string) (result *SnmpPacket, err error) {
+	return x.get(oids, x.Community)
+}
+
+// get performs a Get() with the given community in place of x.Community.
+func (x *GoSNMP) get(oids []string, community string) (result *SnmpPacket, err error) {
 	oidCount := len(oids)
 	if oidCount > x.MaxOids {
 		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
 			oidCount, x.MaxOids)
 	}
+	if x.DuplicateOids != DuplicateOidsAllow {
+		return x.getDuplicateOids(oids, community)
+	}
 	// convert oids slice to pdu slice
 	var pdus []SnmpPDU
 	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+		pdus = append(pdus, SnmpPDU{x.rewriteOID(oid), Null, nil})
 	}
 	// build up SnmpPacket
 	packetOut := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
-	return x.send(packetOut, true)
+	packetOut.Community = community
+	result, err = x.send(packetOut, true)
+	if err != nil {
+		return result, err
+	}
+	return result, x.checkMissingVarbinds(pdus, result)
+}
+
+// checkMissingVarbinds applies MissingVarbinds to the response result to a
+// GET of pdus.
+func (x *GoSNMP) checkMissingVarbinds(pdus []SnmpPDU, result *SnmpPacket) error {
+	if len(result.Variables) >= len(pdus) || result.Error != NoError || x.MissingVarbinds == MissingVarbindsAllow {
+		return nil
+	}
+	if x.MissingVarbinds == MissingVarbindsReject {
+		return fmt.Errorf("%w: got %d of %d", ErrMissingVarbinds, len(result.Variables), len(pdus))
+	}
+
+	received := make(map[string]SnmpPDU, len(result.Variables))
+	for _, pdu := range result.Variables {
+		received[strings.TrimPrefix(pdu.Name, ".")] = pdu
+	}
+	padded := make([]SnmpPDU, len(pdus))
+	for i, pdu := range pdus {
+		if variable, ok := received[strings.TrimPrefix(pdu.Name, ".")]; ok {
+			padded[i] = variable
+		} else {
+			padded[i] = SnmpPDU{Name: pdu.Name, Type: NoSuchInstance}
+		}
+	}
+	result.Variables = padded
+	return nil
+}
+
+// GetRaw sends an SNMP GET request like Get, and returns the response
+// datagram exactly as received, eg for a proxy to relay unchanged. The
+// response is still decoded to match it to the request by request ID, and
+// authenticated for SNMPv3, but the returned bytes are not decrypted.
+// DuplicateOids is ignored.
+func (x *GoSNMP) GetRaw(oids []string) ([]byte, error) {
+	if len(oids) > x.MaxOids {
+		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
+			len(oids), x.MaxOids)
+	}
+	var pdus []SnmpPDU
+	for _, oid := range oids {
+		pdus = append(pdus, SnmpPDU{x.rewriteOID(oid), Null, nil})
+	}
+	packetOut := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
+	packetOut.keepRaw = true
+	result, err := x.send(packetOut, true)
+	if err != nil {
+		return nil, err
+	}
+	return result.raw, nil
+}
+
+// GetTryCommunities sends an SNMP GET request with each community in turn
+// until the agent answers, and returns the response together with the
+// community that worked. A community is skipped when the request times out,
+// which is how most agents treat an unknown community, or when the agent
+// responds with an authorizationError. x.Community is left unchanged.
+func (x *GoSNMP) GetTryCommunities(oids []string, communities []string) (*SnmpPacket, string, error) {
+	if x.Version == Version3 {
+		return nil, "", fmt.Errorf("community strings are not used in SNMPv3")
+	}
+	if len(communities) == 0 {
+		return nil, "", fmt.Errorf("no communities to try")
+	}
+
+	var lastErr error
+	for _, community := range communities {
+		result, err := x.get(oids, community)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			lastErr = fmt.Errorf("community %q: %w", community, err)
+		case err != nil:
+			return nil, "", err
+		case result.Error == AuthorizationError:
+			lastErr = fmt.Errorf("community %q: %s", community, result.Error)
+		default:
+			return result, community, nil
+		}
+		x.Logger.Printf("GetTryCommunities: %v", lastErr)
+	}
+	return nil, "", fmt.Errorf("no community succeeded, last error: %w", lastErr)
+}
+
+// getDuplicateOids performs a Get() with community honouring x.DuplicateOids.
+func (x *GoSNMP) getDuplicateOids(oids []string, community string) (result *SnmpPacket, err error) {
+	var unique []string
+	positions := make([]int, len(oids)) // index into unique for each oid
+	seen := make(map[string]int)
+	for i, oid := range oids {
+		key := strings.TrimPrefix(normalizeOID(oid), ".")
+		if first, ok := seen[key]; ok {
+			if x.DuplicateOids == DuplicateOidsReject {
+				return nil, fmt.Errorf("duplicate oid %s at positions %d and %d", oid, first, i)
+			}
+			positions[i] = positions[first]
+			continue
+		}
+		seen[key] = i
+		positions[i] = len(unique)
+		unique = append(unique, oid)
+	}
+
+	var pdus []SnmpPDU
+	for _, oid := range unique {
+		pdus = append(pdus, SnmpPDU{x.rewriteOID(oid), Null, nil})
+	}
+	packetOut := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
+	packetOut.Community = community
+	result, err = x.send(packetOut, true)
+	if err == nil {
+		err = x.checkMissingVarbinds(pdus, result)
+	}
+	if err != nil || len(unique) == len(oids) {
+		return result, err
+	}
+
+	// error-index is 1-based and refers to the request sent; point it at
+	// the first matching position of the caller's oids.
+	if result.ErrorIndex > 0 {
+		for i, pos := range positions {
+			if pos == int(result.ErrorIndex)-1 {
+				result.ErrorIndex = uint8(i + 1)
+				break
+			}
+		}
+	}
+
+	if len(result.Variables) == len(unique) {
+		expanded := make([]SnmpPDU, len(oids))
+		for i, pos := range positions {
+			expanded[i] = result.Variables[pos]
+		}
+		result.Variables = expanded
+	}
+	return result, nil
 }
 
 // Set sends an SNMP SET request
 func (x *GoSNMP) Set(pdus []SnmpPDU) (result *SnmpPacket, err error) {
 	var packetOut *SnmpPacket
-	switch pdus[0].Type {
-	// TODO test Gauge32
-	case Integer, OctetString, Gauge32, IPAddress:
+	if isSettable(pdus[0].Type) {
 		packetOut = x.mkSnmpPacket(SetRequest, pdus, 0, 0)
-	default:
-		return nil, fmt.Errorf("ERR:gosnmp currently only supports SNMP SETs for Integers, IPAddress and OctetStrings")
+	} else {
+		return nil, fmt.Errorf("ERR:gosnmp currently only supports SNMP SETs for Integers, IPAddress, OctetStrings and ObjectIdentifiers")
 	}
 	return x.send(packetOut, true)
 }
 
+// SetChunked sends updates in as many SET requests as needed for each to
+// carry at most MaxOids varbinds, waiting ChunkDelay between them, and
+// returns the response to each request in order.
+//
+// Unlike a single Set, the updates are not applied atomically: each request
+// succeeds or fails on its own. SetChunked stops at the first request that
+// fails or that the agent responds to with an error status, returning the
+// responses so far, including that one, and an error. The updates of the
+// earlier requests have been applied and are not rolled back.
+func (x *GoSNMP) SetChunked(updates []SnmpPDU) ([]*SnmpPacket, error) {
+	maxOids := x.MaxOids
+	if maxOids <= 0 {
+		maxOids = MaxOids
+	}
+
+	var results []*SnmpPacket
+	for start := 0; start < len(updates); start += maxOids {
+		end := start + maxOids
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if start > 0 {
+			if err := x.sleep(x.ChunkDelay); err != nil {
+				return results, err
+			}
+		}
+		result, err := x.Set(updates[start:end])
+		if err != nil {
+			return results, fmt.Errorf("set of varbinds %d to %d: %w", start, end-1, err)
+		}
+		results = append(results, result)
+		if result.Error != NoError {
+			return results, fmt.Errorf("set of varbinds %d to %d: %s at index %d",
+				start, end-1, result.Error, result.ErrorIndex)
+		}
+	}
+	return results, nil
+}
+
+// GetChunked gets oids in as many GET requests as needed for each to carry
+// at most MaxOids OIDs, waiting ChunkDelay between them, and returns the
+// response to each request in order. GetChunked stops at the first request
+// that fails or that the agent responds to with an error status, returning
+// the responses so far, including that one, and an error.
+func (x *GoSNMP) GetChunked(oids []string) ([]*SnmpPacket, error) {
+	maxOids := x.MaxOids
+	if maxOids <= 0 {
+		maxOids = MaxOids
+	}
+
+	var results []*SnmpPacket
+	for start := 0; start < len(oids); start += maxOids {
+		end := start + maxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		if start > 0 {
+			if err := x.sleep(x.ChunkDelay); err != nil {
+				return results, err
+			}
+		}
+		result, err := x.Get(oids[start:end])
+		if err != nil {
+			return results, fmt.Errorf("get of oids %d to %d: %w", start, end-1, err)
+		}
+		results = append(results, result)
+		if result.Error != NoError {
+			return results, fmt.Errorf("get of oids %d to %d: %s at index %d",
+				start, end-1, result.Error, result.ErrorIndex)
+		}
+	}
+	return results, nil
+}
+
+// sleep waits for d, returning early with the error of Context if it is
+// done first.
+func (x *GoSNMP) sleep(d time.Duration) error {
+	ctx := x.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// loggedCommunity returns community as it is to appear in logs, see
+// RedactCommunity.
+func (x *GoSNMP) loggedCommunity(community string) string {
+	if !x.RedactCommunity || community == "" {
+		return community
+	}
+	return community[:1] + strings.Repeat("*", len(community)-1)
+}
+
+// rewriteOID strips the leading zeros of the sub-identifiers of an outgoing
+// OID, so that it matches the OIDs of responses, and applies OIDRewrite if
+// set.
+func (x *GoSNMP) rewriteOID(oid string) string {
+	oid = normalizeOID(oid)
+	if x.OIDRewrite == nil {
+		return oid
+	}
+	return x.OIDRewrite(oid)
+}
+
+// isSettable reports whether Set supports values of type t.
+func isSettable(t Asn1BER) bool {
+	switch t {
+	// TODO test Gauge32
+	case Integer, OctetString, Gauge32, IPAddress, ObjectIdentifier:
+		return true
+	}
+	return false
+}
+
+// ValidateSet checks that updates would make a valid SET request, without
+// sending it: every varbind must be of a type Set supports, have a valid OID
+// and a value that marshals as its type. The request is marshalled and the
+// bytes discarded. For SNMPV3 only the PDU is marshalled, as encryption
+// needs the keys of a connection that has discovered the engine.
+func (x *GoSNMP) ValidateSet(updates []SnmpPDU) error {
+	if len(updates) == 0 {
+		return fmt.Errorf("no varbinds to set")
+	}
+	maxOids := x.MaxOids
+	if maxOids == 0 {
+		maxOids = MaxOids
+	}
+	if len(updates) > maxOids {
+		return fmt.Errorf("oid count (%d) is greater than MaxOids (%d)", len(updates), maxOids)
+	}
+	for i, pdu := range updates {
+		if !isSettable(pdu.Type) {
+			return fmt.Errorf("varbind %d (%s): SNMP SETs of type %v are not supported", i, pdu.Name, pdu.Type)
+		}
+	}
+
+	packetOut := x.mkSnmpPacket(SetRequest, updates, 0, 0)
+	var err error
+	if x.Version == Version3 {
+		_, err = packetOut.marshalPDU()
+	} else {
+		_, err = packetOut.marshalMsg()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid SET request: %w", err)
+	}
+	return nil
+}
+
 // GetNext sends an SNMP GETNEXT request
 func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
 	oidCount := len(oids)
@@ -424,7 +1246,7 @@ func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
 	// convert oids slice to pdu slice
 	var pdus []SnmpPDU
 	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+		pdus = append(pdus, SnmpPDU{x.rewriteOID(oid), Null, nil})
 	}
 
 	// Marshal and send the packet
@@ -436,10 +1258,19 @@ func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
 // GetBulk sends an SNMP GETBULK request
 //
 // For maxRepetitions greater than 255, use BulkWalk() or BulkWalkAll()
+//
+// A maxRepetitions of 0 uses x.MaxRepetitions, or DefaultMaxRepetitions if
+// that is not set either.
 func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint32) (result *SnmpPacket, err error) {
 	if x.Version == Version1 {
 		return nil, fmt.Errorf("GETBULK not supported in SNMPv1")
 	}
+	if maxRepetitions == 0 {
+		maxRepetitions = x.MaxRepetitions
+	}
+	if maxRepetitions == 0 {
+		maxRepetitions = DefaultMaxRepetitions
+	}
 	oidCount := len(oids)
 	if oidCount > x.MaxOids {
 		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
@@ -449,7 +1280,7 @@ func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint3
 	// convert oids slice to pdu slice
 	var pdus []SnmpPDU
 	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+		pdus = append(pdus, SnmpPDU{x.rewriteOID(oid), Null, nil})
 	}
 
 	// Marshal and send the packet
@@ -457,6 +1288,122 @@ func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint3
 	return x.send(packetOut, true)
 }
 
+// GetBulkMap sends an SNMP GETBULK request like GetBulk and returns the
+// response variables keyed by OID. If the agent returns the same OID more than
+// once the last value wins and a warning is logged.
+func (x *GoSNMP) GetBulkMap(oids []string, nonRepeaters uint8, maxReps uint8) (map[string]SnmpPDU, error) {
+	result, err := x.GetBulk(oids, nonRepeaters, uint32(maxReps))
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != NoError {
+		return nil, fmt.Errorf("GetBulk returned error status %s at index %d", result.Error, result.ErrorIndex)
+	}
+
+	vars := make(map[string]SnmpPDU, len(result.Variables))
+	for _, pdu := range result.Variables {
+		if _, ok := vars[pdu.Name]; ok {
+			x.Logger.Printf("WARNING GetBulkMap duplicate OID %s in response, keeping last value", pdu.Name)
+		}
+		vars[pdu.Name] = pdu
+	}
+	return vars, nil
+}
+
+// GetBulkN returns up to the first n values in the subtree under oid, eg the
+// first n entries of a table, with a single GETBULK of max-repetitions n
+// rather than a walk. Fewer are returned when the subtree ends sooner.
+func (x *GoSNMP) GetBulkN(oid string, n uint8) ([]SnmpPDU, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(oid, ".") {
+		oid = "." + oid
+	}
+	result, err := x.GetBulk([]string{oid}, 0, uint32(n))
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != NoError {
+		return nil, fmt.Errorf("GetBulk returned error status %s at index %d", result.Error, result.ErrorIndex)
+	}
+
+	var pdus []SnmpPDU
+	for _, pdu := range result.Variables {
+		if len(pdus) == int(n) || !strings.HasPrefix(pdu.Name, oid+".") {
+			break
+		}
+		switch pdu.Type {
+		case EndOfMibView, NoSuchObject, NoSuchInstance:
+			return pdus, nil
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus, nil
+}
+
+// GetScalar retrieves the single value of a scalar object. It sends a GETNEXT
+// for oid and accepts the result if it is the instance oid.0; otherwise it
+// sends a GET for the bare oid, for agents that expose scalars without the
+// ".0" instance suffix. An error is returned if neither form exists, for
+// example when oid is a table or a subtree.
+//
+// This avoids relying on the leaf detection in Walk() and BulkWalk().
+func (x *GoSNMP) GetScalar(oid string) (result SnmpPDU, err error) {
+	if !strings.HasPrefix(oid, ".") {
+		oid = "." + oid
+	}
+
+	response, err := x.GetNext([]string{oid})
+	if err != nil {
+		return result, err
+	}
+	if response.Error == NoError && len(response.Variables) == 1 {
+		pdu := response.Variables[0]
+		if pdu.Name == oid+".0" && pdu.Type != EndOfMibView {
+			return pdu, nil
+		}
+	}
+
+	response, err = x.Get([]string{oid})
+	if err != nil {
+		return result, err
+	}
+	if response.Error == NoError && len(response.Variables) == 1 {
+		pdu := response.Variables[0]
+		switch pdu.Type {
+		case NoSuchObject, NoSuchInstance, EndOfMibView:
+		default:
+			return pdu, nil
+		}
+	}
+	return result, fmt.Errorf("no scalar value found for %s or %s.0", oid, oid)
+}
+
+// SendWithMsgFlags sends a single SNMPv3 request using the security level in
+// flags instead of x.MsgFlags, e.g. to issue one AuthPriv SET on a connection
+// that otherwise polls with AuthNoPriv. The connection is not reconfigured;
+// the SecurityParameters must support the requested level.
+func (x *GoSNMP) SendWithMsgFlags(pdutype PDUType, pdus []SnmpPDU, nonRepeaters uint8, maxRepetitions uint32, flags SnmpV3MsgFlags) (*SnmpPacket, error) {
+	if x.Version != Version3 {
+		return nil, fmt.Errorf("MsgFlags override requires SNMPv3, connection is %s", x.Version)
+	}
+	if x.SecurityParameters == nil {
+		return nil, fmt.Errorf("MsgFlags override requires SecurityParameters")
+	}
+	if err := x.SecurityParameters.validate(flags); err != nil {
+		return nil, fmt.Errorf("security parameters do not support MsgFlags %#x: %w", uint8(flags), err)
+	}
+	if len(pdus) > x.MaxOids {
+		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
+			len(pdus), x.MaxOids)
+	}
+
+	packetOut := x.mkSnmpPacket(pdutype, pdus, nonRepeaters, maxRepetitions)
+	packetOut.MsgFlags = flags&AuthPriv | Reportable
+	return x.send(packetOut, true)
+}
+
 // SnmpEncodePacket exposes SNMP packet generation to external callers.
 // This is useful for generating traffic for use over separate transport
 // stacks and creating traffic samples for test purposes.
@@ -495,7 +1442,8 @@ func (x *GoSNMP) SnmpEncodePacket(pdutype PDUType, pdus []SnmpPDU, nonRepeaters
 
 // SnmpDecodePacket exposes SNMP packet parsing to external callers.
 // This is useful for processing traffic from other sources and
-// building test harnesses.
+// building test harnesses. Requests, eg a Set received by an agent, are
+// decoded like responses: each Value has the Go type for its Type.
 func (x *GoSNMP) SnmpDecodePacket(resp []byte) (*SnmpPacket, error) {
 	var err error
 
@@ -534,6 +1482,32 @@ func (x *GoSNMP) SnmpDecodePacket(resp []byte) (*SnmpPacket, error) {
 	return result, nil
 }
 
+// SnmpDecodePackets is like SnmpDecodePacket, but if ConcatenatedMessages is
+// set decodes every SNMP message in resp rather than only one.
+func (x *GoSNMP) SnmpDecodePackets(resp []byte) ([]*SnmpPacket, error) {
+	if !x.ConcatenatedMessages {
+		result, err := x.SnmpDecodePacket(resp)
+		if err != nil {
+			return nil, err
+		}
+		return []*SnmpPacket{result}, nil
+	}
+
+	msgs, err := splitMessages(resp)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*SnmpPacket, 0, len(msgs))
+	for i, msg := range msgs {
+		result, err := x.SnmpDecodePacket(msg)
+		if err != nil {
+			return results, fmt.Errorf("unable to decode message %d: %w", i, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 // SetRequestID sets the base ID value for future requests
 func (x *GoSNMP) SetRequestID(reqID uint32) {
 	x.requestID = reqID & 0x7fffffff
@@ -549,7 +1523,8 @@ func (x *GoSNMP) SetMsgID(msgID uint32) {
 //
 
 // WalkFunc is the type of the function called for each data unit visited
-// by the Walk function.  If an error is returned processing stops.
+// by the Walk function.  If an error is returned processing stops, and the
+// walk returns the error unless it is ErrStopWalk.
 type WalkFunc func(dataUnit SnmpPDU) error
 
 // BulkWalk retrieves a subtree of values using GETBULK. As the tree is
@@ -557,7 +1532,48 @@ type WalkFunc func(dataUnit SnmpPDU) error
 // an error if either there is an underlaying SNMP error (e.g. GetBulk fails),
 // or if walkFn returns an error.
 func (x *GoSNMP) BulkWalk(rootOid string, walkFn WalkFunc) error {
-	return x.walk(GetBulkRequest, rootOid, walkFn)
+	return x.BulkWalkWithContext(x.Context, rootOid, walkFn)
+}
+
+// BulkWalkWithContext is BulkWalk using ctx in place of x.Context: the walk
+// returns ctx.Err() once ctx is done, and ctx's deadline bounds each
+// request. A nil ctx leaves x.Context in effect, as for WalkOptions.Context.
+// x must not be used concurrently.
+func (x *GoSNMP) BulkWalkWithContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	return x.BulkWalkOpts(rootOid, WalkOptions{Context: ctx}, walkFn)
+}
+
+// BulkWalkBatches walks like BulkWalk, but calls fn once per GETBULK
+// response with the PDUs of the walk it held, and the number of the request,
+// from 1. This shows how the agent paginates the subtree, eg whether it
+// honours MaxRepetitions. As for a WalkFunc, fn returning ErrStopWalk ends
+// the walk without error.
+func (x *GoSNMP) BulkWalkBatches(rootOid string, fn func(batch []SnmpPDU, requestNum int) error) error {
+	var batch []SnmpPDU
+	batchNum := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := fn(batch, batchNum)
+		batch = nil
+		return err
+	}
+
+	defer func(saved func(int) error) { x.walkResponse = saved }(x.walkResponse)
+	x.walkResponse = func(requestNum int) error {
+		err := flush()
+		batchNum = requestNum
+		return err
+	}
+	err := x.walkThrottled(GetBulkRequest, rootOid, x.MaxRepetitions, 0, false, func(pdu SnmpPDU) error {
+		batch = append(batch, pdu)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return x.walkStopped(flush(), batchNum)
 }
 
 // BulkWalkAll is similar to BulkWalk but returns a filled array of all values
@@ -568,12 +1584,101 @@ func (x *GoSNMP) BulkWalkAll(rootOid string) (results []SnmpPDU, err error) {
 	return x.walkAll(GetBulkRequest, rootOid)
 }
 
+// BulkWalkThrottled is like BulkWalk, but gentler on a loaded agent: each
+// GETBULK fetches at most blockSize values, and it sleeps for pause between
+// requests. The walk is aborted with the context's error if x.Context is
+// cancelled during a pause.
+func (x *GoSNMP) BulkWalkThrottled(rootOid string, blockSize uint32, pause time.Duration, walkFn WalkFunc) error {
+	return x.BulkWalkOpts(rootOid, WalkOptions{MaxRepetitions: blockSize, Pause: pause}, walkFn)
+}
+
+// WalkOptions are the tunables of a BulkWalkOpts walk. Zero values leave the
+// corresponding GoSNMP settings in effect.
+type WalkOptions struct {
+	// MaxRepetitions is the GETBULK max-repetitions, see
+	// GoSNMP.MaxRepetitions.
+	MaxRepetitions uint32
+
+	// NonRepeaters is the GETBULK non-repeaters, see GoSNMP.NonRepeaters.
+	NonRepeaters int
+
+	// Context if set, is used for the requests of the walk in place of
+	// GoSNMP.Context.
+	Context context.Context
+
+	// Timeout if positive, is the timeout of each request of the walk in
+	// place of GoSNMP.Timeout.
+	Timeout time.Duration
+
+	// Pause is the time to sleep between requests, see BulkWalkThrottled.
+	Pause time.Duration
+
+	// MaxRows if positive, ends the walk once walkFn was called with
+	// MaxRows values.
+	MaxRows int
+
+	// Filter if set, is called for each value and only those it returns
+	// true for are passed to walkFn.
+	Filter func(SnmpPDU) bool
+}
+
+// BulkWalkOpts is BulkWalk with the tunables of opts. The GoSNMP settings
+// overridden by opts are restored when it returns, so x must not be used
+// concurrently.
+func (x *GoSNMP) BulkWalkOpts(rootOid string, opts WalkOptions, walkFn WalkFunc) error {
+	if opts.NonRepeaters != 0 {
+		defer func(nonRepeaters int) { x.NonRepeaters = nonRepeaters }(x.NonRepeaters)
+		x.NonRepeaters = opts.NonRepeaters
+	}
+	if opts.Context != nil {
+		defer func(ctx context.Context) { x.Context = ctx }(x.Context)
+		x.Context = opts.Context
+	}
+	if opts.Timeout > 0 {
+		defer func(timeout time.Duration) { x.Timeout = timeout }(x.Timeout)
+		x.Timeout = opts.Timeout
+	}
+	maxReps := opts.MaxRepetitions
+	if maxReps == 0 {
+		maxReps = x.MaxRepetitions
+	}
+
+	rows := 0
+	err := x.walkThrottled(GetBulkRequest, rootOid, maxReps, opts.Pause, x.ReuseWalkBuffers, func(pdu SnmpPDU) error {
+		if opts.Filter != nil && !opts.Filter(pdu) {
+			return nil
+		}
+		if err := walkFn(pdu); err != nil {
+			return err
+		}
+		if rows++; opts.MaxRows > 0 && rows >= opts.MaxRows {
+			return errMaxRows
+		}
+		return nil
+	})
+	if err == errMaxRows {
+		return nil
+	}
+	return err
+}
+
 // Walk retrieves a subtree of values using GETNEXT - a request is made for each
 // value, unlike BulkWalk which does this operation in batches. As the tree is
 // walked walkFn is called for each new value. The function immediately returns
 // an error if either there is an underlaying SNMP error (e.g. GetNext fails),
 // or if walkFn returns an error.
 func (x *GoSNMP) Walk(rootOid string, walkFn WalkFunc) error {
+	return x.WalkWithContext(x.Context, rootOid, walkFn)
+}
+
+// WalkWithContext is Walk using ctx in place of x.Context: the walk returns
+// ctx.Err() once ctx is done, and ctx's deadline bounds each request. A nil
+// ctx leaves x.Context in effect. x must not be used concurrently.
+func (x *GoSNMP) WalkWithContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	if ctx != nil {
+		defer func(saved context.Context) { x.Context = saved }(x.Context)
+		x.Context = ctx
+	}
 	return x.walk(GetNextRequest, rootOid, walkFn)
 }
 
@@ -585,6 +1690,69 @@ func (x *GoSNMP) WalkAll(rootOid string) (results []SnmpPDU, err error) {
 	return x.walkAll(GetNextRequest, rootOid)
 }
 
+// WalkDepth walks the subtree under rootOid like BulkWalk, or Walk for
+// SNMPv1, but only calls walkFn for values at most maxDepth arcs below
+// rootOid, eg a maxDepth of 1 for its direct children. Deeper values are
+// skipped while the walk carries on past them.
+func (x *GoSNMP) WalkDepth(rootOid string, maxDepth int, walkFn WalkFunc) error {
+	root := rootOid
+	if root == "" || root == "." {
+		root = baseOid
+	}
+	root = x.rewriteOID(root)
+	if !strings.HasPrefix(root, ".") {
+		root = "." + root
+	}
+
+	getRequestType := GetBulkRequest
+	if x.Version == Version1 {
+		getRequestType = GetNextRequest
+	}
+	return x.walk(getRequestType, rootOid, func(pdu SnmpPDU) error {
+		if strings.Count(strings.TrimPrefix(pdu.Name, root), ".") > maxDepth {
+			return nil
+		}
+		return walkFn(pdu)
+	})
+}
+
+// WalkRaw walks the subtree under rootOid like BulkWalk, or Walk for
+// SNMPv1, but without decoding the values: fn is called with the name, the
+// type and the raw contents of each value, which DecodeValue decodes, so
+// that values of no interest cost no allocation. The responses are decoded
+// into the same buffers, so raw is only valid until fn returns. As values
+// are left undecoded IntegerAsIPAddress does not apply, and WalkEndSentinel
+// is compared with the raw contents.
+func (x *GoSNMP) WalkRaw(rootOid string, fn func(name string, valueType Asn1BER, raw []byte) error) error {
+	defer func(saved bool) { x.rawValues = saved }(x.rawValues)
+	x.rawValues = true
+
+	getRequestType := GetBulkRequest
+	if x.Version == Version1 {
+		getRequestType = GetNextRequest
+	}
+	return x.walkThrottled(getRequestType, rootOid, x.MaxRepetitions, 0, true, func(pdu SnmpPDU) error {
+		raw, _ := pdu.Value.([]byte)
+		return fn(pdu.Name, pdu.Type, raw)
+	})
+}
+
+// DecodeValue decodes raw, the contents of a value of type valueType as
+// passed by WalkRaw, into what would be the Value of its SnmpPDU.
+func (x *GoSNMP) DecodeValue(valueType Asn1BER, raw []byte) (interface{}, error) {
+	length, err := marshalLength(len(raw))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, 1+len(length)+len(raw))
+	data = append(append(append(data, byte(valueType)), length...), raw...)
+	var decoded variable
+	if err = x.decodeValue(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded.Value, nil
+}
+
 //
 // Public Functions (helpers) - in alphabetical order
 //
@@ -657,3 +1825,10 @@ func ToBigInt(value interface{}) *big.Int {
 	}
 	return big.NewInt(val)
 }
+
+// IntToIP converts an IPv4 address held in an integer in network byte order,
+// as some nonstandard agents return in place of an IpAddress, to a net.IP.
+// Use bits.ReverseBytes32 first for an address in little-endian order.
+func IntToIP(v uint32) net.IP {
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
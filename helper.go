@@ -54,6 +54,37 @@ func Check(err error) {
 	}
 }
 
+// opaqueTimeTicks is the Opaque sub-tag some vendors use to wrap a TimeTicks,
+// following the net-snmp convention of 0x30 plus the wrapped type used for
+// OpaqueFloat and OpaqueDouble. It is decoded as a TimeTicks.
+const opaqueTimeTicks = 0x30 + TimeTicks
+
+// opaqueCounter64 is net-snmp's ASN_OPAQUE_COUNTER64 sub-tag, used by some
+// optical transport MIBs to carry 64 bit counters in an Opaque. It is
+// decoded as a Counter64.
+const opaqueCounter64 = 0x30 + Counter64
+
+// rawValue sets retVal to the type and the undecoded contents of the value
+// in data, see WalkRaw.
+func rawValue(data []byte, retVal *variable) error {
+	if len(data) == 0 {
+		return errors.New("zero byte buffer")
+	}
+	if data[0]&AsnExtensionID == AsnExtensionID {
+		if len(data) < 2 {
+			return fmt.Errorf("bytes: % x err: truncated (data %d length %d)", data, len(data), 2)
+		}
+		data = data[1:]
+	}
+	length, cursor := parseLength(data)
+	if length > len(data) || cursor > length {
+		return fmt.Errorf("bytes: % x err: truncated (data %d length %d)", data, len(data), length)
+	}
+	retVal.Type = Asn1BER(data[0])
+	retVal.Value = data[cursor:length]
+	return nil
+}
+
 func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 	if len(data) == 0 {
 		return errors.New("zero byte buffer")
@@ -128,13 +159,12 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 				return fmt.Errorf("not enough data for ipv4 address: %x", data)
 			}
 			retVal.Value = net.IPv4(data[2], data[3], data[4], data[5]).String()
-		case 16: // IPv6
+		case 16: // IPv6 like, but IpAddress is IPv4 only (RFC 2578)
 			if len(data) < 18 {
 				return fmt.Errorf("not enough data for ipv6 address: %x", data)
 			}
-			d := make(net.IP, 16)
-			copy(d, data[2:17])
-			retVal.Value = d.String()
+			x.Logger.Printf("WARNING decodeValue: IPAddress of 16 bytes, returned as raw bytes: %x", data[2:18])
+			retVal.Value = append([]byte(nil), data[2:18]...)
 		default:
 			return fmt.Errorf("got ipaddress len %d, expected 4 or 16", data[1])
 		}
@@ -168,8 +198,8 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 		}
 		retVal.Type = Gauge32
 		retVal.Value = ret
-	case TimeTicks:
-		// 0x43
+	case TimeTicks, opaqueTimeTicks:
+		// 0x43, or 0x9f 0x73 within an Opaque
 		x.Logger.Print("decodeValue: type is TimeTicks")
 		length, cursor := parseLength(data)
 		if length > len(data) {
@@ -194,8 +224,8 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 		opaqueData := data[cursor:length]
 		// recursively decode opaque data
 		return x.decodeValue(opaqueData, retVal)
-	case Counter64:
-		// 0x46
+	case Counter64, opaqueCounter64:
+		// 0x46, or 0x9f 0x76 within an Opaque
 		x.Logger.Print("decodeValue: type is Counter64")
 		length, cursor := parseLength(data)
 		if length > len(data) {
@@ -410,7 +440,7 @@ func marshalLength(length int) ([]byte, error) {
 	// more convenient to pass length as int than uint64. Therefore check < 0
 	if length < 0 {
 		return nil, fmt.Errorf("length must be greater than zero")
-	} else if length < 127 {
+	} else if length <= 127 {
 		return []byte{byte(length)}, nil
 	}
 
@@ -515,8 +545,13 @@ func parseBase128Int(bytes []byte, initOffset int) (ret int64, offset int, err e
 }
 
 // parseInt64 treats the given bytes as a big-endian, signed integer and
-// returns the result.
+// returns the result. Non-minimal encodings with redundant leading 0x00 or
+// 0xff octets, as emitted by some agents, are accepted.
 func parseInt64(bytes []byte) (ret int64, err error) {
+	for len(bytes) > 1 &&
+		(bytes[0] == 0x00 && bytes[1]&0x80 == 0 || bytes[0] == 0xff && bytes[1]&0x80 != 0) {
+		bytes = bytes[1:]
+	}
 	if len(bytes) > 8 {
 		// We'll overflow an int64 in this case.
 		err = errors.New("integer too large")
@@ -569,6 +604,11 @@ func parseLength(bytes []byte) (length int, cursor int) {
 		cursor += 2
 	default:
 		numOctets := int(bytes[1]) & 127
+		if 2+numOctets > len(bytes) {
+			// truncated length octets, report more bytes than available
+			// so the caller's length check fails
+			return len(bytes) + 1, len(bytes)
+		}
 		for i := 0; i < numOctets; i++ {
 			length <<= 8
 			length += int(bytes[2+i])
@@ -114,6 +114,26 @@ func TestMarshalInt32(t *testing.T) {
 	}
 }
 
+func TestParseIntNonMinimal(t *testing.T) {
+	tests := []struct {
+		data []byte
+		n    int
+	}{
+		{[]byte{0x00, 0x7f}, 127},
+		{[]byte{0xff, 0x80}, -128},
+		{[]byte{0x00, 0x00, 0x01}, 1},
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, 1},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, -2},
+		{[]byte{0x00, 0x80}, 128},
+		{[]byte{0xff, 0x7f}, -129},
+	}
+	for _, test := range tests {
+		ret, err := parseInt(test.data)
+		assert.NoErrorf(t, err, "% x", test.data)
+		assert.Equalf(t, test.n, ret, "% x", test.data)
+	}
+}
+
 func TestParseUint64(t *testing.T) {
 	tests := []struct {
 		data []byte
@@ -138,6 +158,22 @@ var testsInvalidSNMPResponses = []string{
 	"MEo=",
 }
 
+func TestDecodeIPAddress(t *testing.T) {
+	x := &GoSNMP{}
+
+	value, err := x.DecodeValue(IPAddress, []byte{192, 0, 2, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", value)
+
+	ipv6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	value, err = x.DecodeValue(IPAddress, ipv6)
+	assert.NoError(t, err)
+	assert.Equal(t, ipv6, value, "16 bytes are returned raw, last byte included")
+
+	_, err = x.DecodeValue(IPAddress, []byte{192, 0, 2, 1, 0})
+	assert.Error(t, err)
+}
+
 func TestInvalidSNMPResponses(t *testing.T) {
 
 	g := &GoSNMP{
@@ -0,0 +1,135 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	ifEntry       = ".1.3.6.1.2.1.2.2.1"
+	ifDescr       = ifEntry + ".2"
+	ifType        = ifEntry + ".3"
+	ifMtu         = ifEntry + ".4"
+	ifSpeed       = ifEntry + ".5"
+	ifAdminStatus = ifEntry + ".7"
+	ifOperStatus  = ifEntry + ".8"
+	ifInOctets    = ifEntry + ".10"
+	ifOutOctets   = ifEntry + ".16"
+
+	ifXEntry      = ".1.3.6.1.2.1.31.1.1.1"
+	ifName        = ifXEntry + ".1"
+	ifHCInOctets  = ifXEntry + ".6"
+	ifHCOutOctets = ifXEntry + ".10"
+	ifHighSpeed   = ifXEntry + ".15"
+)
+
+// ifTableColumns are the columns InterfaceTable walks, in order. The
+// ifXTable columns come last so that they override the ifTable ones.
+var ifTableColumns = []string{
+	ifDescr, ifType, ifMtu, ifSpeed, ifAdminStatus, ifOperStatus, ifInOctets, ifOutOctets,
+	ifName, ifHCInOctets, ifHCOutOctets, ifHighSpeed,
+}
+
+// Interface is a row of the agent's ifTable (IF-MIB), together with the
+// columns of its ifXTable if the agent has one.
+type Interface struct {
+	// Index is the ifIndex of the row.
+	Index int
+	// Descr is the ifDescr, eg the name of the interface and its hardware.
+	Descr string
+	// Name is the ifName from the ifXTable, empty if the agent has none.
+	Name string
+	// Type is the ifType, an IANAifType such as 6 for ethernetCsmacd.
+	Type int
+	// MTU is the ifMtu, the largest datagram the interface can send.
+	MTU int
+	// Speed is the bandwidth in bits per second: ifSpeed, or ifHighSpeed
+	// for interfaces faster than ifSpeed can express.
+	Speed uint64
+	// AdminStatus is the ifAdminStatus: 1 up, 2 down or 3 testing.
+	AdminStatus int
+	// OperStatus is the ifOperStatus: 1 up, 2 down, 3 testing, 4 unknown,
+	// 5 dormant, 6 notPresent or 7 lowerLayerDown.
+	OperStatus int
+	// InOctets and OutOctets are the 64 bit ifHCInOctets and ifHCOutOctets,
+	// or the 32 bit ifInOctets and ifOutOctets if the agent has no
+	// ifXTable.
+	InOctets  uint64
+	OutOctets uint64
+}
+
+// InterfaceTable returns the rows of the agent's ifTable, with the ifXTable
+// columns merged in, in table order. Only the columns of Interface are
+// walked, each with GETBULK, or GETNEXT for SNMPv1.
+func (x *GoSNMP) InterfaceTable() ([]Interface, error) {
+	var interfaces []Interface
+	rows := make(map[int]int) // ifIndex to interfaces index
+
+	for _, column := range ifTableColumns {
+		column := column
+		walkFn := func(pdu SnmpPDU) error {
+			if !strings.HasPrefix(pdu.Name, column+".") {
+				return nil
+			}
+			index, err := strconv.Atoi(pdu.Name[len(column)+1:])
+			if err != nil {
+				return fmt.Errorf("invalid ifTable index in %s: %w", pdu.Name, err)
+			}
+			row, ok := rows[index]
+			if !ok {
+				row = len(interfaces)
+				rows[index] = row
+				interfaces = append(interfaces, Interface{Index: index})
+			}
+			iface := &interfaces[row]
+
+			switch column {
+			case ifDescr:
+				if descr, ok := pdu.Value.([]byte); ok {
+					iface.Descr = string(descr)
+				}
+			case ifName:
+				if name, ok := pdu.Value.([]byte); ok {
+					iface.Name = string(name)
+				}
+			case ifType:
+				iface.Type = int(ToBigInt(pdu.Value).Int64())
+			case ifMtu:
+				iface.MTU = int(ToBigInt(pdu.Value).Int64())
+			case ifSpeed:
+				iface.Speed = ToBigInt(pdu.Value).Uint64()
+			case ifHighSpeed:
+				// ifSpeed saturates at 4294967295 for faster interfaces
+				if iface.Speed == math.MaxUint32 {
+					iface.Speed = ToBigInt(pdu.Value).Uint64() * 1000000
+				}
+			case ifAdminStatus:
+				iface.AdminStatus = int(ToBigInt(pdu.Value).Int64())
+			case ifOperStatus:
+				iface.OperStatus = int(ToBigInt(pdu.Value).Int64())
+			case ifInOctets, ifHCInOctets:
+				iface.InOctets = ToBigInt(pdu.Value).Uint64()
+			case ifOutOctets, ifHCOutOctets:
+				iface.OutOctets = ToBigInt(pdu.Value).Uint64()
+			}
+			return nil
+		}
+
+		var err error
+		if x.Version == Version1 {
+			err = x.Walk(column, walkFn)
+		} else {
+			err = x.BulkWalk(column, walkFn)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return interfaces, nil
+}
@@ -334,6 +334,5 @@ func (x *snmpHandler) SetContextName(contextName string) {
 }
 
 func (x *snmpHandler) Close() error {
-	// not x.Conn for consistency
-	return x.GoSNMP.Conn.Close()
+	return x.GoSNMP.Close()
 }
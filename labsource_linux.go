@@ -0,0 +1,112 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// labSourceConn is a send only net.Conn writing UDP datagrams with a
+// spoofed source address through a raw socket, see GoSNMP.LabSourceAddr.
+type labSourceConn struct {
+	fd       int
+	src, dst *net.UDPAddr
+}
+
+// dialLabSource opens a raw socket sending UDP datagrams from src to dst.
+func dialLabSource(src, dst *net.UDPAddr) (net.Conn, error) {
+	if src.IP.To4() == nil || dst.IP.To4() == nil {
+		return nil, errors.New("LabSourceAddr only supports IPv4")
+	}
+	if src.Port <= 0 || src.Port > 65535 {
+		return nil, fmt.Errorf("LabSourceAddr port %d is not in the range 1 to 65535", src.Port)
+	}
+	// IPPROTO_RAW implies IP_HDRINCL: the IP header is written by us
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return nil, fmt.Errorf("opening raw socket for LabSourceAddr: %w", err)
+	}
+	return &labSourceConn{fd: fd, src: src, dst: dst}, nil
+}
+
+// Write sends b as the payload of a UDP datagram.
+func (c *labSourceConn) Write(b []byte) (int, error) {
+	const ipHeaderLen, udpHeaderLen = 20, 8
+	total := ipHeaderLen + udpHeaderLen + len(b)
+	if total > 65535 {
+		return 0, fmt.Errorf("datagram of %d bytes is too large", total)
+	}
+	src, dst := c.src.IP.To4(), c.dst.IP.To4()
+
+	pkt := make([]byte, total)
+	ip := pkt[:ipHeaderLen]
+	ip[0] = 0x45 // version 4, 5 word header
+	binary.BigEndian.PutUint16(ip[2:], uint16(total))
+	ip[8] = 64 // TTL
+	ip[9] = syscall.IPPROTO_UDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	// the kernel fills in the identification and header checksum
+
+	udp := pkt[ipHeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:], uint16(c.src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(c.dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(b)))
+	copy(udp[udpHeaderLen:], b)
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum(src, dst, udp))
+
+	sa := &syscall.SockaddrInet4{}
+	copy(sa.Addr[:], dst)
+	if err := syscall.Sendto(c.fd, pkt, 0, sa); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// udpChecksum returns the checksum of the UDP datagram udp, whose checksum
+// field is zero, over the IPv4 pseudo header (RFC 768).
+func udpChecksum(src, dst net.IP, udp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += syscall.IPPROTO_UDP + uint32(len(udp))
+	add(udp)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	if checksum := ^uint16(sum); checksum != 0 {
+		return checksum
+	}
+	return 0xffff
+}
+
+// Read fails, the connection can only send.
+func (c *labSourceConn) Read(b []byte) (int, error) {
+	return 0, errors.New("a LabSourceAddr connection can only send")
+}
+
+func (c *labSourceConn) Close() error {
+	return syscall.Close(c.fd)
+}
+
+func (c *labSourceConn) LocalAddr() net.Addr  { return c.src }
+func (c *labSourceConn) RemoteAddr() net.Addr { return c.dst }
+
+func (c *labSourceConn) SetDeadline(t time.Time) error      { return nil }
+func (c *labSourceConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *labSourceConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,70 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+// +build all trap
+
+package gosnmp
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLabSourceAddr(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer conn.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 99), Port: 4242}
+	x := &GoSNMP{
+		Target:        "127.0.0.1",
+		Port:          uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Version:       Version2c,
+		Community:     "public",
+		Timeout:       time.Second,
+		LabSourceAddr: src,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	err = x.Connect()
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("raw sockets need root or CAP_NET_RAW")
+	}
+	if err != nil {
+		t.Fatalf("Connect() err: %v", err)
+	}
+	defer x.Conn.Close()
+
+	trap := SnmpTrap{
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.1"},
+		},
+	}
+	if _, err = x.SendTrap(trap); err != nil {
+		t.Fatalf("SendTrap() err: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, rxBufSize)
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("ReadFromUDP() err: %v", err)
+	}
+	if !from.IP.Equal(src.IP) || from.Port != src.Port {
+		t.Errorf("datagram from %v, expected %v", from, src)
+	}
+	packet, err := x.SnmpDecodePacket(buf[:n])
+	if err != nil {
+		t.Fatalf("SnmpDecodePacket() err: %v", err)
+	}
+	if packet.PDUType != SNMPv2Trap || len(packet.Variables) != 2 {
+		t.Errorf("decoded %v with %d variables, expected a SNMPv2Trap with 2", packet.PDUType, len(packet.Variables))
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package gosnmp
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// dialLabSource is not supported on this platform, see
+// GoSNMP.LabSourceAddr.
+func dialLabSource(src, dst *net.UDPAddr) (net.Conn, error) {
+	return nil, fmt.Errorf("LabSourceAddr is not supported on %s", runtime.GOOS)
+}
@@ -10,3 +10,6 @@ func (l *Logger) Print(v ...interface{}) {
 
 func (l *Logger) Printf(format string, v ...interface{}) {
 }
+
+func (l *Logger) Logf(level LogLevel, format string, v ...interface{}) {
+}
@@ -13,3 +13,11 @@ func (l *Logger) Printf(format string, v ...interface{}) {
 		l.logger.Printf(format, v...)
 	}
 }
+
+func (l *Logger) Logf(level LogLevel, format string, v ...interface{}) {
+	if leveled, ok := l.logger.(LeveledLoggerInterface); ok {
+		leveled.Logf(level, format, v...)
+	} else if l.logger != nil {
+		l.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+// +build all misc
+// +build !gosnmp_nodebug
+
+package gosnmp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testLeveledLogger records messages at or above its level.
+type testLeveledLogger struct {
+	level    LogLevel
+	messages map[LogLevel][]string
+}
+
+func (l *testLeveledLogger) Print(v ...interface{}) {}
+
+func (l *testLeveledLogger) Printf(format string, v ...interface{}) {}
+
+func (l *testLeveledLogger) Logf(level LogLevel, format string, v ...interface{}) {
+	if level >= l.level {
+		l.messages[level] = append(l.messages[level], fmt.Sprintf(format, v...))
+	}
+}
+
+func TestLogSecurity(t *testing.T) {
+	logger := &testLeveledLogger{level: LogLevelInfo, messages: make(map[LogLevel][]string)}
+	sp := &UsmSecurityParameters{
+		UserName:                 "usr-sha-aes",
+		AuthoritativeEngineID:    authorativeEngineID(t),
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authkey1",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "privkey1",
+		Logger:                   NewLogger(logger),
+	}
+
+	sp.LogSecurity(LogLevelDebug)
+	require.Empty(t, logger.messages)
+
+	sp.LogSecurity(LogLevelInfo)
+	require.Len(t, logger.messages[LogLevelInfo], 1)
+	msg := logger.messages[LogLevelInfo][0]
+	require.Contains(t, msg, "user=usr-sha-aes")
+	require.Contains(t, msg, "auth=SHA")
+	require.NotContains(t, msg, "authkey1")
+	require.NotContains(t, msg, "privkey1")
+}
+
+func TestRedactCommunity(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	x := &GoSNMP{
+		Target:          "127.0.0.1",
+		Port:            uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Version:         Version2c,
+		Community:       "secret",
+		Timeout:         50 * time.Millisecond,
+		Retries:         0,
+		RedactCommunity: true,
+		Logger:          NewLogger(log.New(&buf, "", 0)),
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	// the listener never answers, only the request is of interest
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.Error(t, err)
+
+	packet := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(packet)
+	require.NoError(t, err)
+	decoded, err := x.SnmpDecodePacket(packet[:n])
+	require.NoError(t, err)
+	require.Equal(t, "secret", decoded.Community)
+
+	logged := buf.String()
+	require.Contains(t, logged, "SENDING PACKET")
+	require.Contains(t, logged, "Parsed community s*****")
+	require.Equal(t, 0, strings.Count(logged, "secret"), logged)
+}
@@ -56,6 +56,35 @@ type SnmpPacket struct {
 	Variables          []SnmpPDU
 	Logger             Logger
 
+	// VarbindOffsets locates each of Variables within the received packet.
+	// It is only populated when GoSNMP.RecordVarbindOffsets is set.
+	VarbindOffsets []VarbindOffset
+
+	// Retransmits is the number of times the request was sent again, after
+	// timeouts or recoverable reports, before this response was received.
+	Retransmits int
+
+	// SentMsgFlags are the SNMPV3 msgFlags the request was marshalled with,
+	// ie the security level it actually went out at, while MsgFlags are
+	// those of the response.
+	SentMsgFlags SnmpV3MsgFlags
+
+	// reuse, if set, holds buffers reused for the response to this
+	// request, see GoSNMP.ReuseWalkBuffers.
+	reuse *rxReuse
+
+	// size is the length in bytes of the received message.
+	size int
+
+	// rawScopedPDU, if set, is the plaintext ScopedPDU sent in place of
+	// one marshalled from the packet, see GoSNMP.WrapScopedPDU.
+	rawScopedPDU []byte
+
+	// keepRaw, if set on a request, has the response as received kept in
+	// raw, see GoSNMP.GetRaw.
+	keepRaw bool
+	raw     []byte
+
 	// v1 traps have a very different format from v2c and v3 traps.
 	//
 	// These fields are set via the SnmpTrap parameter to SendTrap().
@@ -76,6 +105,27 @@ type SnmpTrap struct {
 	GenericTrap  int
 	SpecificTrap int
 	Timestamp    uint
+
+	// These fields optionally set the sending engine's boots and time for
+	// SNMPv3 notifications, where the sender is the authoritative engine.
+	// When either is non-zero they override the values held in
+	// SecurityParameters for this trap only.
+	EngineBoots uint32
+	EngineTime  uint32
+}
+
+// VarbindOffset is the position of an encoded varbind within a received
+// packet, useful for proxies that rewrite packets in place. For SNMPv3
+// packets with privacy the offsets refer to the decrypted packet.
+type VarbindOffset struct {
+	// Offset is the index of the varbind's SEQUENCE tag.
+	Offset int
+
+	// HeaderLength is the number of bytes used by the tag and BER length.
+	HeaderLength int
+
+	// Length is the total encoded length, including the header.
+	Length int
 }
 
 // VarBind struct represents an SNMP Varbind.
@@ -117,17 +167,71 @@ const (
 
 var (
 	ErrDecryption            = errors.New("decryption error")
+	ErrEmptyEngineID         = errors.New("empty engine id")
+	ErrEngineDiscoveryFailed = errors.New("engine discovery failed")
 	ErrInvalidMsgs           = errors.New("invalid messages")
+	ErrMissingVarbinds       = errors.New("response is missing variable bindings")
+	ErrNotEncrypted          = errors.New("authPriv scoped pdu not encrypted")
 	ErrNotInTimeWindow       = errors.New("not in time window")
+	ErrRequestIDMismatch     = errors.New("request id mismatch")
+	ErrTruncatedResponse     = errors.New("truncated response")
 	ErrUnknownEngineID       = errors.New("unknown engine id")
 	ErrUnknownPDUHandlers    = errors.New("unknown pdu handlers")
 	ErrUnknownReportPDU      = errors.New("unknown report pdu")
 	ErrUnknownSecurityLevel  = errors.New("unknown security level")
 	ErrUnknownSecurityModels = errors.New("unknown security models")
 	ErrUnknownUsername       = errors.New("unknown username")
+	ErrWalkMaxBytes          = errors.New("walk exceeded WalkMaxBytes")
+	ErrWeakKeyDerivation     = errors.New("weak key derivation")
 	ErrWrongDigest           = errors.New("wrong digest")
 )
 
+// ReportError is the error returned for a Report PDU received in response to
+// an SNMPv3 request. It wraps the Err* variable for the report's OID, eg
+// ErrWrongDigest, so it can be tested for with errors.Is.
+type ReportError struct {
+	Err error
+	// OID is the name of the report's varbind, eg usmStatsWrongDigests.
+	OID string
+	// Count is the value of the report's counter, the number of such
+	// events the agent has seen.
+	Count uint64
+}
+
+func newReportError(err error, pdu SnmpPDU) *ReportError {
+	reportErr := &ReportError{Err: err, OID: pdu.Name}
+	switch pdu.Type {
+	case Counter32, Counter64, Gauge32, Integer, Uinteger32:
+		reportErr.Count = ToBigInt(pdu.Value).Uint64()
+	}
+	return reportErr
+}
+
+func (e *ReportError) Error() string {
+	return fmt.Sprintf("%v (report %s, count %d)", e.Err, e.OID, e.Count)
+}
+
+// Unwrap returns Err.
+func (e *ReportError) Unwrap() error {
+	return e.Err
+}
+
+// requestTimeoutError is the error returned when every attempt of a request
+// timed out. It wraps the last read error, so the timeout can be tested for
+// with errors.Is(err, os.ErrDeadlineExceeded).
+type requestTimeoutError struct {
+	retries int
+	err     error
+}
+
+func (e *requestTimeoutError) Error() string {
+	return fmt.Sprintf("request timeout (after %d retries)", e.retries)
+}
+
+func (e *requestTimeoutError) Unwrap() error {
+	return e.err
+}
+
 const rxBufSize = 65535 // max size of IPv4 & IPv6 packet
 
 // Logger is an interface used for debugging. Both Print and
@@ -154,16 +258,52 @@ func NewLogger(logger LoggerInterface) Logger {
 	}
 }
 
+// LogLevel is the severity of a message passed to a LeveledLoggerInterface.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// LeveledLoggerInterface may be implemented by the logger given to NewLogger,
+// eg an adapter for log/slog, to receive some messages with a level. Loggers
+// that only implement LoggerInterface receive them through Printf.
+type LeveledLoggerInterface interface {
+	LoggerInterface
+	Logf(level LogLevel, format string, v ...interface{})
+}
+
 // GoSNMP
 // send/receive one snmp request
 func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 	wait bool) (result *SnmpPacket, err error) {
-	allReqIDs := make([]uint32, 0, x.Retries+1)
-	// allMsgIDs := make([]uint32, 0, x.Retries+1) // unused
+	return x.sendOneRequestRetrying(packetOut, wait, x.Timeout, x.Retries)
+}
+
+// sendOneRequestRetrying is sendOneRequest with the given timeout and
+// number of retries in place of Timeout and Retries.
+func (x *GoSNMP) sendOneRequestRetrying(packetOut *SnmpPacket,
+	wait bool, timeout time.Duration, maxRetries int) (result *SnmpPacket, err error) {
+	allReqIDs := make([]uint32, 0, maxRetries+1)
+	// allMsgIDs := make([]uint32, 0, maxRetries+1) // unused
+
+	// holdingSlot is set while an attempt holds one of x.sendSlots.
+	holdingSlot := false
+	releaseSlot := func() {
+		if holdingSlot {
+			<-x.sendSlots
+			holdingSlot = false
+		}
+	}
+	defer releaseSlot()
 
-	timeout := x.Timeout
 	withContextDeadline := false
 	for retries := 0; ; retries++ {
+		// the previous attempt, if any, is no longer awaiting a response
+		releaseSlot()
 		if retries > 0 {
 			if x.OnRetry != nil {
 				x.OnRetry(x)
@@ -174,9 +314,9 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				err = context.DeadlineExceeded
 				break
 			}
-			if retries > x.Retries {
+			if retries > maxRetries {
 				if strings.Contains(err.Error(), "timeout") {
-					err = fmt.Errorf("request timeout (after %d retries)", retries-1)
+					err = &requestTimeoutError{retries: retries - 1, err: err}
 				}
 				break
 			}
@@ -192,6 +332,15 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			return nil, x.Context.Err()
 		}
 
+		if x.sendSlots != nil {
+			select {
+			case x.sendSlots <- struct{}{}:
+				holdingSlot = true
+			case <-x.Context.Done():
+				return nil, x.Context.Err()
+			}
+		}
+
 		reqDeadline := time.Now().Add(timeout)
 		if contextDeadline, ok := x.Context.Deadline(); ok {
 			if contextDeadline.Before(reqDeadline) {
@@ -224,7 +373,7 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			}
 		}
 		if x.Version == Version3 {
-			packetOut.SecurityParameters.Log()
+			packetOut.SecurityParameters.LogSecurity(x.SecurityLogLevel)
 		}
 
 		var outBuf []byte
@@ -234,11 +383,14 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			err = fmt.Errorf("marshal: %w", err)
 			break
 		}
+		sentMsgFlags := packetOut.MsgFlags
 
 		if x.PreSend != nil {
 			x.PreSend(x)
 		}
-		x.Logger.Printf("SENDING PACKET: %#+v", *packetOut)
+		logged := *packetOut
+		logged.Community = x.loggedCommunity(logged.Community)
+		x.Logger.Printf("SENDING PACKET: %#+v", logged)
 		// If using UDP and unconnected socket, send packet directly to stored address.
 		if uconn, ok := x.Conn.(net.PacketConn); ok && x.uaddr != nil {
 			_, err = uconn.WriteTo(outBuf, x.uaddr)
@@ -264,8 +416,12 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			// Let the deadline abort us if we don't receive a valid response.
 
 			var resp []byte
-			resp, err = x.receive()
-			if err == io.EOF && strings.HasPrefix(x.Transport, "tcp") {
+			var rxBuf []byte
+			if packetOut.reuse != nil {
+				rxBuf = packetOut.reuse.buf
+			}
+			resp, err = x.receive(rxBuf)
+			if err == io.EOF && x.streamTransport() {
 				// EOF on TCP: reconnect and retry. Do not count
 				// as retry as socket was broken
 				x.Logger.Printf("ERROR: EOF. Performing reconnect")
@@ -283,8 +439,18 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				x.OnRecv(x)
 			}
 			x.Logger.Printf("GET RESPONSE OK: %+v", resp)
-			result = new(SnmpPacket)
+			if packetOut.reuse != nil {
+				packetOut.reuse.buf = resp
+				result = packetOut.reuse.next()
+			} else {
+				result = new(SnmpPacket)
+			}
 			result.Logger = x.Logger
+			result.size = len(resp)
+			if packetOut.keepRaw {
+				// resp is decrypted in place and may be a reused buffer
+				result.raw = append([]byte(nil), resp...)
+			}
 
 			result.MsgFlags = packetOut.MsgFlags
 			if packetOut.SecurityParameters != nil {
@@ -305,7 +471,7 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 						useResponseSecurityParameters = true
 					}
 				}
-				err = x.testAuthentication(resp, result, useResponseSecurityParameters)
+				err = x.testAuthentication(resp, result, packetOut.MsgFlags, useResponseSecurityParameters)
 				if err != nil {
 					x.Logger.Printf("ERROR on Test Authentication on v3: %s", err)
 					break
@@ -345,28 +511,30 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			// usmStatsNotInTimeWindows and usmStatsUnknownEngineIDs are recoverable errors
 			// and will be retransmitted, for others we return the result with an error.
 			if result.Version == Version3 && result.PDUType == Report && len(result.Variables) == 1 {
+				var reportErr error
 				switch result.Variables[0].Name {
 				case usmStatsUnsupportedSecLevels:
-					return result, ErrUnknownSecurityLevel
+					reportErr = ErrUnknownSecurityLevel
 				case usmStatsNotInTimeWindows:
 					break waitingResponse
 				case usmStatsUnknownUserNames:
-					return result, ErrUnknownUsername
+					reportErr = ErrUnknownUsername
 				case usmStatsUnknownEngineIDs:
 					break waitingResponse
 				case usmStatsWrongDigests:
-					return result, ErrWrongDigest
+					reportErr = ErrWrongDigest
 				case usmStatsDecryptionErrors:
-					return result, ErrDecryption
+					reportErr = ErrDecryption
 				case snmpUnknownSecurityModels:
-					return result, ErrUnknownSecurityModels
+					reportErr = ErrUnknownSecurityModels
 				case snmpInvalidMsgs:
-					return result, ErrInvalidMsgs
+					reportErr = ErrInvalidMsgs
 				case snmpUnknownPDUHandlers:
-					return result, ErrUnknownPDUHandlers
+					reportErr = ErrUnknownPDUHandlers
 				default:
-					return result, ErrUnknownReportPDU
+					reportErr = ErrUnknownReportPDU
 				}
+				return result, newReportError(reportErr, result.Variables[0])
 			}
 
 			validID := false
@@ -379,6 +547,9 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				validID = true
 			}
 			if !validID {
+				if x.FailOnRequestIDMismatch {
+					return nil, fmt.Errorf("%w: got %d, sent %v", ErrRequestIDMismatch, result.RequestID, allReqIDs)
+				}
 				x.Logger.Print("ERROR out of order")
 				continue
 			}
@@ -393,6 +564,8 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			x.OnFinish(x)
 		}
 		// Success!
+		result.Retransmits = retries
+		result.SentMsgFlags = sentMsgFlags
 		return result, nil
 	}
 
@@ -417,6 +590,12 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 		return nil, fmt.Errorf("&GoSNMP.Conn is missing. Provide a connection or use Connect()")
 	}
 
+	atomic.AddInt32(&x.inflight, 1)
+	defer atomic.AddInt32(&x.inflight, -1)
+	if atomic.LoadInt32(&x.closing) != 0 {
+		return nil, fmt.Errorf("connection is closing")
+	}
+
 	if x.Retries < 0 {
 		x.Retries = 0
 	}
@@ -431,9 +610,34 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 
 	// perform request
 	result, err = x.sendOneRequest(packetOut, wait)
+	if x.TransportFallback && strings.HasPrefix(x.Transport, "udp") &&
+		(errors.Is(err, ErrTruncatedResponse) || err == nil && result.Error == TooBig) {
+		x.Logger.Print("WARNING response too big for UDP, retrying over TCP")
+		result, err = x.sendOverTCP(packetOut, wait)
+	}
 	if err != nil {
 		x.Logger.Printf("SEND Error on the first Request Error: %s", err)
-		return result, err
+		if errors.Is(err, ErrWrongDigest) || errors.Is(err, ErrDecryption) {
+			x.invalidateEngineDiscovery()
+		}
+		if !errors.Is(err, ErrWrongDigest) || result == nil {
+			return result, err
+		}
+
+		// The keys may have been localized for another engine, eg one
+		// the agent had before a change of engine ID.
+		x.Logger.Print("WARNING detected wrong digest ERROR")
+		if err = x.relocalizeKeys(result); err != nil {
+			x.Logger.Printf("ERROR relocalizeKeys error: %s", err)
+			return result, ErrWrongDigest
+		}
+		packetOut.SecurityParameters = x.SecurityParameters.Copy()
+		// retransmit with re-localized keys
+		result, err = x.sendOneRequest(packetOut, wait)
+		if err != nil {
+			x.Logger.Printf("ERROR wrong digest retransmit error: %s", err)
+			return result, err
+		}
 	}
 
 	if result.Version == Version3 {
@@ -444,6 +648,8 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 			switch result.Variables[0].Name {
 			case usmStatsNotInTimeWindows:
 				x.Logger.Print("WARNING detected out-of-time-window ERROR")
+				// the cached boots and time are stale, eg the agent rebooted
+				x.invalidateEngineDiscovery()
 				if err = x.updatePktSecurityParameters(packetOut); err != nil {
 					x.Logger.Printf("ERROR updatePktSecurityParameters error: %s", err)
 					return nil, err
@@ -454,9 +660,14 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 					x.Logger.Printf("ERROR out-of-time-window retransmit error: %s", err)
 					return result, ErrNotInTimeWindow
 				}
+				if result.PDUType != Report {
+					x.storeEngineDiscovery()
+				}
 
 			case usmStatsUnknownEngineIDs:
 				x.Logger.Print("WARNING detected unknown engine id ERROR")
+				// the cached engine ID is stale, eg the agent was replaced
+				x.invalidateEngineDiscovery()
 				if err = x.updatePktSecurityParameters(packetOut); err != nil {
 					x.Logger.Printf("ERROR updatePktSecurityParameters error: %s", err)
 					return nil, err
@@ -467,12 +678,33 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 					x.Logger.Printf("ERROR unknown engine id retransmit error: %s", err)
 					return result, ErrUnknownEngineID
 				}
+				if result.PDUType != Report {
+					x.storeEngineDiscovery()
+				}
 			}
 		}
 	}
 	return result, err
 }
 
+// sendOverTCP re-issues packetOut over a new TCP connection to the same
+// Target and Port, for TransportFallback.
+func (x *GoSNMP) sendOverTCP(packetOut *SnmpPacket, wait bool) (*SnmpPacket, error) {
+	tcp := *x
+	tcp.Transport = "tcp" + strings.TrimPrefix(x.Transport, "udp")
+	tcp.uaddr = nil
+	tcp.rxBuf = new([rxBufSize]byte)
+	if err := tcp.netConnect(); err != nil {
+		return nil, fmt.Errorf("error establishing TCP fallback connection: %w", err)
+	}
+	defer tcp.Conn.Close()
+	result, err := tcp.sendOneRequest(packetOut, wait)
+	// the IDs used over TCP must not be reused by the next request of x
+	atomic.StoreUint32(&x.requestID, atomic.LoadUint32(&tcp.requestID))
+	atomic.StoreUint32(&x.msgID, atomic.LoadUint32(&tcp.msgID))
+	return result, err
+}
+
 // -- Marshalling Logic --------------------------------------------------------
 
 // MarshalMsg marshalls a snmp packet, ready for sending across the wire
@@ -920,6 +1152,91 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 
 // -- Unmarshalling Logic ------------------------------------------------------
 
+// PeekPDUType returns the PDU type of an SNMPv1 or SNMPv2c message, parsing
+// only the message header. It is a cheap way to filter received packets, eg
+// traps, before fully decoding them.
+//
+// SNMPv3 messages are not supported, as their PDU is usually encrypted.
+func PeekPDUType(b []byte) (PDUType, error) {
+	if len(b) < 2 || PDUType(b[0]) != Sequence {
+		return 0, fmt.Errorf("invalid packet header")
+	}
+	cursor, _, err := peekHeader(b)
+	if err != nil {
+		return 0, err
+	}
+
+	// version
+	if cursor >= len(b) || Asn1BER(b[cursor]) != Integer {
+		return 0, fmt.Errorf("error parsing SNMP packet version")
+	}
+	header, length, err := peekHeader(b[cursor:])
+	if err != nil {
+		return 0, err
+	}
+	if length == 1 && cursor+header < len(b) && SnmpVersion(b[cursor+header]) == Version3 {
+		return 0, fmt.Errorf("cannot peek PDU type of SNMPv3 message")
+	}
+	cursor += header + length
+
+	// community
+	if cursor >= len(b) || Asn1BER(b[cursor]) != OctetString {
+		return 0, fmt.Errorf("error parsing community string")
+	}
+	header, length, err = peekHeader(b[cursor:])
+	if err != nil {
+		return 0, err
+	}
+	cursor += header + length
+
+	if cursor >= len(b) {
+		return 0, fmt.Errorf("error parsing SNMP packet, packet length %d cursor %d", len(b), cursor)
+	}
+	return PDUType(b[cursor]), nil
+}
+
+// splitMessages splits b into the SNMP messages it holds back to back.
+func splitMessages(b []byte) ([][]byte, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("cannot unmarshal empty packet")
+	}
+	var msgs [][]byte
+	for len(b) > 0 {
+		if PDUType(b[0]) != Sequence {
+			return nil, fmt.Errorf("invalid packet header at message %d", len(msgs))
+		}
+		header, length, err := peekHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if header+length > len(b) {
+			return nil, fmt.Errorf("message %d truncated: got %d bytes, expected %d", len(msgs), len(b), header+length)
+		}
+		msgs = append(msgs, b[:header+length])
+		b = b[header+length:]
+	}
+	return msgs, nil
+}
+
+// peekHeader returns the length of the BER type and length octets at the
+// start of b, and the length of the value they describe.
+func peekHeader(b []byte) (header int, length int, err error) {
+	if len(b) < 2 {
+		return 0, 0, fmt.Errorf("truncated packet")
+	}
+	if b[1] <= 127 {
+		return 2, int(b[1]), nil
+	}
+	numOctets := int(b[1]) & 127
+	if numOctets == 0 || numOctets > 3 || len(b) < 2+numOctets {
+		return 0, 0, fmt.Errorf("invalid length at packet header")
+	}
+	for i := 0; i < numOctets; i++ {
+		length = length<<8 | int(b[2+i])
+	}
+	return 2 + numOctets, length, nil
+}
+
 func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, error) {
 	if len(packet) < 2 {
 		return 0, fmt.Errorf("cannot unmarshal empty packet")
@@ -928,7 +1245,12 @@ func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, erro
 		return 0, fmt.Errorf("cannot unmarshal response into nil packet reference")
 	}
 
-	response.Variables = make([]SnmpPDU, 0, 5)
+	if response.Variables == nil {
+		response.Variables = make([]SnmpPDU, 0, 5)
+	} else {
+		response.Variables = response.Variables[:0]
+	}
+	response.VarbindOffsets = nil
 
 	// Start parsing the packet
 	cursor := 0
@@ -939,6 +1261,9 @@ func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, erro
 	}
 
 	length, cursor := parseLength(packet)
+	if len(packet) < length {
+		return 0, fmt.Errorf("error verifying packet sanity: %w: Got %d Expected: %d", ErrTruncatedResponse, len(packet), length)
+	}
 	if len(packet) != length {
 		return 0, fmt.Errorf("error verifying packet sanity: Got %d Expected: %d", len(packet), length)
 	}
@@ -980,7 +1305,7 @@ func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, erro
 
 		if community, ok := rawCommunity.(string); ok {
 			response.Community = community
-			x.Logger.Printf("Parsed community %s", community)
+			x.Logger.Printf("Parsed community %s", x.loggedCommunity(community))
 		}
 	}
 	return cursor, nil
@@ -1007,6 +1332,7 @@ func (x *GoSNMP) unmarshalPayload(packet []byte, cursor int, response *SnmpPacke
 		if err := x.unmarshalResponse(packet[cursor:], response); err != nil {
 			return fmt.Errorf("error in unmarshalResponse: %w", err)
 		}
+		response.shiftVarbindOffsets(cursor)
 		// If it's an InformRequest, mark the trap.
 		response.IsInform = (requestType == InformRequest)
 	case Trap:
@@ -1014,6 +1340,7 @@ func (x *GoSNMP) unmarshalPayload(packet []byte, cursor int, response *SnmpPacke
 		if err := x.unmarshalTrapV1(packet[cursor:], response); err != nil {
 			return fmt.Errorf("error in unmarshalTrapV1: %w", err)
 		}
+		response.shiftVarbindOffsets(cursor)
 	default:
 		x.Logger.Printf("UnmarshalPayload Meet Unknown PDUType %#x. Offset %v", requestType, cursor)
 		return fmt.Errorf("unknown PDUType %#x", requestType)
@@ -1070,8 +1397,8 @@ func (x *GoSNMP) unmarshalResponse(packet []byte, response *SnmpPacket) error {
 			return fmt.Errorf("error parsing SNMP packet, packet length %d cursor %d", len(packet), cursor)
 		}
 
-		if maxRepetitions, ok := rawMaxRepetitions.(uint32); ok {
-			response.MaxRepetitions = (maxRepetitions & 0x7FFFFFFF)
+		if maxRepetitions, ok := rawMaxRepetitions.(int); ok {
+			response.MaxRepetitions = uint32(maxRepetitions) & 0x7FFFFFFF
 		}
 	} else {
 		// Parse Error-Status
@@ -1105,7 +1432,11 @@ func (x *GoSNMP) unmarshalResponse(packet []byte, response *SnmpPacket) error {
 		}
 	}
 
-	return x.unmarshalVBL(packet[cursor:], response)
+	if err = x.unmarshalVBL(packet[cursor:], response); err != nil {
+		return err
+	}
+	response.shiftVarbindOffsets(cursor)
+	return nil
 }
 
 func (x *GoSNMP) unmarshalTrapV1(packet []byte, response *SnmpPacket) error {
@@ -1193,12 +1524,16 @@ func (x *GoSNMP) unmarshalTrapV1(packet []byte, response *SnmpPacket) error {
 		x.Logger.Printf("Timestamp: %d", Timestamp)
 	}
 
-	return x.unmarshalVBL(packet[cursor:], response)
+	if err = x.unmarshalVBL(packet[cursor:], response); err != nil {
+		return err
+	}
+	response.shiftVarbindOffsets(cursor)
+	return nil
 }
 
 // unmarshal a Varbind list
 func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
-	var cursor, cursorInc int
+	var cursor int
 	var vblLength int
 
 	if len(packet) == 0 || cursor > len(packet) {
@@ -1230,7 +1565,14 @@ func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
 			return fmt.Errorf("expected a sequence when unmarshalling a VB, got %x", packet[cursor])
 		}
 
-		_, cursorInc = parseLength(packet[cursor:])
+		vbLength, cursorInc := parseLength(packet[cursor:])
+		if x.RecordVarbindOffsets {
+			response.VarbindOffsets = append(response.VarbindOffsets, VarbindOffset{
+				Offset:       cursor,
+				HeaderLength: cursorInc,
+				Length:       vbLength,
+			})
+		}
 		cursor += cursorInc
 		if cursor > len(packet) {
 			return fmt.Errorf("error parsing OID Value: packet %d cursor %d", len(packet), cursor)
@@ -1252,7 +1594,11 @@ func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
 		x.Logger.Printf("OID: %s", oid)
 		// Parse Value
 		var decodedVal variable
-		if err := x.decodeValue(packet[cursor:], &decodedVal); err != nil {
+		if x.rawValues {
+			if err := rawValue(packet[cursor:], &decodedVal); err != nil {
+				return fmt.Errorf("error decoding value: %w", err)
+			}
+		} else if err := x.decodeValue(packet[cursor:], &decodedVal); err != nil {
 			return fmt.Errorf("error decoding value: %w", err)
 		}
 
@@ -1262,19 +1608,64 @@ func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
 			return fmt.Errorf("error decoding OID Value: truncated, packet length %d cursor %d", len(packet), cursor)
 		}
 
+		switch {
+		case decodedVal.Type == Counter32 && oidInSubtrees(oid, x.Counter32AsGauge32):
+			decodedVal.Type = Gauge32
+		case decodedVal.Type == Integer && !x.rawValues && oidInSubtrees(oid, x.IntegerAsIPAddress):
+			decodedVal.Type = IPAddress
+			decodedVal.Value = x.integerToIP(decodedVal.Value.(int)).String()
+		}
+
 		response.Variables = append(response.Variables, SnmpPDU{oid, decodedVal.Type, decodedVal.Value})
 	}
 	return nil
 }
 
-// receive response from network and read into a byte array
-func (x *GoSNMP) receive() ([]byte, error) {
+// oidInSubtrees reports whether oid is one of, or under one of, subtrees.
+func oidInSubtrees(oid string, subtrees []string) bool {
+	for _, subtree := range subtrees {
+		subtree = strings.Trim(subtree, ".")
+		if subtree == "" {
+			return true
+		}
+		subtree = "." + subtree
+		if oid == subtree || strings.HasPrefix(oid, subtree+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// integerToIP converts an Integer value to an IPv4 address in
+// x.IntegerIPByteOrder.
+func (x *GoSNMP) integerToIP(v int) net.IP {
+	if x.IntegerIPByteOrder == nil || x.IntegerIPByteOrder == binary.BigEndian {
+		return IntToIP(uint32(v))
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	return IntToIP(x.IntegerIPByteOrder.Uint32(b[:]))
+}
+
+// shiftVarbindOffsets rebases the recorded varbind offsets by n bytes, as the
+// varbind list is parsed from a sub-slice of the packet.
+func (packet *SnmpPacket) shiftVarbindOffsets(n int) {
+	for i := range packet.VarbindOffsets {
+		packet.VarbindOffsets[i].Offset += n
+	}
+}
+
+// receive response from network and read into a byte array. buf, if not
+// nil, is reused for the response.
+func (x *GoSNMP) receive(buf []byte) ([]byte, error) {
 	var n int
 	var err error
 	// If we are using UDP and unconnected socket, read the packet and
 	// disregard the source address.
 	if uconn, ok := x.Conn.(net.PacketConn); ok {
 		n, _, err = uconn.ReadFrom(x.rxBuf[:])
+	} else if x.streamTransport() {
+		return x.receiveStream(buf)
 	} else {
 		n, err = x.Conn.Read(x.rxBuf[:])
 	}
@@ -1289,7 +1680,47 @@ func (x *GoSNMP) receive() ([]byte, error) {
 		return nil, fmt.Errorf("response buffer too small")
 	}
 
-	resp := make([]byte, n)
-	copy(resp, x.rxBuf[:n])
-	return resp, nil
+	return append(buf[:0], x.rxBuf[:n]...), nil
+}
+
+// receiveStream reads exactly one message from a stream (TCP or TLS) connection.
+// SNMP over TCP (RFC 3430) sends messages back to back, relying on the BER
+// length to delimit them, and a single message may arrive over several reads.
+// Read the tag and length first, then exactly the length announced.
+func (x *GoSNMP) receiveStream(respBuf []byte) ([]byte, error) {
+	buf := x.rxBuf[:]
+
+	// tag and the first length octet
+	if _, err := io.ReadFull(x.Conn, buf[:2]); err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading from socket: %w", err)
+	}
+
+	headerLength := 2
+	length := int(buf[1])
+	if buf[1]&0x80 != 0 {
+		numOctets := int(buf[1] & 0x7f)
+		if numOctets == 0 || numOctets > 3 {
+			return nil, fmt.Errorf("unsupported BER length of %d octets on stream", numOctets)
+		}
+		headerLength += numOctets
+		if _, err := io.ReadFull(x.Conn, buf[2:headerLength]); err != nil {
+			return nil, fmt.Errorf("error reading from socket: %w", err)
+		}
+		length = 0
+		for _, octet := range buf[2:headerLength] {
+			length = length<<8 | int(octet)
+		}
+	}
+
+	n := headerLength + length
+	if n > rxBufSize {
+		return nil, fmt.Errorf("response buffer too small for message of %d bytes", n)
+	}
+	if _, err := io.ReadFull(x.Conn, buf[headerLength:n]); err != nil {
+		return nil, fmt.Errorf("error reading from socket: %w", err)
+	}
+
+	return append(respBuf[:0], buf[:n]...), nil
 }
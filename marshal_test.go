@@ -8,19 +8,34 @@ package gosnmp
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net"
+	"os"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Tests in alphabetical order of function being tested
@@ -683,6 +698,40 @@ var testsUnmarshal = []struct {
 			},
 		},
 	},
+	{opaqueTimeTicksResponse,
+		&SnmpPacket{
+			Version:    Version2c,
+			Community:  "public",
+			PDUType:    GetResponse,
+			RequestID:  601216773,
+			Error:      0,
+			ErrorIndex: 0,
+			Variables: []SnmpPDU{
+				{
+					Name:  ".1.3.6.1.4.1.6574.4.2.12.1.0",
+					Type:  TimeTicks,
+					Value: uint32(123456),
+				},
+			},
+		},
+	},
+	{opaqueCounter64Response,
+		&SnmpPacket{
+			Version:    Version2c,
+			Community:  "public",
+			PDUType:    GetResponse,
+			RequestID:  601216773,
+			Error:      0,
+			ErrorIndex: 0,
+			Variables: []SnmpPDU{
+				{
+					Name:  ".1.3.6.1.4.1.6574.4.2.12.1.0",
+					Type:  Counter64,
+					Value: uint64(0xfedcba9876543210),
+				},
+			},
+		},
+	},
 	{snmpv3HelloRequest,
 		&SnmpPacket{
 			Version:    Version3,
@@ -1334,6 +1383,34 @@ func opaqueDoubleResponse() []byte {
 	}
 }
 
+/*
+Opaque TimeTicks, not observed, crafted after the Opaque sub-tags used for
+OpaqueFloat and OpaqueDouble: 0x9f 0x73 wrapping 123456 ticks
+*/
+func opaqueTimeTicksResponse() []byte {
+	return []byte{
+		0x30, 0x33, 0x02, 0x01, 0x01, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69,
+		0x63, 0xa2, 0x26, 0x02, 0x04, 0x23, 0xd5, 0xd7, 0x05, 0x02, 0x01, 0x00,
+		0x02, 0x01, 0x00, 0x30, 0x18, 0x30, 0x16, 0x06, 0x0c, 0x2b, 0x06, 0x01,
+		0x04, 0x01, 0xb3, 0x2e, 0x04, 0x02, 0x0c, 0x01, 0x00, 0x44, 0x06, 0x9f,
+		0x73, 0x03, 0x01, 0xe2, 0x40,
+	}
+}
+
+/*
+Opaque Counter64, crafted after net-snmp's ASN_OPAQUE_COUNTER64: 0x9f 0x76
+wrapping 0xfedcba9876543210, which a float64 can't hold exactly
+*/
+func opaqueCounter64Response() []byte {
+	return []byte{
+		0x30, 0x39, 0x02, 0x01, 0x01, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69,
+		0x63, 0xa2, 0x2c, 0x02, 0x04, 0x23, 0xd5, 0xd7, 0x05, 0x02, 0x01, 0x00,
+		0x02, 0x01, 0x00, 0x30, 0x1e, 0x30, 0x1c, 0x06, 0x0c, 0x2b, 0x06, 0x01,
+		0x04, 0x01, 0xb3, 0x2e, 0x04, 0x02, 0x0c, 0x01, 0x00, 0x44, 0x0c, 0x9f,
+		0x76, 0x09, 0x00, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10,
+	}
+}
+
 func TestUnmarshalEmptyPanic(t *testing.T) {
 	var in = []byte{}
 	var res = new(SnmpPacket)
@@ -1774,3 +1851,3219 @@ func dumpBytes2(desc string, bb []byte, cursor int) string {
 	}
 	return result
 }
+
+func TestConnectNetwork(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "localhost",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Millisecond * 100,
+		Network: "udp4",
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	assert.Equal(t, "udp4", x.Transport)
+	assert.NotNil(t, x.Conn.LocalAddr().(*net.UDPAddr).IP.To4())
+	assert.NotNil(t, x.Conn.RemoteAddr().(*net.UDPAddr).IP.To4())
+
+	x = &GoSNMP{Target: "localhost", Network: "ip4"}
+	assert.Error(t, x.Connect())
+
+	for _, test := range []struct{ transport, network, want string }{
+		{"", "tcp6", "tcp6"},
+		{"udp", "udp4", "udp4"},
+		{"udp4", "udp", "udp4"},
+		{"tcp6", "tcp6", "tcp6"},
+		{"tls", "tcp", "tls"},
+		{"tls", "tcp4", "tls4"},
+		{"tls6", "tcp6", "tls6"},
+	} {
+		x = &GoSNMP{Transport: test.transport, Network: test.network}
+		require.NoError(t, x.validateParameters(), "%s %s", test.transport, test.network)
+		assert.Equal(t, test.want, x.Transport, "%s %s", test.transport, test.network)
+	}
+	for _, test := range []struct{ transport, network string }{
+		{"tcp", "udp4"},
+		{"udp", "tcp"},
+		{"tls", "udp6"},
+		{"udp6", "udp4"},
+		{"tls4", "tcp6"},
+	} {
+		x = &GoSNMP{Transport: test.transport, Network: test.network}
+		assert.Error(t, x.validateParameters(), "%s %s", test.transport, test.network)
+	}
+}
+
+// -- test agent ---------------------------------------------------------------
+
+// newTestAgent starts a UDP agent answering each request with the packet
+// returned by handler (nil drops the request), and returns a connected v2c
+// client for it along with a function to shut both down.
+func newTestAgent(t *testing.T, handler func(req *SnmpPacket) *SnmpPacket) (*GoSNMP, func()) {
+	srvr := startTestAgent(t, handler)
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Target:    srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:      uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:   time.Millisecond * 100,
+		Retries:   2,
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	if err := x.Connect(); err != nil {
+		srvr.Close()
+		t.Fatalf("error connecting: %s", err)
+	}
+
+	return x, func() {
+		x.Conn.Close()
+		srvr.Close()
+	}
+}
+
+// startTestAgent starts a UDP agent on localhost answering each request with
+// the packet returned by handler, or nothing if it returns nil. v3 requests
+// must be unencrypted.
+func startTestAgent(t *testing.T, handler func(req *SnmpPacket) *SnmpPacket) *net.UDPConn {
+	return startTestAgentUsm(t, nil, handler)
+}
+
+// startTestAgentUsm is startTestAgent for an agent holding the USM user sp, so
+// that authenticated and encrypted v3 requests can be decoded. Requests failing
+// authentication are answered with a usmStatsWrongDigests report.
+func startTestAgentUsm(t *testing.T, sp *UsmSecurityParameters, handler func(req *SnmpPacket) *SnmpPacket) *net.UDPConn {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+
+	go func() {
+		agent := &GoSNMP{Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+		buf := make([]byte, rxBufSize)
+		for {
+			n, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var reqPkt SnmpPacket
+			if sp != nil {
+				reqPkt.SecurityParameters = sp.Copy()
+			}
+			msg := buf[:n]
+			cursor, err := agent.unmarshalHeader(msg, &reqPkt)
+			if err != nil {
+				t.Errorf("error: %s", err)
+				continue
+			}
+			if sp != nil && reqPkt.MsgFlags&AuthNoPriv != 0 {
+				if authentic, _ := reqPkt.SecurityParameters.isAuthentic(msg, &reqPkt); !authentic {
+					report := &SnmpPacket{
+						Version:            Version3,
+						MsgID:              reqPkt.MsgID,
+						MsgFlags:           NoAuthNoPriv,
+						SecurityModel:      UserSecurityModel,
+						SecurityParameters: sp.Copy(),
+						PDUType:            Report,
+						RequestID:          reqPkt.RequestID,
+						Variables:          []SnmpPDU{{Name: usmStatsWrongDigests, Type: Counter32, Value: uint32(1)}},
+					}
+					if outBuf, err := report.marshalMsg(); err == nil {
+						srvr.WriteTo(outBuf, addr)
+					}
+					continue
+				}
+			}
+			if reqPkt.Version == Version3 {
+				if msg, cursor, err = agent.decryptPacket(msg, cursor, &reqPkt); err != nil {
+					t.Errorf("error: %s", err)
+					continue
+				}
+			}
+			if err = agent.unmarshalPayload(msg, cursor, &reqPkt); err != nil {
+				t.Errorf("error: %s", err)
+				continue
+			}
+
+			rspPkt := handler(&reqPkt)
+			if rspPkt == nil {
+				continue
+			}
+			rspPkt.RequestID = reqPkt.RequestID
+			outBuf, err := rspPkt.marshalMsg()
+			if err != nil {
+				t.Errorf("ERR: %s", err)
+				continue
+			}
+			srvr.WriteTo(outBuf, addr)
+		}
+	}()
+
+	return srvr
+}
+
+// testMib is a sorted list of PDUs served by testMib.handle.
+type testMib []SnmpPDU
+
+func testOidLess(a, b string) bool {
+	as := strings.Split(strings.Trim(a, "."), ".")
+	bs := strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ai, _ := strconv.Atoi(as[i])
+		bi, _ := strconv.Atoi(bs[i])
+		if ai != bi {
+			return ai < bi
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func (m testMib) next(oid string) SnmpPDU {
+	for _, pdu := range m {
+		if testOidLess(oid, pdu.Name) {
+			return pdu
+		}
+	}
+	return SnmpPDU{Name: oid, Type: EndOfMibView}
+}
+
+func (m testMib) get(oid string) SnmpPDU {
+	for _, pdu := range m {
+		if pdu.Name == oid {
+			return pdu
+		}
+	}
+	return SnmpPDU{Name: oid, Type: NoSuchObject}
+}
+
+// handle answers Get, GetNext and GetBulk requests as a v2c agent would.
+func (m testMib) handle(req *SnmpPacket) *SnmpPacket {
+	var vars []SnmpPDU
+	switch req.PDUType {
+	case GetRequest:
+		for _, v := range req.Variables {
+			vars = append(vars, m.get(v.Name))
+		}
+	case GetNextRequest:
+		for _, v := range req.Variables {
+			vars = append(vars, m.next(v.Name))
+		}
+	case GetBulkRequest:
+		for _, v := range req.Variables {
+			oid := v.Name
+			for i := uint32(0); i < req.MaxRepetitions; i++ {
+				pdu := m.next(oid)
+				vars = append(vars, pdu)
+				if pdu.Type == EndOfMibView {
+					break
+				}
+				oid = pdu.Name
+			}
+		}
+	default:
+		vars = req.Variables
+	}
+	return &SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   GetResponse,
+		Variables: vars,
+		Logger:    req.Logger,
+	}
+}
+
+func TestGetScalar(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1", Type: OctetString, Value: []byte("bare")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(1234)},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	pdu, err := x.GetScalar(".1.3.6.1.2.1.1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.1.3.0", pdu.Name)
+	assert.Equal(t, uint32(1234), pdu.Value)
+
+	pdu, err = x.GetScalar("1.3.6.1.2.1.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.1.1", pdu.Name)
+	assert.Equal(t, []byte("bare"), pdu.Value)
+
+	_, err = x.GetScalar(".1.3.6.1.2.1.2.2.1.1")
+	assert.Error(t, err)
+}
+
+func TestRecordVarbindOffsets(t *testing.T) {
+	x := &GoSNMP{
+		Version:              Version2c,
+		Logger:               NewLogger(log.New(ioutil.Discard, "", 0)),
+		RecordVarbindOffsets: true,
+	}
+
+	for _, in := range [][]byte{counter64Response(), opaqueFloatResponse(), ciscoGetbulkResponseBytes()} {
+		pkt, err := x.SnmpDecodePacket(in)
+		if err != nil {
+			t.Fatalf("SnmpDecodePacket err: %v", err)
+		}
+		if len(pkt.VarbindOffsets) != len(pkt.Variables) {
+			t.Fatalf("got %d offsets for %d variables", len(pkt.VarbindOffsets), len(pkt.Variables))
+		}
+		for i, vbo := range pkt.VarbindOffsets {
+			raw := in[vbo.Offset : vbo.Offset+vbo.Length]
+			if raw[0] != byte(Sequence) {
+				t.Errorf("#%d: offset %d does not point to a sequence: %x", i, vbo.Offset, raw)
+			}
+			length, cursor := parseLength(raw)
+			assert.Equal(t, vbo.Length, length)
+			assert.Equal(t, vbo.HeaderLength, cursor)
+
+			// the OID follows the header
+			oid, _, err := parseRawField(x.Logger, raw[vbo.HeaderLength:], "OID")
+			assert.NoError(t, err)
+			assert.Equal(t, pkt.Variables[i].Name, oid)
+		}
+		// the last varbind ends the packet
+		last := pkt.VarbindOffsets[len(pkt.VarbindOffsets)-1]
+		assert.Equal(t, len(in), last.Offset+last.Length)
+	}
+
+	x.RecordVarbindOffsets = false
+	pkt, err := x.SnmpDecodePacket(counter64Response())
+	assert.NoError(t, err)
+	assert.Nil(t, pkt.VarbindOffsets)
+}
+
+func TestReceiveTCPShortReads(t *testing.T) {
+	srvr, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("tcp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Transport: "tcp",
+		Target:    srvr.Addr().(*net.TCPAddr).IP.String(),
+		Port:      uint16(srvr.Addr().(*net.TCPAddr).Port),
+		Timeout:   time.Second,
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	go func() {
+		conn, err := srvr.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Errorf("error reading request: %s", err)
+			return
+		}
+		var reqPkt SnmpPacket
+		cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+		if err != nil {
+			t.Errorf("error: %s", err)
+			return
+		}
+		if err = x.unmarshalPayload(buf[:n], cursor, &reqPkt); err != nil {
+			t.Errorf("error: %s", err)
+			return
+		}
+
+		rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
+			{Name: ".1.2", Type: OctetString, Value: payload},
+		}, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return
+		}
+		// dribble the response out, splitting the BER header too
+		for _, chunk := range [][]byte{outBuf[:1], outBuf[1:3], outBuf[3:100], outBuf[100:5000], outBuf[5000:]} {
+			conn.Write(chunk)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	result, err := x.Get([]string{".1.2"})
+	if err != nil {
+		t.Fatalf("Get() err: %v", err)
+	}
+	if len(result.Variables) != 1 {
+		t.Fatalf("expected 1 variable, got %d", len(result.Variables))
+	}
+	assert.Equal(t, payload, result.Variables[0].Value)
+}
+
+func TestGetDuplicateOids(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(1234)},
+	}
+	var requested int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.StoreInt32(&requested, int32(len(req.Variables)))
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	oids := []string{".1.3.6.1.2.1.1.1.0", "1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.1.1.0"}
+
+	// default: sent unchanged
+	result, err := x.Get(oids)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requested))
+	assert.Len(t, result.Variables, 3)
+
+	x.DuplicateOids = DuplicateOidsReject
+	_, err = x.Get(oids)
+	assert.Error(t, err)
+
+	x.DuplicateOids = DuplicateOidsMerge
+	result, err = x.Get(oids)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requested))
+	if assert.Len(t, result.Variables, 3) {
+		assert.Equal(t, ".1.3.6.1.2.1.1.1.0", result.Variables[0].Name)
+		assert.Equal(t, ".1.3.6.1.2.1.1.3.0", result.Variables[1].Name)
+		assert.Equal(t, ".1.3.6.1.2.1.1.1.0", result.Variables[2].Name)
+		assert.Equal(t, []byte("descr"), result.Variables[2].Value)
+	}
+}
+
+func TestShareEngineDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	discoveries := 0
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  100,
+			},
+			ContextEngineID: "testengine",
+			PDUType:         GetResponse,
+			Variables:       req.Variables,
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			mu.Lock()
+			discoveries++
+			mu.Unlock()
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	get := func() *GoSNMP {
+		x := &GoSNMP{
+			Version:              Version3,
+			Target:               srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:                 uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:              time.Millisecond * 100,
+			Retries:              2,
+			Logger:               NewLogger(log.New(ioutil.Discard, "", 0)),
+			SecurityModel:        UserSecurityModel,
+			MsgFlags:             NoAuthNoPriv,
+			SecurityParameters:   &UsmSecurityParameters{UserName: "test"},
+			ShareEngineDiscovery: true,
+		}
+		require.NoError(t, x.Connect())
+		defer x.Conn.Close()
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		require.NoError(t, err)
+		return x
+	}
+	defer func() { engineDiscoveryCache = NewMemoryEngineCache(0) }()
+
+	get()
+	x := get()
+	mu.Lock()
+	require.Equal(t, 1, discoveries, "second connection should reuse the discovery")
+	mu.Unlock()
+	usp := x.SecurityParameters.(*UsmSecurityParameters)
+	require.Equal(t, "testengine", usp.AuthoritativeEngineID)
+	require.Equal(t, uint32(3), usp.AuthoritativeEngineBoots)
+	require.Equal(t, "testengine", x.ContextEngineID)
+
+	x.invalidateEngineDiscovery()
+	get()
+	mu.Lock()
+	require.Equal(t, 2, discoveries, "invalidated discovery should be repeated")
+	mu.Unlock()
+}
+
+func TestEngineCache(t *testing.T) {
+	var discoveries int32
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  100,
+			},
+			ContextEngineID: "testengine",
+			PDUType:         GetResponse,
+			Variables:       req.Variables,
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			atomic.AddInt32(&discoveries, 1)
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	get := func(cache EngineCache) {
+		x := &GoSNMP{
+			Version:            Version3,
+			Target:             srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:               uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:            time.Millisecond * 100,
+			Retries:            2,
+			Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+			SecurityModel:      UserSecurityModel,
+			MsgFlags:           NoAuthNoPriv,
+			SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+			EngineCache:        cache,
+		}
+		require.NoError(t, x.Connect())
+		defer x.Conn.Close()
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		require.NoError(t, err)
+	}
+
+	cache := NewMemoryEngineCache(0)
+	get(cache)
+	get(cache)
+	require.Equal(t, int32(1), atomic.LoadInt32(&discoveries), "second connection should reuse the discovery")
+	engineID, boots, _, ok := cache.Get(srvr.LocalAddr().String())
+	require.True(t, ok)
+	require.Equal(t, "testengine", engineID)
+	require.Equal(t, uint32(3), boots)
+
+	get(NewMemoryEngineCache(0))
+	require.Equal(t, int32(2), atomic.LoadInt32(&discoveries), "caches are not shared")
+
+	expiring := NewMemoryEngineCache(time.Nanosecond)
+	get(expiring)
+	time.Sleep(time.Millisecond)
+	get(expiring)
+	require.Equal(t, int32(4), atomic.LoadInt32(&discoveries), "expired entries are discovered again")
+}
+
+func TestEngineCacheUnknownEngineID(t *testing.T) {
+	var accept int32
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  100,
+			},
+			ContextEngineID: "testengine",
+			PDUType:         GetResponse,
+			Variables:       req.Variables,
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID != "testengine" {
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		} else if atomic.LoadInt32(&accept) == 0 {
+			return nil
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	addr := srvr.LocalAddr().String()
+	get := func(cache EngineCache) error {
+		x := &GoSNMP{
+			Version:            Version3,
+			Target:             srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:               uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:            time.Millisecond * 100,
+			Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+			SecurityModel:      UserSecurityModel,
+			MsgFlags:           NoAuthNoPriv,
+			SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+			EngineCache:        cache,
+		}
+		require.NoError(t, x.Connect())
+		defer x.Conn.Close()
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		return err
+	}
+
+	// the retransmit after the report goes unanswered: the stale entry
+	// must not survive, nor be replaced by the unconfirmed report
+	cache := NewMemoryEngineCache(0)
+	cache.Set(addr, "oldengine", 1, 50)
+	require.ErrorIs(t, get(cache), ErrUnknownEngineID)
+	_, _, _, ok := cache.Get(addr)
+	require.False(t, ok, "stale engine should be invalidated")
+
+	atomic.StoreInt32(&accept, 1)
+	cache.Set(addr, "oldengine", 1, 50)
+	require.NoError(t, get(cache))
+	engineID, boots, _, ok := cache.Get(addr)
+	require.True(t, ok)
+	require.Equal(t, "testengine", engineID)
+	require.Equal(t, uint32(3), boots)
+}
+
+func TestEngineKeepalive(t *testing.T) {
+	var engineTime, probes uint32 = 100, 0
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  atomic.AddUint32(&engineTime, 1000),
+			},
+			ContextEngineID: "testengine",
+			PDUType:         GetResponse,
+			Variables:       req.Variables,
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			atomic.AddUint32(&probes, 1)
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:            Version3,
+		Target:             srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:               uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:            time.Millisecond * 100,
+		Retries:            2,
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel:      UserSecurityModel,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+		EngineKeepalive:    time.Millisecond * 20,
+	}
+	require.NoError(t, x.Connect())
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.NoError(t, err)
+	usp := x.SecurityParameters.(*UsmSecurityParameters)
+	usp.mu.Lock()
+	learnt := usp.AuthoritativeEngineTime
+	usp.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		usp.mu.Lock()
+		defer usp.mu.Unlock()
+		return usp.AuthoritativeEngineTime > learnt
+	}, time.Second, time.Millisecond*5, "the keepalive should refresh the engine time")
+	usp.mu.Lock()
+	require.Equal(t, uint32(3), usp.AuthoritativeEngineBoots)
+	usp.mu.Unlock()
+
+	require.NoError(t, x.Close())
+	// a probe may have been in flight
+	time.Sleep(time.Millisecond * 30)
+	stopped := atomic.LoadUint32(&probes)
+	time.Sleep(time.Millisecond * 60)
+	require.Equal(t, stopped, atomic.LoadUint32(&probes), "Close should stop the keepalive")
+}
+
+func TestEngineKeepaliveForwardOnly(t *testing.T) {
+	var mu sync.Mutex
+	msgIDs := make(map[uint32]bool)
+	duplicate := false
+	var probeEngineID atomic.Value
+	probeEngineID.Store("testengine")
+	var probeBoots, probeTime uint32 = 3, 100
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		duplicate = duplicate || msgIDs[req.MsgID]
+		msgIDs[req.MsgID] = true
+		mu.Unlock()
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  100,
+			},
+			ContextEngineID: "testengine",
+			PDUType:         GetResponse,
+			Variables:       req.Variables,
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			rsp.SecurityParameters = &UsmSecurityParameters{
+				AuthoritativeEngineID:    probeEngineID.Load().(string),
+				AuthoritativeEngineBoots: atomic.LoadUint32(&probeBoots),
+				AuthoritativeEngineTime:  atomic.LoadUint32(&probeTime),
+			}
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:            Version3,
+		Target:             srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:               uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:            time.Millisecond * 100,
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel:      UserSecurityModel,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+		EngineKeepalive:    time.Millisecond * 10,
+		RandomSource:       bytes.NewReader([]byte{0, 0, 0, 1, 0x10, 0, 0, 0}),
+	}
+	require.NoError(t, x.Connect())
+	defer x.Close()
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.NoError(t, err)
+	usp := x.SecurityParameters.(*UsmSecurityParameters)
+	engine := func() (string, uint32, uint32) {
+		usp.mu.Lock()
+		defer usp.mu.Unlock()
+		return usp.AuthoritativeEngineID, usp.AuthoritativeEngineBoots, usp.AuthoritativeEngineTime
+	}
+
+	// an earlier time, an earlier boots or another engine are ignored
+	atomic.StoreUint32(&probeTime, 50)
+	time.Sleep(time.Millisecond * 50)
+	atomic.StoreUint32(&probeBoots, 2)
+	atomic.StoreUint32(&probeTime, 500)
+	time.Sleep(time.Millisecond * 50)
+	atomic.StoreUint32(&probeBoots, 4)
+	probeEngineID.Store("otherengine")
+	time.Sleep(time.Millisecond * 50)
+	engineID, boots, engineTime := engine()
+	require.Equal(t, "testengine", engineID)
+	require.Equal(t, uint32(3), boots)
+	require.Equal(t, uint32(100), engineTime)
+
+	probeEngineID.Store("testengine")
+	atomic.StoreUint32(&probeTime, 5)
+	require.Eventually(t, func() bool {
+		_, boots, engineTime := engine()
+		return boots == 4 && engineTime == 5
+	}, time.Second, time.Millisecond*5, "a later boots should be applied")
+
+	require.NoError(t, x.Close())
+	mu.Lock()
+	defer mu.Unlock()
+	require.False(t, duplicate, "probes should not reuse the msgIDs of x")
+}
+
+func TestPeekPDUType(t *testing.T) {
+	pduType, err := PeekPDUType(trap1())
+	require.NoError(t, err)
+	require.Equal(t, SNMPv2Trap, pduType)
+
+	pduType, err = PeekPDUType(ciscoGetbulkResponseBytes())
+	require.NoError(t, err)
+	require.Equal(t, GetResponse, pduType)
+
+	for _, b := range [][]byte{nil, {0x30}, {0x30, 0x03, 0x02, 0x01, 0x01}, {0x04, 0x00}} {
+		_, err = PeekPDUType(b)
+		require.Error(t, err, "%x", b)
+	}
+
+	v3 := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+		PDUType:            GetRequest,
+	}
+	b, err := v3.marshalMsg()
+	require.NoError(t, err)
+	_, err = PeekPDUType(b)
+	require.Error(t, err)
+}
+
+func TestNoRetries(t *testing.T) {
+	for _, retries := range []int{0, -1} {
+		var mu sync.Mutex
+		sends := 0
+		x, closer := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+			mu.Lock()
+			sends++
+			mu.Unlock()
+			return nil
+		})
+
+		x.Retries = retries
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		require.Error(t, err)
+		require.Equal(t, 0, x.Retries)
+
+		// give a late request a chance to arrive
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		require.Equal(t, 1, sends, "Retries %d", retries)
+		mu.Unlock()
+		closer()
+	}
+}
+
+func TestCloseGraceful(t *testing.T) {
+	mib := testMib{{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")}}
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		time.Sleep(50 * time.Millisecond)
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	done := make(chan error)
+	go func() {
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		done <- err
+	}()
+	for atomic.LoadInt32(&x.inflight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, x.CloseGraceful(ctx))
+	require.NoError(t, <-done, "in-flight request should complete")
+
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.Error(t, err, "request after CloseGraceful should fail")
+}
+
+func TestCloseGracefulDeadline(t *testing.T) {
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return nil
+	})
+	defer closeFn()
+
+	done := make(chan error)
+	go func() {
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		done <- err
+	}()
+	for atomic.LoadInt32(&x.inflight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, x.CloseGraceful(ctx))
+	require.Error(t, <-done)
+}
+
+func TestSnmpDecodePackets(t *testing.T) {
+	x := &GoSNMP{
+		Logger: NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	trap := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   SNMPv2Trap,
+		RequestID: 4242,
+		Variables: []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(1234)}},
+	}
+	trapBytes, err := trap.marshalMsg()
+	require.NoError(t, err)
+	msgs := append(counter64Response(), trapBytes...)
+
+	_, err = x.SnmpDecodePackets(msgs)
+	require.Error(t, err, "concatenated messages should be rejected by default")
+
+	x.ConcatenatedMessages = true
+	pkts, err := x.SnmpDecodePackets(msgs)
+	require.NoError(t, err)
+	require.Len(t, pkts, 2)
+	require.Equal(t, GetResponse, pkts[0].PDUType)
+	require.Equal(t, SNMPv2Trap, pkts[1].PDUType)
+
+	_, err = x.SnmpDecodePackets(msgs[:len(msgs)-1])
+	require.Error(t, err)
+}
+
+func TestBulkWalkThrottled(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.3", Type: Integer, Value: 3},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.4", Type: Integer, Value: 4},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.5", Type: Integer, Value: 5},
+	}
+	var mu sync.Mutex
+	var maxReps []uint32
+	var times []time.Time
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		maxReps = append(maxReps, req.MaxRepetitions)
+		times = append(times, time.Now())
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	pause := 30 * time.Millisecond
+	var results []SnmpPDU
+	err := x.BulkWalkThrottled(".1.3.6.1.2.1.2.2", 2, pause, func(pdu SnmpPDU) error {
+		results = append(results, pdu)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []uint32{2, 2, 2}, maxReps)
+	for i := 1; i < len(times); i++ {
+		require.True(t, times[i].Sub(times[i-1]) >= pause, "request %d sent after %s", i, times[i].Sub(times[i-1]))
+	}
+}
+
+func TestBulkWalkThrottledCancel(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	x.Context = ctx
+
+	start := time.Now()
+	err := x.BulkWalkThrottled(".1.3.6.1.2.1.2.2", 1, time.Minute, func(pdu SnmpPDU) error {
+		cancel()
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.True(t, time.Since(start) < time.Second, "pause should be interrupted")
+}
+
+func TestCapabilities(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("host")},
+		{Name: ".1.3.6.1.2.1.1.9.1.2.1", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1"},
+		{Name: ".1.3.6.1.2.1.1.9.1.2.2", Type: ObjectIdentifier, Value: ".1.3.6.1.2.1.49"},
+		{Name: ".1.3.6.1.2.1.1.9.1.3.1", Type: OctetString, Value: []byte("The MIB module for SNMPv2 entities")},
+		{Name: ".1.3.6.1.2.1.1.9.1.3.2", Type: OctetString, Value: []byte("The MIB module for managing TCP implementations")},
+		{Name: ".1.3.6.1.2.1.1.9.1.4.1", Type: TimeTicks, Value: uint32(10)},
+		{Name: ".1.3.6.1.2.1.1.9.1.4.2", Type: TimeTicks, Value: uint32(20)},
+		{Name: ".1.3.6.1.2.1.2.1.0", Type: Integer, Value: 2},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	entries, err := x.Capabilities()
+	require.NoError(t, err)
+	require.Equal(t, []ORTableEntry{
+		{Index: 1, ID: ".1.3.6.1.6.3.1", Descr: "The MIB module for SNMPv2 entities", UpTime: 10},
+		{Index: 2, ID: ".1.3.6.1.2.1.49", Descr: "The MIB module for managing TCP implementations", UpTime: 20},
+	}, entries)
+}
+
+func TestGetBulkDefaultMaxRepetitions(t *testing.T) {
+	mib := testMib{{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")}}
+	var mu sync.Mutex
+	var maxReps uint32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		maxReps = req.MaxRepetitions
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	for _, tt := range []struct {
+		configured, requested, expected uint32
+	}{
+		{0, 0, DefaultMaxRepetitions},
+		{7, 0, 7},
+		{7, 3, 3},
+	} {
+		x.MaxRepetitions = tt.configured
+		_, err := x.GetBulk([]string{".1.3.6.1.2.1.1"}, 0, tt.requested)
+		require.NoError(t, err)
+		mu.Lock()
+		require.Equal(t, tt.expected, maxReps, "configured %d requested %d", tt.configured, tt.requested)
+		mu.Unlock()
+	}
+}
+
+// TestDecodeGetBulkRequest checks that a decoded GetBulkRequest keeps its
+// max-repetitions, which parseRawField returns as an int.
+func TestDecodeGetBulkRequest(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+	for _, maxRepetitions := range []uint32{0, 1, 127, 128, 300, 0x7FFFFFFF} {
+		packet := &SnmpPacket{
+			Version:        Version2c,
+			Community:      "public",
+			PDUType:        GetBulkRequest,
+			RequestID:      42,
+			NonRepeaters:   2,
+			MaxRepetitions: maxRepetitions,
+			Variables:      []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1", Type: Null}},
+		}
+		b, err := packet.MarshalMsg()
+		require.NoError(t, err)
+
+		decoded, err := x.SnmpDecodePacket(b)
+		require.NoError(t, err)
+		require.Equal(t, GetBulkRequest, decoded.PDUType)
+		require.Equal(t, uint8(2), decoded.NonRepeaters)
+		require.Equal(t, maxRepetitions, decoded.MaxRepetitions)
+	}
+}
+
+func TestWalkUnknownErrorStatus(t *testing.T) {
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return &SnmpPacket{
+			Version:    req.Version,
+			Community:  req.Community,
+			PDUType:    GetResponse,
+			Error:      SNMPError(42),
+			ErrorIndex: 1,
+			Variables:  req.Variables,
+		}
+	})
+	defer closeFn()
+
+	_, err := x.BulkWalkAll(".1.3.6.1.2.1.1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown error status 42")
+
+	_, err = x.WalkAll(".1.3.6.1.2.1.1")
+	require.Error(t, err)
+}
+
+func TestBuildDiscoveryPacket(t *testing.T) {
+	x := &GoSNMP{
+		Version:            Version3,
+		SecurityModel:      UserSecurityModel,
+		MsgFlags:           AuthPriv,
+		ContextName:        "ctx",
+		SecurityParameters: &UsmSecurityParameters{UserName: "test", AuthoritativeEngineID: "known"},
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	b, err := x.BuildDiscoveryPacket()
+	require.NoError(t, err)
+
+	var pkt SnmpPacket
+	cursor, err := x.unmarshalHeader(b, &pkt)
+	require.NoError(t, err)
+	require.Equal(t, Version3, pkt.Version)
+	require.Equal(t, Reportable|NoAuthNoPriv, pkt.MsgFlags)
+	usp := pkt.SecurityParameters.(*UsmSecurityParameters)
+	require.Empty(t, usp.AuthoritativeEngineID)
+	require.Empty(t, usp.UserName)
+
+	b, cursor, err = x.decryptPacket(b, cursor, &pkt)
+	require.NoError(t, err)
+	require.NoError(t, x.unmarshalPayload(b, cursor, &pkt))
+	require.Equal(t, GetRequest, pkt.PDUType)
+	require.Empty(t, pkt.Variables)
+	require.Equal(t, "ctx", pkt.ContextName)
+
+	x.Version = Version2c
+	_, err = x.BuildDiscoveryPacket()
+	require.Error(t, err)
+}
+
+func TestLongOctetStringRoundTrip(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+	for _, tt := range []struct {
+		size        int
+		lengthBytes []byte
+	}{
+		{300, []byte{0x82, 0x01, 0x2c}},
+		{70000, []byte{0x83, 0x01, 0x11, 0x70}},
+	} {
+		value := bytes.Repeat([]byte("abcdefghij"), tt.size/10)
+		pdu := SnmpPDU{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: value}
+
+		vb, err := marshalVarbind(&pdu)
+		require.NoError(t, err)
+		header := append([]byte{byte(OctetString)}, tt.lengthBytes...)
+		require.True(t, bytes.HasSuffix(vb[:len(vb)-tt.size], header), "size %d: % x", tt.size, vb[:len(vb)-tt.size])
+
+		pkt := &SnmpPacket{
+			Version:   Version2c,
+			Community: "public",
+			PDUType:   GetResponse,
+			RequestID: 1,
+			Variables: []SnmpPDU{pdu},
+		}
+		b, err := pkt.marshalMsg()
+		require.NoError(t, err)
+
+		result, err := x.SnmpDecodePacket(b)
+		require.NoError(t, err, "size %d", tt.size)
+		require.Len(t, result.Variables, 1)
+		require.Equal(t, value, result.Variables[0].Value, "size %d", tt.size)
+
+		// truncated length octets must fail rather than panic
+		_, err = x.SnmpDecodePacket(b[:3])
+		require.Error(t, err)
+	}
+}
+
+func TestValidateSet(t *testing.T) {
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "private",
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+
+	valid := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "router1"},
+		{Name: ".1.3.6.1.2.1.2.2.1.7.1", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.1.1.0", Type: IPAddress, Value: "192.168.1.1"},
+	}
+	require.NoError(t, x.ValidateSet(valid))
+
+	for name, invalid := range map[string][]SnmpPDU{
+		"empty":        nil,
+		"type":         {{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(1)}},
+		"oid":          {{Name: ".1.3.x.1", Type: Integer, Value: 1}},
+		"value":        {{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: 5}},
+		"second value": {valid[0], {Name: ".1.3.6.1.2.1.2.2.1.7.1", Type: Integer, Value: "up"}},
+	} {
+		require.Error(t, x.ValidateSet(invalid), name)
+	}
+}
+
+func TestReuseWalkBuffers(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 20; i++ {
+		mib = append(mib, SnmpPDU{
+			Name:  fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i),
+			Type:  OctetString,
+			Value: []byte(fmt.Sprintf("eth%d", i)),
+		})
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+	x.MaxRepetitions = 2
+
+	walk := func() {
+		i := 0
+		err := x.BulkWalk(".1.3.6.1.2.1.2.2", func(pdu SnmpPDU) error {
+			i++
+			if string(pdu.Value.([]byte)) != fmt.Sprintf("eth%d", i) {
+				return fmt.Errorf("unexpected value %s for %s", pdu.Value, pdu.Name)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 20, i)
+	}
+
+	allocs := testing.AllocsPerRun(5, walk)
+	x.ReuseWalkBuffers = true
+	reuseAllocs := testing.AllocsPerRun(5, walk)
+	t.Logf("allocations per walk: %.0f, reusing buffers: %.0f", allocs, reuseAllocs)
+	require.Less(t, reuseAllocs, allocs)
+
+	// retained results are not affected
+	results, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2")
+	require.NoError(t, err)
+	require.Len(t, results, 20)
+	require.Equal(t, []byte("eth1"), results[0].Value)
+}
+
+func TestSendWithMsgFlags(t *testing.T) {
+	agentUsp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "privpassword",
+		AuthoritativeEngineID:    "testengine",
+		AuthoritativeEngineBoots: 3,
+		AuthoritativeEngineTime:  100,
+		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	require.NoError(t, agentUsp.initSecurityKeys())
+
+	var mu sync.Mutex
+	var levels []SnmpV3MsgFlags
+	srvr := startTestAgentUsm(t, agentUsp, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:            Version3,
+			MsgID:              req.MsgID,
+			MsgFlags:           req.MsgFlags & AuthPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: agentUsp.Copy(),
+			ContextEngineID:    "testengine",
+			PDUType:            GetResponse,
+			Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("agent")}},
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			rsp.MsgFlags = NoAuthNoPriv
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+			return rsp
+		}
+		mu.Lock()
+		levels = append(levels, req.MsgFlags&AuthPriv)
+		mu.Unlock()
+		if err := rsp.SecurityParameters.initPacket(rsp); err != nil {
+			t.Errorf("initPacket: %s", err)
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Retries:       2,
+		MaxOids:       MaxOids,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: Null}}
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("agent"), result.Variables[0].Value)
+	require.Equal(t, AuthNoPriv, result.SentMsgFlags&AuthPriv)
+
+	result, err = x.SendWithMsgFlags(GetRequest, pdus, 0, 0, AuthPriv)
+	require.NoError(t, err)
+	require.Equal(t, []byte("agent"), result.Variables[0].Value)
+	require.Equal(t, AuthPriv, result.SentMsgFlags&AuthPriv)
+	require.Equal(t, AuthNoPriv, x.MsgFlags&AuthPriv, "override must not reconfigure the connection")
+
+	mu.Lock()
+	require.Equal(t, []SnmpV3MsgFlags{AuthNoPriv, AuthPriv}, levels)
+	mu.Unlock()
+
+	x.SecurityParameters.(*UsmSecurityParameters).PrivacyProtocol = NoPriv
+	_, err = x.SendWithMsgFlags(GetRequest, pdus, 0, 0, AuthPriv)
+	require.Error(t, err)
+
+	v2 := &GoSNMP{Version: Version2c, MaxOids: MaxOids}
+	_, err = v2.SendWithMsgFlags(GetRequest, pdus, 0, 0, AuthPriv)
+	require.Error(t, err)
+}
+
+func TestGetBulkMap(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 6},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	vars, err := x.GetBulkMap([]string{".1.3.6.1.2.1.2.2.1.2"}, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, vars, 2)
+	require.Equal(t, []byte("eth0"), vars[".1.3.6.1.2.1.2.2.1.2.1"].Value)
+	require.Equal(t, []byte("eth1"), vars[".1.3.6.1.2.1.2.2.1.2.2"].Value)
+
+	dup, closeDup := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return &SnmpPacket{
+			Version:   Version2c,
+			Community: "public",
+			PDUType:   GetResponse,
+			Variables: []SnmpPDU{
+				{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("first")},
+				{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("last")},
+			},
+		}
+	})
+	defer closeDup()
+
+	vars, err = dup.GetBulkMap([]string{".1.3.6.1.2.1.1.1"}, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, vars, 1)
+	require.Equal(t, []byte("last"), vars[".1.3.6.1.2.1.1.1.0"].Value)
+}
+
+func TestGetTryCommunities(t *testing.T) {
+	mib := testMib{{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")}}
+	var mu sync.Mutex
+	var tried []string
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		tried = append(tried, req.Community)
+		mu.Unlock()
+		switch req.Community {
+		case "private":
+			return mib.handle(req)
+		case "denied":
+			rsp := mib.handle(req)
+			rsp.Error = AuthorizationError
+			return rsp
+		}
+		return nil
+	})
+	defer closeFn()
+	x.Retries = 0
+
+	result, community, err := x.GetTryCommunities([]string{".1.3.6.1.2.1.1.1.0"}, []string{"public", "denied", "private", "unused"})
+	require.NoError(t, err)
+	require.Equal(t, "private", community)
+	require.Equal(t, []byte("descr"), result.Variables[0].Value)
+	require.Equal(t, "public", x.Community)
+	mu.Lock()
+	require.Equal(t, []string{"public", "denied", "private"}, tried)
+	mu.Unlock()
+
+	_, _, err = x.GetTryCommunities([]string{".1.3.6.1.2.1.1.1.0"}, []string{"public"})
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Contains(t, err.Error(), "request timeout (after 0 retries)")
+}
+
+func TestWalkMaxBytes(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 10; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.1.9.1.3.%d", i), Type: OctetString, Value: bytes.Repeat([]byte{'x'}, 1000)})
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+	x.MaxRepetitions = 2
+
+	results, err := x.BulkWalkAll(".1.3.6.1.2.1.1.9")
+	require.NoError(t, err)
+	require.Len(t, results, 10)
+
+	x.WalkMaxBytes = 3000
+	walked := 0
+	err = x.BulkWalk(".1.3.6.1.2.1.1.9", func(SnmpPDU) error {
+		walked++
+		return nil
+	})
+	require.ErrorIs(t, err, ErrWalkMaxBytes)
+	require.Equal(t, 2, walked, "the response over budget must not be handed to the WalkFunc")
+}
+
+func TestCounter32AsGauge32(t *testing.T) {
+	pkt := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   GetResponse,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.4.1.9999.1.1.0", Type: Counter32, Value: uint32(42)},
+			{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: Counter32, Value: uint32(7)},
+		},
+	}
+	b, err := pkt.marshalMsg()
+	require.NoError(t, err)
+
+	x := &GoSNMP{Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+	result, err := x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, Counter32, result.Variables[0].Type)
+
+	x.Counter32AsGauge32 = []string{"1.3.6.1.4.1.9999"}
+	result, err = x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, Gauge32, result.Variables[0].Type)
+	require.Equal(t, uint(42), result.Variables[0].Value)
+	require.Equal(t, Counter32, result.Variables[1].Type)
+
+	x.Counter32AsGauge32 = []string{"."}
+	result, err = x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, Gauge32, result.Variables[0].Type)
+	require.Equal(t, Gauge32, result.Variables[1].Type)
+}
+
+func TestRequestIDSeeding(t *testing.T) {
+	idSequence := func(source io.Reader) []uint32 {
+		x := &GoSNMP{
+			Version:      Version2c,
+			Community:    "public",
+			Target:       "127.0.0.1",
+			Port:         161,
+			Logger:       NewLogger(log.New(ioutil.Discard, "", 0)),
+			RandomSource: source,
+		}
+		require.NoError(t, x.Connect())
+		defer x.Conn.Close()
+
+		var ids []uint32
+		for i := 0; i < 3; i++ {
+			b, err := x.SnmpEncodePacket(GetRequest, []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null}}, 0, 0)
+			require.NoError(t, err)
+			pkt, err := x.SnmpDecodePacket(b)
+			require.NoError(t, err)
+			ids = append(ids, pkt.RequestID)
+		}
+		return ids
+	}
+
+	require.NotEqual(t, idSequence(nil), idSequence(nil))
+
+	seed := []byte{0x12, 0x34, 0x56, 0x78}
+	fixed := idSequence(bytes.NewReader(seed))
+	require.Equal(t, fixed, idSequence(bytes.NewReader(seed)))
+	require.Equal(t, fixed[0]+1, fixed[1])
+}
+
+func TestUnmarshalSecurityModel(t *testing.T) {
+	pkt := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{UserName: "test", AuthoritativeEngineID: "testengine"},
+		PDUType:            GetRequest,
+		Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null}},
+	}
+	b, err := pkt.marshalMsg()
+	require.NoError(t, err)
+
+	x := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{UserName: "test"},
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	result, err := x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, UserSecurityModel, result.SecurityModel)
+	require.Equal(t, "test", result.SecurityParameters.(*UsmSecurityParameters).UserName)
+
+	pkt.SecurityModel = 2 // SNMPv2c community-based, not a v3 model
+	b, err = pkt.marshalMsg()
+	require.NoError(t, err)
+	_, err = x.SnmpDecodePacket(b)
+	require.ErrorIs(t, err, ErrUnknownSecurityModels)
+}
+
+func TestMaxInflightSends(t *testing.T) {
+	var received int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&received, 1)
+		return nil // every request is lost
+	})
+	defer closeFn()
+
+	const timeout = 40 * time.Millisecond
+	x.Timeout = timeout
+	x.Retries = 1
+	x.MaxInflightSends = 2
+	x.Conn.Close()
+	require.NoError(t, x.Connect())
+
+	var maxOutstanding int32
+	x.PreSend = func(x *GoSNMP) {
+		outstanding := int32(len(x.sendSlots))
+		for {
+			prev := atomic.LoadInt32(&maxOutstanding)
+			if outstanding <= prev || atomic.CompareAndSwapInt32(&maxOutstanding, prev, outstanding) {
+				break
+			}
+		}
+	}
+
+	const requests = 4
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&maxOutstanding))
+	// 8 attempts each waiting out its timeout, at most 2 at a time
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(requests*(x.Retries+1)/x.MaxInflightSends)*int64(timeout))
+	require.Len(t, x.sendSlots, 0, "slots must be released")
+	require.Equal(t, int32(requests*(x.Retries+1)), atomic.LoadInt32(&received))
+}
+
+func TestIntegerAsIPAddress(t *testing.T) {
+	pkt := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   GetResponse,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.4.1.9999.2.1.0", Type: Integer, Value: int(int32(-0x3f57fef6))}, // 0xc0a8010a
+			{Name: ".1.3.6.1.4.1.9999.2.2.0", Type: Integer, Value: 0x0a01a8c0},
+			{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+		},
+	}
+	b, err := pkt.marshalMsg()
+	require.NoError(t, err)
+
+	x := &GoSNMP{
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+		IntegerAsIPAddress: []string{".1.3.6.1.4.1.9999.2.1.0"},
+	}
+	result, err := x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, SnmpPDU{Name: ".1.3.6.1.4.1.9999.2.1.0", Type: IPAddress, Value: "192.168.1.10"}, result.Variables[0])
+	require.Equal(t, Integer, result.Variables[1].Type)
+	require.Equal(t, Integer, result.Variables[2].Type)
+
+	x.IntegerAsIPAddress = []string{".1.3.6.1.4.1.9999.2.2"}
+	x.IntegerIPByteOrder = binary.LittleEndian
+	result, err = x.SnmpDecodePacket(b)
+	require.NoError(t, err)
+	require.Equal(t, SnmpPDU{Name: ".1.3.6.1.4.1.9999.2.2.0", Type: IPAddress, Value: "192.168.1.10"}, result.Variables[1])
+}
+
+func TestWrongDigestRelocalizesKeys(t *testing.T) {
+	agentUsp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassword",
+		AuthoritativeEngineID:    "newengine",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  10,
+		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	require.NoError(t, agentUsp.initSecurityKeys())
+
+	var served int32
+	srvr := startTestAgentUsm(t, agentUsp, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&served, 1)
+		return &SnmpPacket{
+			Version:            Version3,
+			MsgID:              req.MsgID,
+			MsgFlags:           AuthNoPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: agentUsp.Copy(),
+			ContextEngineID:    "newengine",
+			PDUType:            GetResponse,
+			Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("agent")}},
+		}
+	})
+	defer srvr.Close()
+
+	// keys localized for the engine the agent had before its engine ID changed
+	staleKey, err := genlocalkey(StdlibCryptoProvider{}, SHA, "authpassword", "oldengine")
+	require.NoError(t, err)
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Retries:       0,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			AuthoritativeEngineID:    "newengine",
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  10,
+			SecretKey:                staleKey,
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("agent"), result.Variables[0].Value)
+	require.Equal(t, int32(1), atomic.LoadInt32(&served), "only the retry should pass authentication")
+	require.Equal(t, agentUsp.SecretKey, x.SecurityParameters.(*UsmSecurityParameters).SecretKey)
+
+	// without passphrases the keys can't be re-localized
+	x.SecurityParameters.(*UsmSecurityParameters).SecretKey = staleKey
+	x.SecurityParameters.(*UsmSecurityParameters).AuthenticationPassphrase = ""
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrWrongDigest)
+}
+
+func TestEmptyCommunity(t *testing.T) {
+	pkt := &SnmpPacket{
+		Version:   Version2c,
+		Community: "",
+		PDUType:   GetRequest,
+		Variables: []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null}},
+	}
+	b, err := pkt.marshalMsg()
+	require.NoError(t, err)
+	// sequence header, version 2c, then a zero-length community
+	require.Equal(t, []byte{0x02, 0x01, 0x01, 0x04, 0x00}, b[2:7])
+
+	mib := testMib{{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")}}
+	var mu sync.Mutex
+	communities := []string{}
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		communities = append(communities, req.Community)
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	defer closeFn()
+	x.Community = ""
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.NoError(t, err)
+	require.Equal(t, "", result.Community)
+	mu.Lock()
+	require.Equal(t, []string{""}, communities)
+	mu.Unlock()
+}
+
+func TestGetBulkN(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 100; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.1.%d", i), Type: Integer, Value: i})
+	}
+	for i := 1; i <= 3; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i), Type: OctetString, Value: []byte("if")})
+	}
+	mib = append(mib, SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 6})
+	var maxReps uint32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.StoreUint32(&maxReps, req.MaxRepetitions)
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	pdus, err := x.GetBulkN(".1.3.6.1.2.1.2.2.1.1", 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(10), atomic.LoadUint32(&maxReps))
+	require.Len(t, pdus, 10)
+	require.Equal(t, ".1.3.6.1.2.1.2.2.1.1.1", pdus[0].Name)
+	require.Equal(t, ".1.3.6.1.2.1.2.2.1.1.10", pdus[9].Name)
+
+	pdus, err = x.GetBulkN("1.3.6.1.2.1.2.2.1.2", 10)
+	require.NoError(t, err)
+	require.Len(t, pdus, 3, "entries leaving the subtree are trimmed")
+	require.Equal(t, ".1.3.6.1.2.1.2.2.1.2.3", pdus[2].Name)
+
+	pdus, err = x.GetBulkN(".1.3.6.1.2.1.2.2.1.3", 10)
+	require.NoError(t, err)
+	require.Len(t, pdus, 1, "the MIB ends")
+}
+
+func TestWalkDepth(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.9999.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.9999.2.1", Type: Integer, Value: 21},
+		{Name: ".1.3.6.1.4.1.9999.2.2.1", Type: Integer, Value: 221},
+		{Name: ".1.3.6.1.4.1.9999.3", Type: Integer, Value: 3},
+		{Name: ".1.3.6.1.4.1.9999.3.1", Type: Integer, Value: 31},
+		{Name: ".1.3.6.1.4.1.10000.1", Type: Integer, Value: 0},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+	x.MaxRepetitions = 2
+
+	walked := func(maxDepth int) []string {
+		var names []string
+		err := x.WalkDepth("1.3.6.1.4.1.9999", maxDepth, func(pdu SnmpPDU) error {
+			names = append(names, pdu.Name)
+			return nil
+		})
+		require.NoError(t, err)
+		return names
+	}
+	require.Equal(t, []string{".1.3.6.1.4.1.9999.1", ".1.3.6.1.4.1.9999.3"}, walked(1))
+	require.Equal(t, []string{".1.3.6.1.4.1.9999.1", ".1.3.6.1.4.1.9999.2.1", ".1.3.6.1.4.1.9999.3", ".1.3.6.1.4.1.9999.3.1"}, walked(2))
+	require.Len(t, walked(3), 5)
+}
+
+func TestTransportFallback(t *testing.T) {
+	tcpSrvr, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("tcp4 error listening: %s", err)
+	}
+	defer tcpSrvr.Close()
+	port := tcpSrvr.Addr().(*net.TCPAddr).Port
+	udpSrvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Skipf("udp4 port %d in use: %s", port, err)
+	}
+	defer udpSrvr.Close()
+
+	x := &GoSNMP{
+		Version:           Version2c,
+		Community:         "public",
+		Target:            "127.0.0.1",
+		Port:              uint16(port),
+		Timeout:           time.Second,
+		TransportFallback: true,
+		Logger:            NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	var mu sync.Mutex
+	requestIDs := make(map[uint32]int)
+	respond := func(req []byte) []byte {
+		var reqPkt SnmpPacket
+		cursor, err := x.unmarshalHeader(req, &reqPkt)
+		if err != nil {
+			t.Errorf("error: %s", err)
+			return nil
+		}
+		if err = x.unmarshalPayload(req, cursor, &reqPkt); err != nil {
+			t.Errorf("error: %s", err)
+			return nil
+		}
+		mu.Lock()
+		requestIDs[reqPkt.RequestID]++
+		mu.Unlock()
+		rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
+			{Name: ".1.2", Type: OctetString, Value: payload},
+		}, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return nil
+		}
+		return outBuf
+	}
+
+	var udpRequests, tcpRequests int32
+	go func() {
+		buf := make([]byte, rxBufSize)
+		for {
+			n, addr, err := udpSrvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&udpRequests, 1)
+			// the response is cut short, as by a middlebox dropping fragments
+			if outBuf := respond(buf[:n]); outBuf != nil {
+				udpSrvr.WriteTo(outBuf[:len(outBuf)/2], addr)
+			}
+		}
+	}()
+	go func() {
+		conn, err := tcpSrvr.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, rxBufSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Errorf("error reading request: %s", err)
+			return
+		}
+		atomic.AddInt32(&tcpRequests, 1)
+		if outBuf := respond(buf[:n]); outBuf != nil {
+			conn.Write(outBuf)
+		}
+	}()
+
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	result, err := x.Get([]string{".1.2"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	assert.Equal(t, payload, result.Variables[0].Value)
+	assert.NotZero(t, atomic.LoadInt32(&udpRequests))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tcpRequests))
+	assert.Equal(t, "udp", x.Transport)
+
+	x.TransportFallback = false
+	_, err = x.Get([]string{".1.2"})
+	assert.ErrorIs(t, err, ErrTruncatedResponse)
+
+	// the request over TCP used the next request ID, which the following
+	// UDP request must not reuse
+	mu.Lock()
+	defer mu.Unlock()
+	for requestID, count := range requestIDs {
+		assert.Equal(t, 1, count, "request ID %d", requestID)
+	}
+}
+
+func TestNewOIDVarbind(t *testing.T) {
+	for _, bad := range []string{"", "1", "1.3.x", "7.1", "1.3.-6", "1.3.4294967296"} {
+		_, err := NewOIDVarbind(".1.3.6.1.4.1.9999.1.0", bad)
+		assert.Error(t, err, bad)
+	}
+
+	mib := testMib{{Name: ".1.3.6.1.4.1.9999.1.0", Type: ObjectIdentifier, Value: ".0.0"}}
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		if req.PDUType == SetRequest {
+			for _, v := range req.Variables {
+				for i := range mib {
+					if mib[i].Name == v.Name {
+						mib[i] = v
+					}
+				}
+			}
+			return &SnmpPacket{Version: req.Version, Community: req.Community, PDUType: GetResponse, Variables: req.Variables}
+		}
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	pdu, err := NewOIDVarbind(".1.3.6.1.4.1.9999.1.0", "1.3.6.1.2.1.2.2.1.1.3")
+	require.NoError(t, err)
+	assert.Equal(t, SnmpPDU{Name: ".1.3.6.1.4.1.9999.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.2.1.2.2.1.1.3"}, pdu)
+	_, err = x.Set([]SnmpPDU{pdu})
+	require.NoError(t, err)
+
+	result, err := x.Get([]string{".1.3.6.1.4.1.9999.1.0"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	assert.Equal(t, ObjectIdentifier, result.Variables[0].Type)
+	assert.Equal(t, ".1.3.6.1.2.1.2.2.1.1.3", result.Variables[0].Value)
+}
+
+func TestOIDRewrite(t *testing.T) {
+	const logical, vendor = ".1.3.6.1.4.1.1", ".1.3.6.1.4.1.9999.7"
+	mib := testMib{
+		{Name: vendor + ".1.0", Type: Integer, Value: 1},
+		{Name: vendor + ".2.0", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.10000.1.0", Type: Integer, Value: 0},
+	}
+	var mu sync.Mutex
+	var requested []string
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		for _, v := range req.Variables {
+			requested = append(requested, v.Name)
+		}
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	// takeRequested returns the OIDs requested so far and resets them
+	takeRequested := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := requested
+		requested = nil
+		return taken
+	}
+	defer closeFn()
+	x.OIDRewrite = func(oid string) string {
+		if strings.HasPrefix(oid, logical+".") || oid == logical {
+			return vendor + strings.TrimPrefix(oid, logical)
+		}
+		return oid
+	}
+
+	result, err := x.Get([]string{logical + ".1.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{vendor + ".1.0"}, takeRequested())
+	require.Len(t, result.Variables, 1)
+	assert.Equal(t, vendor+".1.0", result.Variables[0].Name)
+
+	_, err = x.GetNext([]string{logical + ".1.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{vendor + ".1.0"}, takeRequested())
+
+	for _, bulk := range []bool{false, true} {
+		var walked []string
+		walkFn := func(pdu SnmpPDU) error {
+			walked = append(walked, pdu.Name)
+			return nil
+		}
+		if bulk {
+			err = x.BulkWalk(logical, walkFn)
+		} else {
+			err = x.Walk(logical, walkFn)
+		}
+		require.NoError(t, err)
+		assert.Equal(t, vendor, takeRequested()[0])
+		assert.Equal(t, []string{vendor + ".1.0", vendor + ".2.0"}, walked)
+	}
+}
+
+func TestInterfaceTable(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("host")},
+		{Name: ".1.3.6.1.2.1.2.1.0", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.3", Type: Integer, Value: 3},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 24},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.3", Type: Integer, Value: 6},
+		{Name: ".1.3.6.1.2.1.2.2.1.4.1", Type: Integer, Value: 65536},
+		{Name: ".1.3.6.1.2.1.2.2.1.4.3", Type: Integer, Value: 1500},
+		{Name: ".1.3.6.1.2.1.2.2.1.5.1", Type: Gauge32, Value: uint(10000000)},
+		{Name: ".1.3.6.1.2.1.2.2.1.5.3", Type: Gauge32, Value: uint(4294967295)},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.3", Type: OctetString, Value: []byte{0, 1, 2, 3, 4, 5}},
+		{Name: ".1.3.6.1.2.1.2.2.1.7.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.7.3", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.3", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: Counter32, Value: uint(100)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.3", Type: Counter32, Value: uint(200)},
+		{Name: ".1.3.6.1.2.1.2.2.1.16.1", Type: Counter32, Value: uint(100)},
+		{Name: ".1.3.6.1.2.1.2.2.1.16.3", Type: Counter32, Value: uint(300)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.3", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: Counter64, Value: uint64(100)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.3", Type: Counter64, Value: uint64(1 << 40)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.10.1", Type: Counter64, Value: uint64(100)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.10.3", Type: Counter64, Value: uint64(1 << 41)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.15.1", Type: Gauge32, Value: uint(10)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.15.3", Type: Gauge32, Value: uint(10000)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.18.3", Type: OctetString, Value: []byte("uplink")},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	interfaces, err := x.InterfaceTable()
+	require.NoError(t, err)
+	require.Equal(t, []Interface{
+		{Index: 1, Descr: "lo", Name: "lo", Type: 24, MTU: 65536, Speed: 10000000,
+			AdminStatus: 1, OperStatus: 1, InOctets: 100, OutOctets: 100},
+		{Index: 3, Descr: "eth0", Name: "eth0", Type: 6, MTU: 1500, Speed: 10000000000,
+			AdminStatus: 1, OperStatus: 2, InOctets: 1 << 40, OutOctets: 1 << 41},
+	}, interfaces)
+}
+
+func TestLeadingZeroOID(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.99.1.0", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.99.2.0", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.100.1.0", Type: Integer, Value: 3},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	result, err := x.Get([]string{".1.3.06.1.4.1.099.1.0"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	assert.Equal(t, ".1.3.6.1.4.1.99.1.0", result.Variables[0].Name)
+	assert.Equal(t, 1, result.Variables[0].Value)
+
+	for _, bulk := range []bool{false, true} {
+		var walked []string
+		walkFn := func(pdu SnmpPDU) error {
+			walked = append(walked, pdu.Name)
+			return nil
+		}
+		if bulk {
+			err = x.BulkWalk(".1.3.06.1.4.1.099", walkFn)
+		} else {
+			err = x.Walk(".1.3.06.1.4.1.099", walkFn)
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []string{".1.3.6.1.4.1.99.1.0", ".1.3.6.1.4.1.99.2.0"}, walked)
+	}
+
+	_, err = x.Get([]string{".1.3.6.1.4.1.0x99.1.0"})
+	assert.Error(t, err)
+}
+
+func TestErrStopWalk(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: OctetString, Value: []byte("eth1")},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	var walked []string
+	walkFn := func(pdu SnmpPDU) error {
+		walked = append(walked, pdu.Name)
+		if string(pdu.Value.([]byte)) == "eth0" {
+			return ErrStopWalk
+		}
+		return nil
+	}
+	want := []string{".1.3.6.1.2.1.2.2.1.2.1", ".1.3.6.1.2.1.2.2.1.2.2"}
+
+	require.NoError(t, x.BulkWalk(".1.3.6.1.2.1.2.2.1.2", walkFn))
+	assert.Equal(t, want, walked)
+
+	walked = nil
+	require.NoError(t, x.Walk(".1.3.6.1.2.1.2.2.1.2", walkFn))
+	assert.Equal(t, want, walked)
+
+	// from the OIDs cached by an earlier walk
+	x.NextCacheTTL = time.Minute
+	err := x.Walk(".1.3.6.1.2.1.2.2.1.2", func(SnmpPDU) error { return nil })
+	require.NoError(t, err)
+	walked = nil
+	require.NoError(t, x.Walk(".1.3.6.1.2.1.2.2.1.2", walkFn))
+	assert.Equal(t, want, walked)
+
+	other := errors.New("other")
+	err = x.BulkWalk(".1.3.6.1.2.1.2.2.1.2", func(SnmpPDU) error { return other })
+	assert.Equal(t, other, err)
+}
+
+func TestGetTable(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.99.1.1.2.1.1", Type: OctetString, Value: []byte("a")},
+		{Name: ".1.3.6.1.4.1.99.1.1.2.2.7", Type: OctetString, Value: []byte("b")},
+		{Name: ".1.3.6.1.4.1.99.1.1.2.10.1", Type: OctetString, Value: []byte("c")},
+		{Name: ".1.3.6.1.4.1.99.1.1.3.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.99.1.1.3.9.1", Type: Integer, Value: 9},
+		{Name: ".1.3.6.1.4.1.99.1.1.3.10.1", Type: Integer, Value: 10},
+		{Name: ".1.3.6.1.4.1.99.1.1.4.1.1", Type: Integer, Value: 4},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	for _, concurrency := range []int{0, 2} {
+		x.TableConcurrency = concurrency
+		rows, err := x.GetTable(".1.3.6.1.4.1.99.1", []string{"1.2", ".1.3.6.1.4.1.99.1.1.3"})
+		require.NoError(t, err)
+
+		var names [][]string
+		for _, row := range rows {
+			var rowNames []string
+			for _, column := range []string{"1.2", ".1.3.6.1.4.1.99.1.1.3"} {
+				if pdu, ok := row[column]; ok {
+					rowNames = append(rowNames, pdu.Name)
+				}
+			}
+			names = append(names, rowNames)
+		}
+		require.Equal(t, [][]string{
+			{".1.3.6.1.4.1.99.1.1.2.1.1", ".1.3.6.1.4.1.99.1.1.3.1.1"},
+			{".1.3.6.1.4.1.99.1.1.2.2.7"},
+			{".1.3.6.1.4.1.99.1.1.3.9.1"},
+			{".1.3.6.1.4.1.99.1.1.2.10.1", ".1.3.6.1.4.1.99.1.1.3.10.1"},
+		}, names, "concurrency %d", concurrency)
+		require.Equal(t, 10, rows[3][".1.3.6.1.4.1.99.1.1.3"].Value)
+	}
+
+	// without the leading dot, as accepted by Walk and BulkWalk
+	x.TableConcurrency = 0
+	rows, err := x.GetTable("1.3.6.1.4.1.99.1", []string{"1.2", ".1.3.6.1.4.1.99.1.1.3."})
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	require.Equal(t, ".1.3.6.1.4.1.99.1.1.2.1.1", rows[0]["1.2"].Name)
+	require.Equal(t, 10, rows[3][".1.3.6.1.4.1.99.1.1.3."].Value)
+
+	_, err = x.GetTable(".1.3.6.1.4.1.99.1", nil)
+	require.Error(t, err)
+}
+
+func TestOnFullTreeWalk(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("host")},
+	}
+	var requests int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&requests, 1)
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	calls := 0
+	x.OnFullTreeWalk = func(*GoSNMP) error {
+		calls++
+		return nil
+	}
+	results, err := x.BulkWalkAll("")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, 1, calls)
+
+	_, err = x.WalkAll(".1.3.6.1.2.1.1")
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	errFullTree := errors.New("full tree walk")
+	x.OnFullTreeWalk = func(*GoSNMP) error {
+		return errFullTree
+	}
+	atomic.StoreInt32(&requests, 0)
+	_, err = x.WalkAll(".")
+	require.ErrorIs(t, err, errFullTree)
+	require.Zero(t, atomic.LoadInt32(&requests))
+}
+
+func TestSetChunked(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	fail := false
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		defer mu.Unlock()
+		resp := &SnmpPacket{Version: req.Version, Community: req.Community, PDUType: GetResponse, Variables: req.Variables}
+		if req.PDUType == SetRequest {
+			sizes = append(sizes, len(req.Variables))
+			if fail && len(sizes) == 2 {
+				resp.Error = NotWritable
+				resp.ErrorIndex = 1
+			}
+		}
+		return resp
+	})
+	defer closeFn()
+	x.MaxOids = 2
+	// takeSizes returns the sizes of the SET requests so far and resets them
+	takeSizes := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		taken := sizes
+		sizes = nil
+		return taken
+	}
+
+	var updates []SnmpPDU
+	for i := 1; i <= 5; i++ {
+		updates = append(updates, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.9999.%d.0", i), Type: Integer, Value: i})
+	}
+	results, err := x.SetChunked(updates)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, []int{2, 2, 1}, takeSizes())
+	require.Equal(t, updates[4].Name, results[2].Variables[0].Name)
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	results, err = x.SetChunked(updates)
+	require.Error(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, NotWritable, results[1].Error)
+	require.Equal(t, []int{2, 2}, takeSizes())
+}
+
+func TestConnectPort(t *testing.T) {
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return &SnmpPacket{Version: req.Version, Community: req.Community, PDUType: GetResponse, Variables: req.Variables}
+	})
+	defer srvr.Close()
+
+	port := uint16(srvr.LocalAddr().(*net.UDPAddr).Port)
+	require.NotEqual(t, uint16(161), port)
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Target:    "127.0.0.1",
+		Port:      port,
+		Timeout:   time.Second,
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+	require.Equal(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), x.Conn.RemoteAddr().String())
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+
+	// a zero port is the default one of the transport
+	overUDP := &GoSNMP{Target: "127.0.0.1", Logger: x.Logger}
+	require.NoError(t, overUDP.Connect())
+	defer overUDP.Conn.Close()
+	require.Equal(t, uint16(161), overUDP.Port)
+	require.Equal(t, "127.0.0.1:161", overUDP.Conn.RemoteAddr().String())
+
+	overTLS := &GoSNMP{Target: "127.0.0.1", Transport: "tls"}
+	require.NoError(t, overTLS.validateParameters())
+	require.Equal(t, uint16(10161), overTLS.Port)
+}
+
+func TestChunkDelay(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		return &SnmpPacket{Version: req.Version, Community: req.Community, PDUType: GetResponse, Variables: req.Variables}
+	})
+	defer closeFn()
+	x.MaxOids = 2
+	x.ChunkDelay = 50 * time.Millisecond
+
+	var oids []string
+	for i := 1; i <= 5; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.4.1.9999.%d.0", i))
+	}
+	results, err := x.GetChunked(oids)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, oids[4], results[2].Variables[0].Name)
+	mu.Lock()
+	require.Len(t, arrivals, 3)
+	for i := 1; i < len(arrivals); i++ {
+		require.GreaterOrEqual(t, int64(arrivals[i].Sub(arrivals[i-1])), int64(40*time.Millisecond))
+	}
+	mu.Unlock()
+
+	// a cancelled context ends the wait between chunks
+	ctx, cancel := context.WithCancel(context.Background())
+	x.Context = ctx
+	x.ChunkDelay = time.Hour
+	time.AfterFunc(50*time.Millisecond, cancel)
+	results, err = x.GetChunked(oids)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, results, 1)
+}
+
+func TestRequestIDMismatch(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Target:    srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:      uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:   time.Second,
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	// answer each request with a stray response first, then the right one
+	go func() {
+		buf := make([]byte, rxBufSize)
+		for {
+			n, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var reqPkt SnmpPacket
+			cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+			if err != nil {
+				t.Errorf("error: %s", err)
+				continue
+			}
+			if err = x.unmarshalPayload(buf[:n], cursor, &reqPkt); err != nil {
+				t.Errorf("error: %s", err)
+				continue
+			}
+			for _, reqID := range []uint32{reqPkt.RequestID + 1000, reqPkt.RequestID} {
+				rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
+					{Name: ".1.2", Type: Integer, Value: int(reqID - reqPkt.RequestID)},
+				}, 0, 0)
+				rspPkt.RequestID = reqID
+				outBuf, err := rspPkt.marshalMsg()
+				if err != nil {
+					t.Errorf("ERR: %s", err)
+					continue
+				}
+				srvr.WriteTo(outBuf, addr)
+			}
+		}
+	}()
+
+	result, err := x.Get([]string{".1.2"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	require.Equal(t, 0, result.Variables[0].Value)
+
+	x.FailOnRequestIDMismatch = true
+	_, err = x.Get([]string{".1.2"})
+	require.ErrorIs(t, err, ErrRequestIDMismatch)
+}
+
+func TestNewV3WithKeys(t *testing.T) {
+	agentUsp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "privpassword",
+		AuthoritativeEngineID:    "engine",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  10,
+		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	require.NoError(t, agentUsp.initSecurityKeys())
+
+	srvr := startTestAgentUsm(t, agentUsp, func(req *SnmpPacket) *SnmpPacket {
+		return &SnmpPacket{
+			Version:            Version3,
+			MsgID:              req.MsgID,
+			MsgFlags:           AuthNoPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: agentUsp.Copy(),
+			ContextEngineID:    "engine",
+			PDUType:            GetResponse,
+			Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("agent")}},
+		}
+	})
+	defer srvr.Close()
+
+	_, err := NewV3WithKeys("127.0.0.1", "test", "", SHA, agentUsp.SecretKey, AES, agentUsp.PrivacyKey)
+	require.Error(t, err, "no engine ID")
+	_, err = NewV3WithKeys("127.0.0.1", "test", "engine", SHA, agentUsp.SecretKey, AES, nil)
+	require.Error(t, err, "no privacy key")
+
+	x, err := NewV3WithKeys(srvr.LocalAddr().(*net.UDPAddr).IP.String(), "test", "engine",
+		SHA, agentUsp.SecretKey, AES, agentUsp.PrivacyKey)
+	require.NoError(t, err)
+	require.Equal(t, AuthPriv, x.MsgFlags)
+	x.Port = uint16(srvr.LocalAddr().(*net.UDPAddr).Port)
+	x.Timeout = time.Millisecond * 100
+	x.Logger = NewLogger(log.New(ioutil.Discard, "", 0))
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("agent"), result.Variables[0].Value)
+	usp := x.SecurityParameters.(*UsmSecurityParameters)
+	require.Empty(t, usp.AuthenticationPassphrase)
+	require.Empty(t, usp.PrivacyPassphrase)
+	require.Equal(t, agentUsp.SecretKey, usp.SecretKey)
+	require.Equal(t, agentUsp.PrivacyKey, usp.PrivacyKey)
+}
+
+func TestConfig(t *testing.T) {
+	agentUsp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "privpassword",
+		AuthoritativeEngineID:    "engine",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  10,
+		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	require.NoError(t, agentUsp.initSecurityKeys())
+
+	srvr := startTestAgentUsm(t, agentUsp, func(req *SnmpPacket) *SnmpPacket {
+		rsp := &SnmpPacket{
+			Version:            Version3,
+			MsgID:              req.MsgID,
+			MsgFlags:           AuthPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: agentUsp.Copy(),
+			ContextEngineID:    "engine",
+			PDUType:            GetResponse,
+			Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("agent")}},
+		}
+		if req.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID == "" {
+			rsp.MsgFlags = NoAuthNoPriv
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+			return rsp
+		}
+		if err := rsp.SecurityParameters.initPacket(rsp); err != nil {
+			t.Errorf("initPacket: %s", err)
+		}
+		return rsp
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Retries:       2,
+		MaxOids:       10,
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      AuthPriv,
+		ContextName:   "ctx",
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+		},
+	}
+	config := x.Config()
+	require.NotSame(t, x.SecurityParameters, config.SecurityParameters)
+
+	clone := FromConfig(config)
+	require.Equal(t, config, clone.Config())
+	require.NotSame(t, config.SecurityParameters, clone.SecurityParameters)
+	require.Equal(t, x.Target, clone.Target)
+	require.Equal(t, x.Port, clone.Port)
+	require.Equal(t, x.MaxOids, clone.MaxOids)
+	require.Equal(t, x.ContextName, clone.ContextName)
+
+	require.NoError(t, clone.Connect())
+	defer clone.Conn.Close()
+	result, err := clone.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("agent"), result.Variables[0].Value)
+
+	// the engine discovered by the clone is not written back to config
+	require.Equal(t, "engine", clone.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID)
+	require.Empty(t, config.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID)
+	require.Empty(t, x.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID)
+}
+
+func TestEngineDiscoveryFailed(t *testing.T) {
+	var probes int32
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&probes, 1)
+		return nil
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:          Version3,
+		Target:           srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:             uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:          time.Second,
+		Retries:          5,
+		DiscoveryTimeout: time.Millisecond * 50,
+		DiscoveryRetries: 2,
+		Logger:           NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel:    UserSecurityModel,
+		MsgFlags:         NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName: "test",
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	start := time.Now()
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrEngineDiscoveryFailed)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, int32(3), atomic.LoadInt32(&probes))
+
+	atomic.StoreInt32(&probes, 0)
+	x.DiscoveryRetries = -1
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrEngineDiscoveryFailed)
+	require.Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func TestScopedPDURewrap(t *testing.T) {
+	newV3 := func(sp *UsmSecurityParameters, flags SnmpV3MsgFlags) *GoSNMP {
+		return &GoSNMP{
+			Version:            Version3,
+			SecurityModel:      UserSecurityModel,
+			MsgFlags:           flags,
+			SecurityParameters: sp,
+			ContextName:        "ctx",
+			Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+		}
+	}
+	inUsp := func() *UsmSecurityParameters {
+		return &UsmSecurityParameters{
+			UserName:                 "manager",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+			AuthoritativeEngineID:    "proxy",
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  10,
+		}
+	}
+	outUsp := func() *UsmSecurityParameters {
+		return &UsmSecurityParameters{
+			UserName:                 "proxy",
+			AuthenticationProtocol:   SHA256,
+			AuthenticationPassphrase: "otherpassword",
+			AuthoritativeEngineID:    "agent",
+			AuthoritativeEngineBoots: 2,
+			AuthoritativeEngineTime:  20,
+		}
+	}
+
+	manager := newV3(inUsp(), AuthPriv)
+	require.NoError(t, manager.SecurityParameters.initSecurityKeys())
+	pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("name")}}
+	msg, err := manager.SnmpEncodePacket(SetRequest, pdus, 0, 0)
+	require.NoError(t, err)
+	sent := append([]byte(nil), msg...)
+
+	proxyIn := newV3(inUsp(), AuthPriv)
+	scopedPDU, err := proxyIn.ScopedPDU(msg)
+	require.NoError(t, err)
+	require.Equal(t, sent, msg, "msg must not be modified")
+	require.Equal(t, byte(Sequence), scopedPDU[0])
+
+	proxyOut := newV3(outUsp(), AuthNoPriv)
+	wrapped, err := proxyOut.WrapScopedPDU(scopedPDU)
+	require.NoError(t, err)
+
+	agent := newV3(outUsp(), AuthNoPriv)
+	require.NoError(t, agent.SecurityParameters.initSecurityKeys())
+	// decoding zeroes the authentication parameters in place
+	decoded := append([]byte(nil), wrapped...)
+	pkt, err := agent.SnmpDecodePacket(decoded)
+	require.NoError(t, err)
+	require.Equal(t, SetRequest, pkt.PDUType)
+	require.Equal(t, "ctx", pkt.ContextName)
+	require.Equal(t, "proxy", pkt.SecurityParameters.(*UsmSecurityParameters).UserName)
+	require.Equal(t, AuthNoPriv, pkt.MsgFlags&AuthPriv)
+	require.Equal(t, pdus[0].Value, pkt.Variables[0].Value)
+	authentic, err := agent.SecurityParameters.isAuthentic(decoded, pkt)
+	require.NoError(t, err)
+	require.True(t, authentic)
+
+	rewrapped, err := agent.ScopedPDU(wrapped)
+	require.NoError(t, err)
+	require.Equal(t, scopedPDU, rewrapped)
+
+	_, err = proxyOut.WrapScopedPDU(scopedPDU[:len(scopedPDU)-1])
+	require.Error(t, err)
+	_, err = proxyIn.ScopedPDU(wrapped)
+	require.Error(t, err, "wrong user keys must fail authentication")
+}
+
+func TestDecodeSetRequestValues(t *testing.T) {
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	updates := []SnmpPDU{
+		{Name: ".1.3.6.1.4.1.9999.1.0", Type: Integer, Value: -5},
+		{Name: ".1.3.6.1.4.1.9999.2.0", Type: OctetString, Value: []byte("contact")},
+		{Name: ".1.3.6.1.4.1.9999.3.0", Type: Gauge32, Value: uint(7)},
+		{Name: ".1.3.6.1.4.1.9999.4.0", Type: IPAddress, Value: "192.0.2.1"},
+		{Name: ".1.3.6.1.4.1.9999.5.0", Type: ObjectIdentifier, Value: ".1.3.6.1.2.1.2.2.1.1.3"},
+	}
+
+	decode := func(pduType PDUType) *SnmpPacket {
+		b, err := x.SnmpEncodePacket(pduType, updates, 0, 0)
+		require.NoError(t, err)
+		pkt, err := x.SnmpDecodePacket(b)
+		require.NoError(t, err)
+		require.Equal(t, pduType, pkt.PDUType)
+		return pkt
+	}
+	request := decode(SetRequest)
+	require.Equal(t, []SnmpPDU{
+		{Name: ".1.3.6.1.4.1.9999.1.0", Type: Integer, Value: -5},
+		{Name: ".1.3.6.1.4.1.9999.2.0", Type: OctetString, Value: []byte("contact")},
+		{Name: ".1.3.6.1.4.1.9999.3.0", Type: Gauge32, Value: uint(7)},
+		{Name: ".1.3.6.1.4.1.9999.4.0", Type: IPAddress, Value: "192.0.2.1"},
+		{Name: ".1.3.6.1.4.1.9999.5.0", Type: ObjectIdentifier, Value: ".1.3.6.1.2.1.2.2.1.1.3"},
+	}, request.Variables)
+	require.Equal(t, decode(GetResponse).Variables, request.Variables)
+}
+
+func TestWalkEndSentinel(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.99.1.1", Type: OctetString, Value: []byte("row1")},
+		{Name: ".1.3.6.1.4.1.99.1.2", Type: OctetString, Value: []byte("row2")},
+		{Name: ".1.3.6.1.4.1.99.1.3", Type: OctetString, Value: []byte("END")},
+		{Name: ".1.3.6.1.4.1.99.1.4", Type: OctetString, Value: []byte("garbage")},
+		{Name: ".1.3.6.1.4.1.99.1.5", Type: OctetString, Value: []byte("garbage")},
+	}
+	var requests int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&requests, 1)
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	x.WalkEndSentinel = &SnmpPDU{Type: OctetString, Value: []byte("END")}
+	results, err := x.WalkAll(".1.3.6.1.4.1.99.1")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, []byte("row2"), results[1].Value)
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// a sentinel of another type doesn't match
+	x.WalkEndSentinel = &SnmpPDU{Type: Opaque, Value: []byte("END")}
+	results, err = x.BulkWalkAll(".1.3.6.1.4.1.99.1")
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	x.WalkEndSentinel = nil
+	results, err = x.BulkWalkAll(".1.3.6.1.4.1.99.1")
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+}
+
+func TestRetransmits(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+	}
+	var drops int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		if atomic.AddInt32(&drops, -1) >= 0 {
+			return nil
+		}
+		return mib.handle(req)
+	})
+	defer closeFn()
+	x.Timeout = 50 * time.Millisecond
+
+	for _, n := range []int32{0, 1, 2} {
+		atomic.StoreInt32(&drops, n)
+		result, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		require.NoError(t, err)
+		require.Equal(t, int(n), result.Retransmits, "%d drops", n)
+	}
+}
+
+func TestEngineDiscoveryEmptyEngineID(t *testing.T) {
+	var probes int32
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&probes, 1)
+		return &SnmpPacket{
+			Version:            Version3,
+			MsgID:              req.MsgID,
+			MsgFlags:           NoAuthNoPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{AuthoritativeEngineBoots: 3, AuthoritativeEngineTime: 100},
+			PDUType:            Report,
+			Variables:          []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}},
+		}
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Retries:       2,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrEmptyEngineID)
+	require.Equal(t, int32(1), atomic.LoadInt32(&probes))
+}
+
+func TestWalkDeny(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.99.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.99.1.2", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.99.2.1", Type: OctetString, Value: []byte("secret")},
+		{Name: ".1.3.6.1.4.1.99.2.2", Type: OctetString, Value: []byte("secret")},
+		{Name: ".1.3.6.1.4.1.99.2.3", Type: OctetString, Value: []byte("secret")},
+		{Name: ".1.3.6.1.4.1.99.3.1", Type: Integer, Value: 3},
+	}
+	var requests int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&requests, 1)
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	names := func(pdus []SnmpPDU) []string {
+		var names []string
+		for _, pdu := range pdus {
+			names = append(names, pdu.Name)
+		}
+		return names
+	}
+	allowed := []string{".1.3.6.1.4.1.99.1.1", ".1.3.6.1.4.1.99.1.2", ".1.3.6.1.4.1.99.3.1"}
+
+	x.WalkDeny = []string{"1.3.6.1.4.1.99.2"}
+	results, err := x.WalkAll(".1.3.6.1.4.1.99")
+	require.NoError(t, err)
+	require.Equal(t, allowed, names(results))
+	require.Equal(t, int32(7), atomic.LoadInt32(&requests))
+
+	atomic.StoreInt32(&requests, 0)
+	x.WalkSkipDenied = true
+	results, err = x.WalkAll(".1.3.6.1.4.1.99")
+	require.NoError(t, err)
+	require.Equal(t, allowed, names(results))
+	require.Equal(t, int32(5), atomic.LoadInt32(&requests))
+
+	results, err = x.BulkWalkAll(".1.3.6.1.4.1.99")
+	require.NoError(t, err)
+	require.Equal(t, allowed, names(results))
+
+	x.WalkAllow = []string{".1.3.6.1.4.1.99.2", ".1.3.6.1.4.1.99.3"}
+	results, err = x.BulkWalkAll(".1.3.6.1.4.1.99")
+	require.NoError(t, err)
+	require.Equal(t, []string{".1.3.6.1.4.1.99.3.1"}, names(results))
+}
+
+func TestGetRaw(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(4242)},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+
+	raw, err := x.GetRaw([]string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+
+	decoded, err := x.SnmpDecodePacket(raw)
+	require.NoError(t, err)
+	require.Equal(t, GetResponse, decoded.PDUType)
+	require.Equal(t, "public", decoded.Community)
+	require.Len(t, decoded.Variables, 2)
+	require.Equal(t, []byte("descr"), decoded.Variables[0].Value)
+	require.Equal(t, uint32(4242), decoded.Variables[1].Value)
+
+	// the raw response is the one to this request
+	expected, err := x.Get([]string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, expected.RequestID-1, decoded.RequestID)
+	require.Equal(t, expected.size, len(raw))
+}
+
+func TestBulkWalkOpts(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 10; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.99.1.%d", i), Type: Integer, Value: i})
+	}
+	var mu sync.Mutex
+	var maxReps []uint32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		maxReps = append(maxReps, req.MaxRepetitions)
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	defer closeFn()
+
+	var values []int
+	err := x.BulkWalkOpts(".1.3.6.1.4.1.99", WalkOptions{
+		MaxRepetitions: 3,
+		Timeout:        time.Millisecond * 50,
+		Context:        context.Background(),
+		MaxRows:        3,
+		Filter: func(pdu SnmpPDU) bool {
+			return pdu.Value.(int)%2 == 0
+		},
+	}, func(pdu SnmpPDU) error {
+		values = append(values, pdu.Value.(int))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 4, 6}, values)
+	mu.Lock()
+	require.Equal(t, []uint32{3, 3}, maxReps)
+	mu.Unlock()
+	require.Equal(t, time.Millisecond*100, x.Timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = x.BulkWalkOpts(".1.3.6.1.4.1.99", WalkOptions{Context: ctx}, func(SnmpPDU) error { return nil })
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, x.Context.Err())
+}
+
+func TestBulkWalkBatches(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 7; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.99.1.%d", i), Type: Integer, Value: i})
+	}
+	mib = append(mib, SnmpPDU{Name: ".1.3.6.1.4.1.100.1", Type: Integer, Value: 0})
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+	x.MaxRepetitions = 3
+
+	var sizes, requestNums []int
+	err := x.BulkWalkBatches(".1.3.6.1.4.1.99", func(batch []SnmpPDU, requestNum int) error {
+		sizes = append(sizes, len(batch))
+		requestNums = append(requestNums, requestNum)
+		return nil
+	})
+	require.NoError(t, err)
+	// the third response ends with the first OID after the subtree
+	require.Equal(t, []int{3, 3, 1}, sizes)
+	require.Equal(t, []int{1, 2, 3}, requestNums)
+	require.Nil(t, x.walkResponse)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = x.BulkWalkBatches(".1.3.6.1.4.1.99", func([]SnmpPDU, int) error {
+		calls++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, calls)
+
+	// ErrStopWalk ends the walk without error, from a response or from the
+	// last batch
+	for _, stopAt := range []int{2, 3} {
+		calls = 0
+		err = x.BulkWalkBatches(".1.3.6.1.4.1.99", func(_ []SnmpPDU, requestNum int) error {
+			calls++
+			if requestNum == stopAt {
+				return ErrStopWalk
+			}
+			return nil
+		})
+		require.NoError(t, err, "stop at %d", stopAt)
+		require.Equal(t, stopAt, calls)
+	}
+}
+
+func TestReportErrorCount(t *testing.T) {
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return &SnmpPacket{
+			Version:       Version3,
+			MsgID:         req.MsgID,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 3,
+				AuthoritativeEngineTime:  100,
+			},
+			PDUType:   Report,
+			Variables: []SnmpPDU{{Name: usmStatsUnknownUserNames, Type: Counter32, Value: uint32(42)}},
+		}
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Retries:       2,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "nobody",
+			AuthoritativeEngineID:    "testengine",
+			AuthoritativeEngineBoots: 3,
+			AuthoritativeEngineTime:  100,
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrUnknownUsername)
+	var reportErr *ReportError
+	require.True(t, errors.As(err, &reportErr))
+	require.Equal(t, usmStatsUnknownUserNames, reportErr.OID)
+	require.Equal(t, uint64(42), reportErr.Count)
+}
+
+func TestNextCache(t *testing.T) {
+	var mu sync.Mutex
+	mib := testMib{
+		{Name: ".1.3.6.1.4.1.99.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.4.1.99.1.2", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.4.1.99.1.3", Type: Integer, Value: 3},
+		{Name: ".1.3.6.1.4.1.99.2.1", Type: Integer, Value: 4},
+	}
+	requests := make(map[PDUType]int)
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[req.PDUType]++
+		return mib.handle(req)
+	})
+	defer closeFn()
+	x.NextCacheTTL = time.Minute
+
+	// poll walks the table, returning the values and the requests it took
+	poll := func() ([]SnmpPDU, map[PDUType]int) {
+		mu.Lock()
+		requests = make(map[PDUType]int)
+		mu.Unlock()
+		results, err := x.WalkAll(".1.3.6.1.4.1.99.1")
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		return results, requests
+	}
+
+	results, sent := poll()
+	require.Len(t, results, 3)
+	require.Equal(t, map[PDUType]int{GetNextRequest: 4}, sent)
+
+	// the cached OIDs are fetched with a single GET
+	results, sent = poll()
+	require.Len(t, results, 3)
+	require.Equal(t, 3, results[2].Value)
+	require.Equal(t, map[PDUType]int{GetRequest: 1, GetNextRequest: 1}, sent)
+
+	// a new row changes the table size, so it is walked again
+	mu.Lock()
+	mib = append(mib[:3], append(testMib{{Name: ".1.3.6.1.4.1.99.1.4", Type: Integer, Value: 5}}, mib[3:]...)...)
+	mu.Unlock()
+	results, sent = poll()
+	require.Len(t, results, 4)
+	require.Equal(t, map[PDUType]int{GetRequest: 1, GetNextRequest: 6}, sent)
+
+	// as is a removed one
+	mu.Lock()
+	mib = append(mib[:1], mib[2:]...)
+	mu.Unlock()
+	results, sent = poll()
+	require.Len(t, results, 3)
+	require.Equal(t, map[PDUType]int{GetRequest: 1, GetNextRequest: 4}, sent)
+}
+
+func TestMissingVarbinds(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(42)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: []byte("host")},
+	}
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		resp := mib.handle(req)
+		// drop the middle varbind
+		resp.Variables = append(resp.Variables[:1], resp.Variables[2:]...)
+		return resp
+	})
+	defer closeFn()
+	oids := []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.1.5.0"}
+
+	result, err := x.Get(oids)
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 2)
+
+	x.MissingVarbinds = MissingVarbindsReject
+	result, err = x.Get(oids)
+	require.ErrorIs(t, err, ErrMissingVarbinds)
+	require.Len(t, result.Variables, 2)
+
+	x.MissingVarbinds = MissingVarbindsPad
+	result, err = x.Get(oids)
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 3)
+	require.Equal(t, []byte("descr"), result.Variables[0].Value)
+	require.Equal(t, SnmpPDU{Name: ".1.3.6.1.2.1.1.3.0", Type: NoSuchInstance}, result.Variables[1])
+	require.Equal(t, []byte("host"), result.Variables[2].Value)
+}
+
+func TestWalkWithContext(t *testing.T) {
+	var mib testMib
+	for i := 1; i <= 20; i++ {
+		mib = append(mib, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.99.1.%d", i), Type: Integer, Value: i})
+	}
+	var requests int32
+	var hang int32
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&hang) != 0 {
+			return nil
+		}
+		return mib.handle(req)
+	})
+	defer closeFn()
+	x.MaxRepetitions = 2
+
+	walks := map[string]func(context.Context, string, WalkFunc) error{
+		"WalkWithContext":     x.WalkWithContext,
+		"BulkWalkWithContext": x.BulkWalkWithContext,
+	}
+	for name, walk := range walks {
+		atomic.StoreInt32(&requests, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		values := 0
+		err := walk(ctx, ".1.3.6.1.4.1.99", func(SnmpPDU) error {
+			if values++; values == 4 {
+				cancel()
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled, name)
+		require.Equal(t, 4, values, name)
+		require.LessOrEqual(t, atomic.LoadInt32(&requests), int32(4), name)
+		require.NoError(t, x.Context.Err(), name)
+
+		// a nil ctx leaves x.Context in effect
+		values = 0
+		err = walk(nil, ".1.3.6.1.4.1.99", func(SnmpPDU) error { //nolint:staticcheck
+			values++
+			return nil
+		})
+		require.NoError(t, err, name)
+		require.Equal(t, 20, values, name)
+	}
+
+	// the deadline bounds each request of a hanging agent
+	atomic.StoreInt32(&hang, 1)
+	x.Timeout = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := x.BulkWalkWithContext(ctx, ".1.3.6.1.4.1.99", func(SnmpPDU) error { return nil })
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+// testTLSCertificate returns a self-signed certificate for 127.0.0.1.
+func testTLSCertificate(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSTransport(t *testing.T) {
+	agentCert := testTLSCertificate(t, "agent")
+	managerCert := testTLSCertificate(t, "manager")
+
+	srvr, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{agentCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	require.NoError(t, err)
+	defer srvr.Close()
+
+	clientFingerprints := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := srvr.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				clientFingerprints <- TLSFingerprint(tlsConn.ConnectionState().PeerCertificates[0])
+
+				agent := &GoSNMP{Transport: "tls", Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+				buf := make([]byte, rxBufSize)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					var reqPkt SnmpPacket
+					msg := buf[:n]
+					cursor, err := agent.unmarshalHeader(msg, &reqPkt)
+					if err != nil {
+						t.Errorf("error: %s", err)
+						return
+					}
+					if reqPkt.SecurityModel != TransportSecurityModel {
+						t.Errorf("expected msgSecurityModel %d, got %d", TransportSecurityModel, reqPkt.SecurityModel)
+					}
+					if msg, cursor, err = agent.decryptPacket(msg, cursor, &reqPkt); err != nil {
+						t.Errorf("error: %s", err)
+						return
+					}
+					if err = agent.unmarshalPayload(msg, cursor, &reqPkt); err != nil {
+						t.Errorf("error: %s", err)
+						return
+					}
+					rspPkt := &SnmpPacket{
+						Version:            Version3,
+						MsgID:              reqPkt.MsgID,
+						MsgFlags:           AuthPriv,
+						SecurityModel:      TransportSecurityModel,
+						SecurityParameters: &TsmSecurityParameters{},
+						PDUType:            GetResponse,
+						RequestID:          reqPkt.RequestID,
+						Variables:          []SnmpPDU{{Name: ".1.2", Type: Integer, Value: 42}},
+					}
+					outBuf, err := rspPkt.marshalMsg()
+					if err != nil {
+						t.Errorf("ERR: %s", err)
+						return
+					}
+					conn.Write(outBuf)
+				}
+			}()
+		}
+	}()
+
+	agentFingerprint := TLSFingerprint(agentCert.Leaf)
+	newManager := func(pinned string) *GoSNMP {
+		return &GoSNMP{
+			Version:       Version3,
+			Transport:     "tls",
+			Target:        "127.0.0.1",
+			Port:          uint16(srvr.Addr().(*net.TCPAddr).Port),
+			Timeout:       time.Second,
+			SecurityModel: TransportSecurityModel,
+			MsgFlags:      AuthPriv,
+			SecurityParameters: &TsmSecurityParameters{
+				CertToName: map[string]string{strings.ToUpper(agentFingerprint): "agent1"},
+			},
+			TLSConfig: &tls.Config{
+				Certificates:          []tls.Certificate{managerCert},
+				InsecureSkipVerify:    true,
+				VerifyPeerCertificate: VerifyTLSFingerprints(pinned),
+			},
+			Logger: NewLogger(log.New(ioutil.Discard, "", 0)),
+		}
+	}
+
+	x := newManager(agentFingerprint)
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+	require.Equal(t, TLSFingerprint(managerCert.Leaf), <-clientFingerprints)
+	require.Equal(t, "agent1", x.SecurityParameters.(*TsmSecurityParameters).SecurityName)
+
+	result, err := x.Get([]string{".1.2"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	require.Equal(t, 42, result.Variables[0].Value)
+
+	// an agent presenting another certificate than the pinned one is refused
+	x = newManager(TLSFingerprint(managerCert.Leaf))
+	require.Error(t, x.Connect())
+
+	// the TSM needs the TLS transport
+	x = newManager(agentFingerprint)
+	x.Transport = "tcp"
+	require.Error(t, x.Connect())
+}
+
+func TestWalkRaw(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.4.1", Type: Integer, Value: 300},
+		{Name: ".1.3.6.1.2.1.2.2.1.4.2", Type: Integer, Value: 1500},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: Counter32, Value: uint32(4000000000)},
+	}
+	x, closeFn := newTestAgent(t, mib.handle)
+	defer closeFn()
+	x.MaxRepetitions = 2
+	x.IntegerAsIPAddress = []string{".1.3.6.1.2.1.2.2.1.4"}
+
+	var names []string
+	var types []Asn1BER
+	var raws [][]byte
+	err := x.WalkRaw(".1.3.6.1.2.1.2.2.1", func(name string, valueType Asn1BER, raw []byte) error {
+		names = append(names, name)
+		types = append(types, valueType)
+		raws = append(raws, append([]byte(nil), raw...))
+		return nil
+	})
+	require.NoError(t, err)
+	require.False(t, x.rawValues)
+	require.Len(t, names, len(mib))
+	for i, pdu := range mib {
+		require.Equal(t, pdu.Name, names[i])
+		require.Equal(t, pdu.Type, types[i])
+	}
+	require.Equal(t, []byte("eth1"), raws[1])
+	require.Equal(t, []byte{0x01, 0x2c}, raws[2])
+
+	mtu, err := x.DecodeValue(types[3], raws[3])
+	require.NoError(t, err)
+	require.Equal(t, 1500, mtu)
+	octets, err := x.DecodeValue(types[4], raws[4])
+	require.NoError(t, err)
+	require.Equal(t, uint(4000000000), octets)
+}
+
+func TestAuthPrivNotSentInCleartext(t *testing.T) {
+	var received int32
+	srvr := startTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		atomic.AddInt32(&received, 1)
+		return nil
+	})
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Millisecond * 100,
+		Logger:        NewLogger(log.New(ioutil.Discard, "", 0)),
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      AuthPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+			AuthoritativeEngineID:    "testengine",
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	// a configuration bug leaving the privacy key uninitialized
+	usp := x.SecurityParameters.(*UsmSecurityParameters)
+	usp.PrivacyKey = nil
+	usp.PrivacyPassphrase = ""
+	_, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrNotEncrypted)
+
+	usp.PrivacyProtocol = DES
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrNotEncrypted)
+
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, atomic.LoadInt32(&received), "nothing may reach the agent")
+}
@@ -0,0 +1,255 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// OID is an object identifier as its sub-identifiers, for building and
+// comparing OIDs without string manipulation. Use ParseOID and String to
+// convert from and to the dotted form used by SnmpPDU.Name.
+type OID []uint
+
+// ParseOID parses a dotted OID such as ".1.3.6.1.2.1.1.1.0"; the leading dot
+// is optional. Sub-identifiers with leading zeros are accepted, ".1.3.06.1"
+// being ".1.3.6.1", as Get and the walks do.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return OID{}, nil
+	}
+	parts := strings.Split(s, ".")
+	oid := make(OID, len(parts))
+	for i, part := range parts {
+		sub, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sub-identifier %q in OID %q: %w", part, s, err)
+		}
+		oid[i] = uint(sub)
+	}
+	return oid, nil
+}
+
+// normalizeOID strips the leading zeros of the sub-identifiers of the dotted
+// OID oid, eg ".1.3.06.1" to ".1.3.6.1". Invalid OIDs are returned as is, for
+// marshalling to reject.
+func normalizeOID(oid string) string {
+	padded := false
+	for i := 0; i+1 < len(oid); i++ {
+		if oid[i] == '0' && (i == 0 || oid[i-1] == '.') && oid[i+1] != '.' {
+			padded = true
+			break
+		}
+	}
+	if !padded {
+		return oid
+	}
+
+	parts := strings.Split(oid, ".")
+	for i, part := range parts {
+		if len(part) > 1 && part[0] == '0' {
+			if parts[i] = strings.TrimLeft(part, "0"); parts[i] == "" {
+				parts[i] = "0"
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// String returns the OID in the dotted form used by SnmpPDU.Name, with a
+// leading dot.
+func (o OID) String() string {
+	var b strings.Builder
+	for _, sub := range o {
+		b.WriteByte('.')
+		b.WriteString(strconv.FormatUint(uint64(sub), 10))
+	}
+	return b.String()
+}
+
+// Append returns a new OID of o followed by sub, eg a table column followed
+// by a row index. o itself is not modified.
+func (o OID) Append(sub ...uint) OID {
+	oid := make(OID, 0, len(o)+len(sub))
+	oid = append(oid, o...)
+	return append(oid, sub...)
+}
+
+// HasPrefix reports whether o is prefix or is in the subtree under it.
+func (o OID) HasPrefix(prefix OID) bool {
+	if len(prefix) > len(o) {
+		return false
+	}
+	for i, sub := range prefix {
+		if o[i] != sub {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0 or +1 as o sorts before, equal to or after other in
+// lexicographic OID order, the order of GETNEXT and walks. An OID sorts
+// before the OIDs in the subtree under it.
+func (o OID) Compare(other OID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(other):
+		return -1
+	case len(o) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// NewOIDVarbind returns a varbind for name whose value is the OID oidValue,
+// eg to Set a RowPointer column. oidValue is checked to be a valid OID, and
+// is given a leading dot if it has none.
+func NewOIDVarbind(name, oidValue string) (SnmpPDU, error) {
+	oid, err := ParseOID(oidValue)
+	if err != nil {
+		return SnmpPDU{}, err
+	}
+	if len(oid) < 2 {
+		return SnmpPDU{}, fmt.Errorf("OID %q needs at least two sub-identifiers", oidValue)
+	}
+	value := oid.String()
+	if _, err = marshalObjectIdentifier(value); err != nil {
+		return SnmpPDU{}, fmt.Errorf("OID %q: %w", oidValue, err)
+	}
+	return SnmpPDU{Name: name, Type: ObjectIdentifier, Value: value}, nil
+}
+
+// IndexPartType describes one part of a table index for ParseIndex.
+type IndexPartType struct {
+	// Type is the SMI type of the part: Integer, an unsigned type such as
+	// Gauge32 or Uinteger32, OctetString, ObjectIdentifier or IPAddress.
+	Type Asn1BER
+
+	// Length if positive, is the size of a fixed length OctetString, which
+	// is then encoded without a length prefix.
+	Length int
+
+	// Implied marks the last part of an index declared IMPLIED, an
+	// OctetString or ObjectIdentifier taking the rest of the OID without a
+	// length prefix.
+	Implied bool
+}
+
+// ParseIndex decodes the index OID suffix of a table row, eg the part of a
+// varbind name after its column, into the parts described by spec, following
+// the index encoding of RFC 2578 section 7.7. Integer parts are returned as
+// int, unsigned parts as uint32, OctetString parts as []byte, and
+// ObjectIdentifier and IPAddress parts as their dotted strings.
+func ParseIndex(suffix string, spec []IndexPartType) ([]interface{}, error) {
+	arcs, err := ParseOID(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]interface{}, 0, len(spec))
+	for i, part := range spec {
+		if part.Implied && i != len(spec)-1 {
+			return nil, fmt.Errorf("index part %d: only the last part can be implied", i)
+		}
+		// take returns the next n arcs
+		take := func(n int) (OID, error) {
+			if n > len(arcs) {
+				return nil, fmt.Errorf("index part %d: needs %d sub-identifiers, %d left in %q", i, n, len(arcs), suffix)
+			}
+			taken := arcs[:n]
+			arcs = arcs[n:]
+			return taken, nil
+		}
+		// length returns the number of arcs of a variable length part
+		length := func() (int, error) {
+			if part.Implied {
+				return len(arcs), nil
+			}
+			prefix, err := take(1)
+			if err != nil {
+				return 0, err
+			}
+			return int(prefix[0]), nil
+		}
+
+		switch part.Type {
+		case Integer:
+			arc, err := take(1)
+			if err != nil {
+				return nil, err
+			}
+			if arc[0] > math.MaxInt32 {
+				return nil, fmt.Errorf("index part %d: %d overflows an Integer", i, arc[0])
+			}
+			parts = append(parts, int(arc[0]))
+		case Counter32, Gauge32, TimeTicks, Uinteger32:
+			arc, err := take(1)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, uint32(arc[0]))
+		case OctetString:
+			n := part.Length
+			if n <= 0 {
+				if n, err = length(); err != nil {
+					return nil, err
+				}
+			}
+			str, err := take(n)
+			if err != nil {
+				return nil, err
+			}
+			value := make([]byte, len(str))
+			for j, arc := range str {
+				if arc > 255 {
+					return nil, fmt.Errorf("index part %d: %d is not an octet", i, arc)
+				}
+				value[j] = byte(arc)
+			}
+			parts = append(parts, value)
+		case ObjectIdentifier:
+			n, err := length()
+			if err != nil {
+				return nil, err
+			}
+			oid, err := take(n)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, oid.String())
+		case IPAddress:
+			ip, err := take(4)
+			if err != nil {
+				return nil, err
+			}
+			octets := make([]string, len(ip))
+			for j, arc := range ip {
+				if arc > 255 {
+					return nil, fmt.Errorf("index part %d: %d is not an octet", i, arc)
+				}
+				octets[j] = strconv.FormatUint(uint64(arc), 10)
+			}
+			parts = append(parts, strings.Join(octets, "."))
+		default:
+			return nil, fmt.Errorf("index part %d: unsupported type %v", i, part.Type)
+		}
+	}
+	if len(arcs) > 0 {
+		return nil, fmt.Errorf("%d sub-identifiers of %q left after the index", len(arcs), suffix)
+	}
+	return parts, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysOREntry  = ".1.3.6.1.2.1.1.9.1"
+	sysORID     = sysOREntry + ".2"
+	sysORDescr  = sysOREntry + ".3"
+	sysORUpTime = sysOREntry + ".4"
+)
+
+// ORTableEntry is a row of the agent's sysORTable (SNMPv2-MIB), describing
+// a MIB module or capability the agent supports.
+type ORTableEntry struct {
+	// Index is the sysORIndex of the row.
+	Index int
+	// ID is the sysORID, the OID of the capability.
+	ID string
+	// Descr is the sysORDescr, a textual description of the capability.
+	Descr string
+	// UpTime is the sysORUpTime, the sysUpTime when the row was last
+	// instantiated.
+	UpTime uint32
+}
+
+// Capabilities returns the rows of the agent's sysORTable, listing the MIB
+// modules it supports, in table order. The table is walked with GETBULK,
+// or GETNEXT for SNMPv1.
+func (x *GoSNMP) Capabilities() ([]ORTableEntry, error) {
+	var entries []ORTableEntry
+	rows := make(map[int]int) // sysORIndex to entries index
+
+	walkFn := func(pdu SnmpPDU) error {
+		var column string
+		switch {
+		case strings.HasPrefix(pdu.Name, sysORID+"."):
+			column = sysORID
+		case strings.HasPrefix(pdu.Name, sysORDescr+"."):
+			column = sysORDescr
+		case strings.HasPrefix(pdu.Name, sysORUpTime+"."):
+			column = sysORUpTime
+		default:
+			return nil
+		}
+
+		index, err := strconv.Atoi(pdu.Name[len(column)+1:])
+		if err != nil {
+			return fmt.Errorf("invalid sysORTable index in %s: %w", pdu.Name, err)
+		}
+		row, ok := rows[index]
+		if !ok {
+			row = len(entries)
+			rows[index] = row
+			entries = append(entries, ORTableEntry{Index: index})
+		}
+
+		switch column {
+		case sysORID:
+			if id, ok := pdu.Value.(string); ok {
+				entries[row].ID = id
+			}
+		case sysORDescr:
+			if descr, ok := pdu.Value.([]byte); ok {
+				entries[row].Descr = string(descr)
+			}
+		case sysORUpTime:
+			entries[row].UpTime = uint32(ToBigInt(pdu.Value).Uint64())
+		}
+		return nil
+	}
+
+	var err error
+	if x.Version == Version1 {
+		err = x.Walk(sysOREntry, walkFn)
+	} else {
+		err = x.BulkWalk(sysOREntry, walkFn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
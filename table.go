@@ -0,0 +1,137 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GetTable walks the columns of the table tableOid, with or without a leading
+// dot, and returns its rows in index order. Each column is the OID of a column
+// of the table, either in full with a leading dot or relative to tableOid, eg "1.2" for the second
+// column of a table whose entry is 1. A row maps the columns it has a value
+// for, as given in columns, to their PDU: the columns missing from sparse
+// rows are absent from their map. The index of a row is the suffix of the
+// names of its PDUs after their column, kept as the agent returned it.
+//
+// The columns are walked with GETBULK, or GETNEXT for SNMPv1, TableConcurrency
+// at once if set.
+func (x *GoSNMP) GetTable(tableOid string, columns []string) ([]map[string]SnmpPDU, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns of %s to walk", tableOid)
+	}
+	// the names of the PDUs walked have a leading dot
+	tableRoot := "." + strings.Trim(tableOid, ".")
+	roots := make([]string, len(columns))
+	for i, column := range columns {
+		roots[i] = "." + strings.Trim(column, ".")
+		if !strings.HasPrefix(column, ".") {
+			roots[i] = tableRoot + roots[i]
+		}
+	}
+
+	values := make([][]SnmpPDU, len(columns))
+	errs := make([]error, len(columns))
+	if x.TableConcurrency <= 1 || len(columns) == 1 {
+		for i, root := range roots {
+			if values[i], errs[i] = x.walkColumn(root); errs[i] != nil {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, x.TableConcurrency)
+		for i := range roots {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				values[i], errs[i] = x.walkColumnConnected(roots[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error walking column %s: %w", columns[i], err)
+		}
+	}
+
+	var rows []map[string]SnmpPDU
+	var indexes []OID
+	byIndex := make(map[string]int) // index to rows index
+	for i, column := range columns {
+		for _, pdu := range values[i] {
+			index := pdu.Name[len(roots[i]):]
+			row, ok := byIndex[index]
+			if !ok {
+				oid, err := ParseOID(index)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index in %s: %w", pdu.Name, err)
+				}
+				row = len(rows)
+				byIndex[index] = row
+				rows = append(rows, make(map[string]SnmpPDU))
+				indexes = append(indexes, oid)
+			}
+			rows[row][column] = pdu
+		}
+	}
+	sort.Sort(tableRows{rows, indexes})
+	return rows, nil
+}
+
+// walkColumn returns the values of the column root of a table.
+func (x *GoSNMP) walkColumn(root string) ([]SnmpPDU, error) {
+	var values []SnmpPDU
+	walkFn := func(pdu SnmpPDU) error {
+		if strings.HasPrefix(pdu.Name, root+".") {
+			values = append(values, pdu)
+		}
+		return nil
+	}
+	var err error
+	if x.Version == Version1 {
+		err = x.Walk(root, walkFn)
+	} else {
+		err = x.BulkWalk(root, walkFn)
+	}
+	return values, err
+}
+
+// walkColumnConnected is walkColumn over a new connection to Target, so that
+// columns can be walked concurrently.
+func (x *GoSNMP) walkColumnConnected(root string) ([]SnmpPDU, error) {
+	conn := *x
+	conn.Conn = nil
+	conn.uaddr = nil
+	conn.random = 0
+	conn.EngineKeepalive = 0
+	if x.SecurityParameters != nil {
+		conn.SecurityParameters = x.SecurityParameters.Copy()
+	}
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+	defer conn.Conn.Close()
+	return conn.walkColumn(root)
+}
+
+// tableRows sorts the rows of GetTable by their index.
+type tableRows struct {
+	rows    []map[string]SnmpPDU
+	indexes []OID
+}
+
+func (t tableRows) Len() int           { return len(t.rows) }
+func (t tableRows) Less(i, j int) bool { return t.indexes[i].Compare(t.indexes[j]) < 0 }
+func (t tableRows) Swap(i, j int) {
+	t.rows[i], t.rows[j] = t.rows[j], t.rows[i]
+	t.indexes[i], t.indexes[j] = t.indexes[j], t.indexes[i]
+}
@@ -0,0 +1,22 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package gosnmp
+
+import "syscall"
+
+// setTrafficClass sets IPV6_TCLASS on c, see GoSNMP.IPv6TrafficClass.
+func setTrafficClass(c syscall.RawConn, class int) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, class)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,75 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+// +build all marshal
+
+package gosnmp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPv6TrafficClass(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.SyscallConn()
+	require.NoError(t, err)
+	var sockErr error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+	}))
+	require.NoError(t, sockErr)
+
+	x := &GoSNMP{
+		Target:           "::1",
+		Port:             uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Version:          Version2c,
+		Community:        "public",
+		Timeout:          50 * time.Millisecond,
+		IPv6TrafficClass: 0xb8, // DSCP EF
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+
+	// the listener never answers, only the request is of interest
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	require.Error(t, err)
+
+	buf := make([]byte, 1500)
+	oob := make([]byte, 128)
+	_, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+	require.NoError(t, err)
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+	class := -1
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_TCLASS && len(msg.Data) >= 4 {
+			// a native endian int, of which only one byte can be set
+			class = int(msg.Data[0] | msg.Data[1] | msg.Data[2] | msg.Data[3])
+		}
+	}
+	require.Equal(t, 0xb8, class)
+}
+
+func TestIPv6TrafficClassRange(t *testing.T) {
+	for _, class := range []int{-1, 256} {
+		x := &GoSNMP{
+			Target:           "::1",
+			Port:             161,
+			Version:          Version2c,
+			Community:        "public",
+			IPv6TrafficClass: class,
+		}
+		require.Error(t, x.Connect(), "traffic class %d", class)
+	}
+}
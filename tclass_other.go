@@ -0,0 +1,20 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package gosnmp
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// setTrafficClass is not supported on this platform, see
+// GoSNMP.IPv6TrafficClass.
+func setTrafficClass(c syscall.RawConn, class int) error {
+	return fmt.Errorf("IPV6_TCLASS is not supported on %s", runtime.GOOS)
+}
@@ -5,11 +5,13 @@
 package gosnmp
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -28,6 +30,9 @@ import (
 //
 // See also Listen() and examples for creating an NMS.
 //
+// For SNMPv3, trap.EngineBoots and trap.EngineTime can be used to send the
+// agent's own engine boots/time rather than those in SecurityParameters.
+//
 // NOTE: the trap code is currently unreliable when working with snmpv3 - pull requests welcome
 func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 	var pdutype PDUType
@@ -82,12 +87,47 @@ func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 		packetOut.SpecificTrap = trap.SpecificTrap
 		packetOut.Timestamp = trap.Timestamp
 	}
+	if x.Version == Version3 && (trap.EngineBoots != 0 || trap.EngineTime != 0) {
+		usp, err := castUsmSecParams(packetOut.SecurityParameters)
+		if err != nil {
+			return nil, err
+		}
+		usp.AuthoritativeEngineBoots = trap.EngineBoots
+		usp.AuthoritativeEngineTime = trap.EngineTime
+	}
 
 	// all sends wait for the return packet, except for SNMPv2Trap
 	// -> wait is only for informs
 	return x.send(packetOut, trap.IsInform)
 }
 
+// SendTrapContext is SendTrap using ctx in place of x.Context. Cancelling
+// ctx aborts an inform awaiting its response or retransmission, and
+// SendTrapContext then returns ctx.Err(). x must not be used concurrently.
+func (x *GoSNMP) SendTrapContext(ctx context.Context, trap SnmpTrap) (*SnmpPacket, error) {
+	defer func(saved context.Context) { x.Context = saved }(x.Context)
+	x.Context = ctx
+
+	done := make(chan struct{})
+	defer close(done)
+	if conn := x.Conn; conn != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// unblock the read awaiting the inform response
+				_ = conn.SetReadDeadline(time.Now())
+			case <-done:
+			}
+		}()
+	}
+
+	result, err := x.SendTrap(trap)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return result, err
+}
+
 //
 // Receiving Traps ie GoSNMP acting as an NMS (Network Management
 // Station).
@@ -106,6 +146,33 @@ type TrapListener struct {
 	// OnNewTrap handles incoming Trap and Inform PDUs.
 	OnNewTrap TrapHandlerFunc
 
+	// OnNewTrapContext, if set, handles incoming Trap and Inform PDUs
+	// instead of OnNewTrap, with a context carrying the trap's source
+	// address and receive time.
+	OnNewTrapContext TrapHandlerContextFunc
+
+	// Context is the base context from which the context passed to
+	// OnNewTrapContext is derived. (default: context.Background())
+	Context context.Context
+
+	// HandlerTimeout if set, is how long the listener waits for the
+	// handler of a trap to return before abandoning it with a logged
+	// warning and receiving the next trap. The context passed to
+	// OnNewTrapContext is cancelled at the timeout, but an abandoned
+	// handler keeps running until it returns, so a handler that doesn't
+	// observe its context is never stopped.
+	HandlerTimeout time.Duration
+
+	// Control is an optional function called with the listening socket
+	// before it is bound, see net.ListenConfig.Control. It can be used to
+	// set socket options.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// ReusePort if set, binds the listening socket with SO_REUSEADDR and
+	// SO_REUSEPORT, so that several listeners, eg the instances of a HA
+	// pair, can share the port. It is only supported on Unix-like systems.
+	ReusePort bool
+
 	// These unexported fields are for letting test cases
 	// know we are ready.
 	conn  *net.UDPConn
@@ -130,6 +197,34 @@ type TrapListener struct {
 // of event this is for e.g. statistics gathering functions, etc.
 type TrapHandlerFunc func(s *SnmpPacket, u *net.UDPAddr)
 
+// TrapHandlerContextFunc is like TrapHandlerFunc, with a context per trap
+// from which TrapSource and TrapReceiveTime return the sender and the time
+// the trap was received.
+type TrapHandlerContextFunc func(ctx context.Context, s *SnmpPacket, u *net.UDPAddr)
+
+// trapContextKey is the type of the keys of values in the context passed to
+// TrapHandlerContextFunc.
+type trapContextKey int
+
+const (
+	trapSourceKey trapContextKey = iota
+	trapReceiveTimeKey
+)
+
+// TrapSource returns the address a trap was received from, from the context
+// passed to a TrapHandlerContextFunc.
+func TrapSource(ctx context.Context) (*net.UDPAddr, bool) {
+	u, ok := ctx.Value(trapSourceKey).(*net.UDPAddr)
+	return u, ok
+}
+
+// TrapReceiveTime returns the time a trap was received, from the context
+// passed to a TrapHandlerContextFunc.
+func TrapReceiveTime(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(trapReceiveTimeKey).(time.Time)
+	return t, ok
+}
+
 // NewTrapListener returns an initialized TrapListener.
 //
 // NOTE: the trap code is currently unreliable when working with snmpv3 - pull requests welcome
@@ -171,6 +266,24 @@ func (t *TrapListener) Close() {
 	}
 }
 
+// listenConfig returns the net.ListenConfig applying Control and ReusePort.
+func (t *TrapListener) listenConfig() net.ListenConfig {
+	if !t.ReusePort {
+		return net.ListenConfig{Control: t.Control}
+	}
+	return net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if err := setReusePort(c); err != nil {
+				return err
+			}
+			if t.Control != nil {
+				return t.Control(network, address, c)
+			}
+			return nil
+		},
+	}
+}
+
 func (t *TrapListener) listenUDP(addr string) error {
 	// udp
 
@@ -178,10 +291,12 @@ func (t *TrapListener) listenUDP(addr string) error {
 	if err != nil {
 		return err
 	}
-	t.conn, err = net.ListenUDP(udp, udpAddr)
+	lc := t.listenConfig()
+	pc, err := lc.ListenPacket(context.Background(), udp, udpAddr.String())
 	if err != nil {
 		return err
 	}
+	t.conn = pc.(*net.UDPConn)
 
 	defer t.conn.Close()
 
@@ -205,59 +320,117 @@ func (t *TrapListener) listenUDP(addr string) error {
 				t.Params.Logger.Printf("TrapListener: error in read %s\n", err)
 				continue
 			}
+			received := time.Now()
 
-			msg := buf[:rlen]
-			traps := t.Params.UnmarshalTrap(msg, false)
-
-			if traps != nil {
-				// Here we assume that t.OnNewTrap will not alter the contents
-				// of the PDU (per documentation, because Go does not have
-				// compile-time const checking).  We don't pass a copy because
-				// the SnmpPacket type is somewhat large, but we could without
-				// violating any implicit or explicit spec.
-				t.OnNewTrap(traps, remote)
-
-				// If it was an Inform request, we need to send a response.
-				if traps.PDUType == InformRequest { //nolint:whitespace
-
-					// Reuse the packet, since we're supposed to send it back
-					// with the exact same variables unless there's an error.
-					// Change the PDUType to the response, though.
-					traps.PDUType = GetResponse
-
-					// If the response can be sent, the error-status is
-					// supposed to be set to noError and the error-index set to
-					// zero.
-					traps.Error = NoError
-					traps.ErrorIndex = 0
-
-					// TODO: Check that the message marshalled is not too large
-					// for the originator to accept and if so, send a tooBig
-					// error PDU per RFC3416 section 4.2.7.  This maximum size,
-					// however, does not have a well-defined mechanism in the
-					// RFC other than using the path MTU (which is difficult to
-					// determine), so it's left to future implementations.
-					ob, err := traps.marshalMsg()
-					if err != nil {
-						return fmt.Errorf("error marshaling INFORM response: %w", err)
-					}
-
-					// Send the return packet back.
-					count, err := t.conn.WriteTo(ob, remote)
-					if err != nil {
-						return fmt.Errorf("error sending INFORM response: %w", err)
-					}
-
-					// This isn't fatal, but should be logged.
-					if count != len(ob) {
-						t.Params.Logger.Printf("Failed to send all bytes of INFORM response!\n")
-					}
+			msgs := [][]byte{buf[:rlen]}
+			if t.Params.ConcatenatedMessages {
+				if msgs, err = splitMessages(buf[:rlen]); err != nil {
+					t.Params.Logger.Printf("TrapListener: error splitting messages %s\n", err)
+					continue
+				}
+			}
+			for _, msg := range msgs {
+				if err = t.handleUDPMessage(msg, remote, received); err != nil {
+					return err
 				}
 			}
 		}
 	}
 }
 
+// handleUDPMessage passes a trap received on UDP to the handler, and responds
+// to it if it was an Inform request.
+func (t *TrapListener) handleUDPMessage(msg []byte, remote *net.UDPAddr, received time.Time) error {
+	traps := t.Params.UnmarshalTrap(msg, false)
+
+	if traps != nil {
+		// Here we assume that t.OnNewTrap will not alter the contents
+		// of the PDU (per documentation, because Go does not have
+		// compile-time const checking).  We don't pass a copy because
+		// the SnmpPacket type is somewhat large, but we could without
+		// violating any implicit or explicit spec.
+		t.handleTrap(traps, remote, received)
+
+		// If it was an Inform request, we need to send a response.
+		if traps.PDUType == InformRequest { //nolint:whitespace
+
+			// Reuse the packet, since we're supposed to send it back
+			// with the exact same variables unless there's an error.
+			// Change the PDUType to the response, though, on a copy as
+			// an abandoned handler may still be reading it.
+			response := *traps
+			traps = &response
+			traps.PDUType = GetResponse
+
+			// If the response can be sent, the error-status is
+			// supposed to be set to noError and the error-index set to
+			// zero.
+			traps.Error = NoError
+			traps.ErrorIndex = 0
+
+			// TODO: Check that the message marshalled is not too large
+			// for the originator to accept and if so, send a tooBig
+			// error PDU per RFC3416 section 4.2.7.  This maximum size,
+			// however, does not have a well-defined mechanism in the
+			// RFC other than using the path MTU (which is difficult to
+			// determine), so it's left to future implementations.
+			ob, err := traps.marshalMsg()
+			if err != nil {
+				return fmt.Errorf("error marshaling INFORM response: %w", err)
+			}
+
+			// Send the return packet back.
+			count, err := t.conn.WriteTo(ob, remote)
+			if err != nil {
+				return fmt.Errorf("error sending INFORM response: %w", err)
+			}
+
+			// This isn't fatal, but should be logged.
+			if count != len(ob) {
+				t.Params.Logger.Printf("Failed to send all bytes of INFORM response!\n")
+			}
+		}
+	}
+	return nil
+}
+
+// handleTrap calls OnNewTrapContext, or OnNewTrap if it is not set, for at
+// most HandlerTimeout.
+func (t *TrapListener) handleTrap(traps *SnmpPacket, remote *net.UDPAddr, received time.Time) {
+	if t.OnNewTrapContext == nil && t.HandlerTimeout <= 0 {
+		t.OnNewTrap(traps, remote)
+		return
+	}
+
+	ctx := t.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = context.WithValue(ctx, trapSourceKey, remote)
+	ctx = context.WithValue(ctx, trapReceiveTimeKey, received)
+	if t.HandlerTimeout <= 0 {
+		t.OnNewTrapContext(ctx, traps, remote)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.HandlerTimeout)
+	defer cancel()
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		if t.OnNewTrapContext != nil {
+			t.OnNewTrapContext(ctx, traps, remote)
+		} else {
+			t.OnNewTrap(traps, remote)
+		}
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		t.Params.Logger.Printf("WARNING TrapListener: abandoned handler of trap from %s after %s", remote, t.HandlerTimeout)
+	}
+}
+
 func (t *TrapListener) handleTCPRequest(conn net.Conn) {
 	// Make a buffer to hold incoming data.
 	buf := make([]byte, 4096)
@@ -268,13 +441,14 @@ func (t *TrapListener) handleTCPRequest(conn net.Conn) {
 		return
 	}
 
+	received := time.Now()
 	msg := buf[:reqLen]
 	traps := t.Params.UnmarshalTrap(msg, false)
 
 	if traps != nil {
 		// TODO: lying for backward compatibility reason - create UDP Address ... not nice
 		r, _ := net.ResolveUDPAddr("", conn.RemoteAddr().String())
-		t.OnNewTrap(traps, r)
+		t.handleTrap(traps, r, received)
 	}
 	// Close the connection when you're done with it.
 	conn.Close()
@@ -286,7 +460,8 @@ func (t *TrapListener) listenTCP(addr string) error {
 		return err
 	}
 
-	l, err := net.ListenTCP("tcp", tcpAddr)
+	lc := t.listenConfig()
+	l, err := lc.Listen(context.Background(), "tcp", tcpAddr.String())
 	if err != nil {
 		return err
 	}
@@ -331,7 +506,7 @@ func (t *TrapListener) Listen(addr string) error {
 	// TestSendV1Trap
 	_ = t.Params.validateParameters()
 
-	if t.OnNewTrap == nil {
+	if t.OnNewTrap == nil && t.OnNewTrapContext == nil {
 		t.OnNewTrap = t.debugTrapHandler
 	}
 
@@ -353,7 +528,49 @@ func (t *TrapListener) Listen(addr string) error {
 
 // Default trap handler
 func (t *TrapListener) debugTrapHandler(s *SnmpPacket, u *net.UDPAddr) {
-	t.Params.Logger.Printf("got trapdata from %+v: %+v\n", u, s)
+	logged := *s
+	logged.Community = t.Params.loggedCommunity(logged.Community)
+	t.Params.Logger.Printf("got trapdata from %+v: %+v\n", u, logged)
+}
+
+// snmpTrapEnterprise is the SNMPv2-MIB snmpTrapEnterprise.0 varbind, which
+// proxies translating SNMPv1 traps add to the v2c trap (RFC 3584).
+const snmpTrapEnterprise = ".1.3.6.1.6.3.1.1.4.3.0"
+
+// TrapEnterprise returns the enterprise OID of a received trap: the
+// snmpTrapEnterprise.0 varbind of a v2c or v3 trap, or Enterprise for a v1
+// trap. ok is false if the trap doesn't carry one.
+func (packet *SnmpPacket) TrapEnterprise() (enterprise string, ok bool) {
+	if packet.PDUType == Trap {
+		return packet.Enterprise, packet.Enterprise != ""
+	}
+	for _, pdu := range packet.Variables {
+		if pdu.Name == snmpTrapEnterprise && pdu.Type == ObjectIdentifier {
+			enterprise, ok = pdu.Value.(string)
+			return enterprise, ok
+		}
+	}
+	return "", false
+}
+
+// SecurityLevel returns the security level a received trap was sent with, so
+// that handlers can apply a different policy to each: "v1" or "v2c" for
+// community based traps, or for SNMPv3 the level of its msgFlags,
+// "noAuthNoPriv", "authNoPriv" or "authPriv". A listener configured for
+// SNMPv3 also accepts v1 and v2c traps; SNMPv3 traps that fail
+// authentication or decryption don't reach the handler.
+func (packet *SnmpPacket) SecurityLevel() string {
+	switch packet.Version {
+	case Version1, Version2c:
+		return "v" + packet.Version.String()
+	}
+	switch packet.MsgFlags & AuthPriv {
+	case AuthPriv:
+		return "authPriv"
+	case AuthNoPriv:
+		return "authNoPriv"
+	}
+	return "noAuthNoPriv"
 }
 
 // UnmarshalTrap unpacks the SNMP Trap.
@@ -378,7 +595,7 @@ func (x *GoSNMP) UnmarshalTrap(trap []byte, useResponseSecurityParameters bool)
 
 	if result.Version == Version3 {
 		if result.SecurityModel == UserSecurityModel {
-			err = x.testAuthentication(trap, result, useResponseSecurityParameters)
+			err = x.testAuthentication(trap, result, x.MsgFlags, useResponseSecurityParameters)
 			if err != nil {
 				x.Logger.Printf("UnmarshalTrap v3 auth: %s\n", err)
 				return nil
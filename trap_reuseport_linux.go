@@ -0,0 +1,11 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build !mips && !mipsle && !mips64 && !mips64le
+// +build !mips,!mipsle,!mips64,!mips64le
+
+package gosnmp
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks on Linux.
+const soReusePort = 0xf
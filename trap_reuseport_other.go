@@ -0,0 +1,20 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package gosnmp
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// setReusePort is not supported on this platform, see
+// TrapListener.ReusePort.
+func setReusePort(c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+}
@@ -7,10 +7,12 @@
 package gosnmp
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"net"
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -1229,3 +1231,512 @@ func TestSendV3TrapSHAAuthAES256CPriv(t *testing.T) {
 	}
 
 }
+
+func TestSendV3TrapEngineBootsTime(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	ts := &GoSNMP{
+		Target:        trapTestAddress,
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Version:       Version3,
+		Timeout:       time.Duration(2) * time.Second,
+		MaxOids:       MaxOids,
+		SecurityModel: UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "test",
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  1,
+			AuthoritativeEngineID:    string([]byte{0x80, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04}),
+		},
+		MsgFlags: NoAuthNoPriv,
+		Logger:   NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	if err = ts.Connect(); err != nil {
+		t.Fatalf("Connect() err: %v", err)
+	}
+	defer ts.Conn.Close()
+
+	trap := SnmpTrap{
+		Variables:   []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}},
+		EngineBoots: 42,
+		EngineTime:  123456,
+	}
+	if _, err = ts.SendTrap(trap); err != nil {
+		t.Fatalf("SendTrap() err: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	srvr.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := srvr.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading trap: %v", err)
+	}
+
+	var pkt SnmpPacket
+	if _, err = ts.unmarshalHeader(buf[:n], &pkt); err != nil {
+		t.Fatalf("error unmarshalling trap header: %v", err)
+	}
+	usp := pkt.SecurityParameters.(*UsmSecurityParameters)
+	if usp.AuthoritativeEngineBoots != 42 || usp.AuthoritativeEngineTime != 123456 {
+		t.Errorf("expected boots/time 42/123456, got %d/%d", usp.AuthoritativeEngineBoots, usp.AuthoritativeEngineTime)
+	}
+
+	// the connection's own parameters are untouched
+	sp := ts.SecurityParameters.(*UsmSecurityParameters)
+	if sp.AuthoritativeEngineBoots != 1 || sp.AuthoritativeEngineTime != 1 {
+		t.Errorf("connection boots/time modified: %d/%d", sp.AuthoritativeEngineBoots, sp.AuthoritativeEngineTime)
+	}
+}
+
+func TestListenConcatenatedMessages(t *testing.T) {
+	received := make(chan *SnmpPacket, 2)
+	tl := NewTrapListener()
+	defer tl.Close()
+
+	tl.OnNewTrap = func(p *SnmpPacket, addr *net.UDPAddr) {
+		received <- p
+	}
+	tl.Params = &GoSNMP{
+		Version:              Version2c,
+		Community:            "public",
+		ConcatenatedMessages: true,
+		Logger:               NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+
+	errch := make(chan error)
+	go func() {
+		err := tl.Listen(net.JoinHostPort(trapTestAddress, trapTestPortString))
+		if err != nil {
+			errch <- err
+		}
+	}()
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("error in listen: %v", err)
+	}
+
+	var datagram []byte
+	for _, requestID := range []uint32{4242, 4243} {
+		trap := &SnmpPacket{
+			Version:   Version2c,
+			Community: "public",
+			PDUType:   SNMPv2Trap,
+			RequestID: requestID,
+			Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}},
+		}
+		b, err := trap.marshalMsg()
+		if err != nil {
+			t.Fatalf("marshalMsg() err: %v", err)
+		}
+		datagram = append(datagram, b...)
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(trapTestAddress, trapTestPortString))
+	if err != nil {
+		t.Fatalf("Dial() err: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(datagram); err != nil {
+		t.Fatalf("Write() err: %v", err)
+	}
+
+	var requestIDs []uint32
+	for len(requestIDs) < 2 {
+		select {
+		case p := <-received:
+			requestIDs = append(requestIDs, p.RequestID)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for traps, got %v", requestIDs)
+		}
+	}
+	if !reflect.DeepEqual(requestIDs, []uint32{4242, 4243}) {
+		t.Errorf("unexpected request IDs %v", requestIDs)
+	}
+}
+
+type trapTestContextKey struct{}
+
+func TestListenContext(t *testing.T) {
+	type handled struct {
+		ctx  context.Context
+		addr *net.UDPAddr
+	}
+	received := make(chan handled, 1)
+	tl := NewTrapListener()
+	defer tl.Close()
+
+	tl.Context = context.WithValue(context.Background(), trapTestContextKey{}, "base")
+	tl.OnNewTrapContext = func(ctx context.Context, p *SnmpPacket, addr *net.UDPAddr) {
+		received <- handled{ctx, addr}
+	}
+	tl.Params = &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+
+	errch := make(chan error)
+	go func() {
+		err := tl.Listen(net.JoinHostPort(trapTestAddress, trapTestPortString))
+		if err != nil {
+			errch <- err
+		}
+	}()
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("error in listen: %v", err)
+	}
+
+	trap := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   SNMPv2Trap,
+		Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}},
+	}
+	b, err := trap.marshalMsg()
+	if err != nil {
+		t.Fatalf("marshalMsg() err: %v", err)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(trapTestAddress, trapTestPortString))
+	if err != nil {
+		t.Fatalf("Dial() err: %v", err)
+	}
+	defer conn.Close()
+	sent := time.Now()
+	if _, err = conn.Write(b); err != nil {
+		t.Fatalf("Write() err: %v", err)
+	}
+
+	var h handled
+	select {
+	case h = <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for trap")
+	}
+
+	if v := h.ctx.Value(trapTestContextKey{}); v != "base" {
+		t.Errorf("context not derived from the listener's Context, got value %v", v)
+	}
+	source, ok := TrapSource(h.ctx)
+	if !ok || source.String() != conn.LocalAddr().String() || source != h.addr {
+		t.Errorf("unexpected source %v (ok %v), want %v", source, ok, conn.LocalAddr())
+	}
+	receiveTime, ok := TrapReceiveTime(h.ctx)
+	if !ok || receiveTime.Before(sent) || receiveTime.After(time.Now()) {
+		t.Errorf("unexpected receive time %v (ok %v), sent at %v", receiveTime, ok, sent)
+	}
+}
+
+func TestListenReusePort(t *testing.T) {
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd":
+	default:
+		t.Skipf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+	}
+
+	addr := net.JoinHostPort(trapTestAddress, "9163")
+	listen := func(reusePort bool) (*TrapListener, error) {
+		tl := NewTrapListener()
+		tl.ReusePort = reusePort
+		tl.Params = &GoSNMP{Logger: NewLogger(log.New(ioutil.Discard, "", 0))}
+		errch := make(chan error, 1)
+		go func() {
+			errch <- tl.Listen(addr)
+		}()
+		select {
+		case <-tl.Listening():
+			return tl, nil
+		case err := <-errch:
+			return nil, err
+		}
+	}
+
+	first, err := listen(true)
+	if err != nil {
+		t.Fatalf("first listener: %v", err)
+	}
+	defer first.Close()
+
+	second, err := listen(true)
+	if err != nil {
+		t.Fatalf("second listener sharing the port: %v", err)
+	}
+	defer second.Close()
+
+	if third, err := listen(false); err == nil {
+		third.Close()
+		t.Error("listener without ReusePort should not bind to the shared port")
+	}
+}
+
+func TestTrapEnterprise(t *testing.T) {
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	trap := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   SNMPv2Trap,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(300)},
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: trapTestEnterpriseOid + ".0.55"},
+			{Name: ".1.3.6.1.6.3.1.1.4.3.0", Type: ObjectIdentifier, Value: trapTestEnterpriseOid},
+		},
+	}
+	b, err := trap.marshalMsg()
+	if err != nil {
+		t.Fatalf("marshalMsg() err: %v", err)
+	}
+	result := x.UnmarshalTrap(b, false)
+	if result == nil {
+		t.Fatal("UnmarshalTrap() failed")
+	}
+	if enterprise, ok := result.TrapEnterprise(); !ok || enterprise != trapTestEnterpriseOid {
+		t.Errorf("TrapEnterprise() = %q, %v, want %q, true", enterprise, ok, trapTestEnterpriseOid)
+	}
+
+	trap.Variables = trap.Variables[:2]
+	if b, err = trap.marshalMsg(); err != nil {
+		t.Fatalf("marshalMsg() err: %v", err)
+	}
+	if result = x.UnmarshalTrap(b, false); result == nil {
+		t.Fatal("UnmarshalTrap() failed")
+	}
+	if enterprise, ok := result.TrapEnterprise(); ok {
+		t.Errorf("TrapEnterprise() = %q for a trap without snmpTrapEnterprise.0", enterprise)
+	}
+
+	v1 := &SnmpPacket{PDUType: Trap, SnmpTrap: SnmpTrap{Enterprise: trapTestEnterpriseOid}}
+	if enterprise, ok := v1.TrapEnterprise(); !ok || enterprise != trapTestEnterpriseOid {
+		t.Errorf("v1 TrapEnterprise() = %q, %v", enterprise, ok)
+	}
+}
+
+func TestTrapSecurityLevel(t *testing.T) {
+	levels := make(chan string, 2)
+	tl := NewTrapListener()
+	defer tl.Close()
+
+	sp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "password",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "password",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  1,
+		AuthoritativeEngineID:    string([]byte{0x80, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04}),
+	}
+
+	tl.OnNewTrap = func(p *SnmpPacket, addr *net.UDPAddr) {
+		levels <- p.SecurityLevel()
+	}
+	tl.Params = &GoSNMP{
+		Version:            Version3,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp,
+		MsgFlags:           AuthPriv,
+		Logger:             NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+
+	errch := make(chan error)
+	go func() {
+		err := tl.Listen(net.JoinHostPort(trapTestAddress, trapTestPortString))
+		if err != nil {
+			errch <- err
+		}
+	}()
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("error in listen: %v", err)
+	}
+
+	receive := func() string {
+		select {
+		case level := <-levels:
+			return level
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for trap")
+		}
+		return ""
+	}
+	trap := SnmpTrap{
+		Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}},
+	}
+
+	v2c := &GoSNMP{
+		Target:    trapTestAddress,
+		Port:      trapTestPort,
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   2 * time.Second,
+		MaxOids:   MaxOids,
+	}
+	if err := v2c.Connect(); err != nil {
+		t.Fatalf("Connect() err: %v", err)
+	}
+	defer v2c.Conn.Close()
+	if _, err := v2c.SendTrap(trap); err != nil {
+		t.Fatalf("SendTrap() err: %v", err)
+	}
+	if level := receive(); level != "v2c" {
+		t.Errorf("v2c trap reported security level %q", level)
+	}
+
+	v3 := &GoSNMP{
+		Target:             trapTestAddress,
+		Port:               trapTestPort,
+		Version:            Version3,
+		Timeout:            2 * time.Second,
+		MaxOids:            MaxOids,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp.Copy(),
+		MsgFlags:           AuthPriv,
+	}
+	if err := v3.Connect(); err != nil {
+		t.Fatalf("Connect() err: %v", err)
+	}
+	defer v3.Conn.Close()
+	if _, err := v3.SendTrap(trap); err != nil {
+		t.Fatalf("SendTrap() err: %v", err)
+	}
+	if level := receive(); level != "authPriv" {
+		t.Errorf("v3 authPriv trap reported security level %q", level)
+	}
+}
+
+func TestListenHandlerTimeout(t *testing.T) {
+	type handled struct {
+		ctx     context.Context
+		payload string
+	}
+	calls := make(chan handled, 2)
+	release := make(chan struct{})
+	defer close(release)
+
+	tl := NewTrapListener()
+	defer tl.Close()
+	tl.HandlerTimeout = 50 * time.Millisecond
+	tl.OnNewTrapContext = func(ctx context.Context, p *SnmpPacket, addr *net.UDPAddr) {
+		calls <- handled{ctx, string(p.Variables[0].Value.([]byte))}
+		// block, ignoring ctx, until the test ends
+		<-release
+	}
+	tl.Params = &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+
+	errch := make(chan error)
+	go func() {
+		err := tl.Listen(net.JoinHostPort(trapTestAddress, trapTestPortString))
+		if err != nil {
+			errch <- err
+		}
+	}()
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("error in listen: %v", err)
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(trapTestAddress, trapTestPortString))
+	if err != nil {
+		t.Fatalf("Dial() err: %v", err)
+	}
+	defer conn.Close()
+	send := func(payload string) {
+		trap := &SnmpPacket{
+			Version:   Version2c,
+			Community: "public",
+			PDUType:   SNMPv2Trap,
+			Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: []byte(payload)}},
+		}
+		b, err := trap.marshalMsg()
+		if err != nil {
+			t.Fatalf("marshalMsg() err: %v", err)
+		}
+		if _, err = conn.Write(b); err != nil {
+			t.Fatalf("Write() err: %v", err)
+		}
+	}
+	receive := func() handled {
+		select {
+		case h := <-calls:
+			return h
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for trap")
+		}
+		return handled{}
+	}
+
+	send("first")
+	first := receive()
+	send("second")
+	start := time.Now()
+	second := receive()
+	if first.payload != "first" || second.payload != "second" {
+		t.Errorf("unexpected traps %q and %q", first.payload, second.payload)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("second trap handled after %s, the blocked handler wasn't abandoned", elapsed)
+	}
+	if first.ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("context of the abandoned handler has error %v, want %v", first.ctx.Err(), context.DeadlineExceeded)
+	}
+}
+
+func TestSendTrapContext(t *testing.T) {
+	// a receiver that never acknowledges informs
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer conn.Close()
+
+	ts := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Second,
+		Retries:   3,
+		MaxOids:   MaxOids,
+		Logger:    NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	if err = ts.Connect(); err != nil {
+		t.Fatalf("Connect() err: %v", err)
+	}
+	defer ts.Conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	trap := SnmpTrap{
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.1"},
+		},
+		IsInform: true,
+	}
+	start := time.Now()
+	_, err = ts.SendTrapContext(ctx, trap)
+	if err != context.Canceled {
+		t.Errorf("SendTrapContext() err: %v, expected %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("SendTrapContext() returned after %v", elapsed)
+	}
+	if ts.Context != context.Background() {
+		t.Errorf("Context was not restored")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//nolint:gochecknoglobals
+var uriAuthProtocols = map[string]SnmpV3AuthProtocol{
+	"md5":    MD5,
+	"sha":    SHA,
+	"sha224": SHA224,
+	"sha256": SHA256,
+	"sha384": SHA384,
+	"sha512": SHA512,
+}
+
+//nolint:gochecknoglobals
+var uriPrivProtocols = map[string]SnmpV3PrivProtocol{
+	"des":       DES,
+	"aes":       AES,
+	"aes192":    AES192,
+	"aes256":    AES256,
+	"aes192c":   AES192C,
+	"aes256c":   AES256C,
+	"aes256gcm": AES256GCM,
+}
+
+// ParseURI builds a GoSNMP from a DSN-like connection string. This is
+// convenient when the connection settings come from a single environment
+// variable or config entry. Two schemes are understood:
+//
+//	snmp://community@host:161?version=2c
+//	snmpv3://user:authpass:privpass@host:161?auth=sha&priv=aes
+//
+// For "snmp" the version query parameter may be "1" or "2c" (the default).
+// The community defaults to Default.Community when the URI has no user
+// info, while snmp://@host selects an empty community.
+// For "snmpv3" the security level is derived from the passphrases present:
+// a user only is NoAuthNoPriv, user:authpass is AuthNoPriv and
+// user:authpass:privpass is AuthPriv. The auth and priv query parameters
+// select the protocols and are required when the matching passphrase is set.
+// The optional context query parameter sets ContextName.
+//
+// The port defaults to Default.Port, and the remaining settings (Timeout,
+// Retries, etc) are copied from Default. The returned GoSNMP is not connected.
+func ParseURI(uri string) (*GoSNMP, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse SNMP URI: %w", err)
+	}
+
+	x := &GoSNMP{
+		Target:             u.Hostname(),
+		Port:               Default.Port,
+		Transport:          Default.Transport,
+		Community:          Default.Community,
+		Version:            Default.Version,
+		Timeout:            Default.Timeout,
+		Retries:            Default.Retries,
+		ExponentialTimeout: Default.ExponentialTimeout,
+		MaxOids:            Default.MaxOids,
+	}
+	if x.Target == "" {
+		return nil, fmt.Errorf("SNMP URI %q has no host", uri)
+	}
+
+	if p := u.Port(); p != "" {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in SNMP URI: %w", p, err)
+		}
+		x.Port = uint16(port)
+	}
+
+	query := u.Query()
+
+	switch strings.ToLower(u.Scheme) {
+	case "snmp":
+		if u.User != nil {
+			x.Community = u.User.Username()
+		}
+		switch v := query.Get("version"); v {
+		case "1":
+			x.Version = Version1
+		case "", "2c":
+			x.Version = Version2c
+		default:
+			return nil, fmt.Errorf("unsupported SNMP version %q in URI, use snmpv3:// for version 3", v)
+		}
+	case "snmpv3":
+		if err = x.parseURIV3(u.User, query); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP URI scheme %q", u.Scheme)
+	}
+
+	return x, nil
+}
+
+func (x *GoSNMP) parseURIV3(user *url.Userinfo, query url.Values) error {
+	if user == nil || user.Username() == "" {
+		return fmt.Errorf("snmpv3 URI requires a user name")
+	}
+
+	sp := &UsmSecurityParameters{
+		UserName:               user.Username(),
+		AuthenticationProtocol: NoAuth,
+		PrivacyProtocol:        NoPriv,
+	}
+	x.Version = Version3
+	x.SecurityModel = UserSecurityModel
+	x.MsgFlags = NoAuthNoPriv
+	x.ContextName = query.Get("context")
+
+	// url.Userinfo only splits on the first ':', so the password
+	// holds "authpass:privpass".
+	if pass, ok := user.Password(); ok && pass != "" {
+		passphrases := strings.SplitN(pass, ":", 2)
+
+		auth, ok := uriAuthProtocols[strings.ToLower(query.Get("auth"))]
+		if !ok {
+			return fmt.Errorf("snmpv3 URI has an auth passphrase but invalid auth protocol %q", query.Get("auth"))
+		}
+		sp.AuthenticationProtocol = auth
+		sp.AuthenticationPassphrase = passphrases[0]
+		x.MsgFlags = AuthNoPriv
+
+		if len(passphrases) == 2 && passphrases[1] != "" {
+			priv, ok := uriPrivProtocols[strings.ToLower(query.Get("priv"))]
+			if !ok {
+				return fmt.Errorf("snmpv3 URI has a priv passphrase but invalid priv protocol %q", query.Get("priv"))
+			}
+			sp.PrivacyProtocol = priv
+			sp.PrivacyPassphrase = passphrases[1]
+			x.MsgFlags = AuthPriv
+		}
+	}
+
+	x.SecurityParameters = sp
+	return nil
+}
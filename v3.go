@@ -10,10 +10,16 @@ package gosnmp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // SnmpV3MsgFlags contains various message flags to describe Authentication, Privacy, and whether a report PDU must be sent.
@@ -30,14 +36,17 @@ const (
 // SnmpV3SecurityModel describes the security model used by a SnmpV3 connection
 type SnmpV3SecurityModel uint8
 
-// UserSecurityModel is the only SnmpV3SecurityModel currently implemented.
+// The SnmpV3SecurityModels implemented.
 const (
-	UserSecurityModel SnmpV3SecurityModel = 3
+	UserSecurityModel      SnmpV3SecurityModel = 3
+	TransportSecurityModel SnmpV3SecurityModel = 4 // RFC 5591, with the "tls" transport only
 )
 
 // SnmpV3SecurityParameters is a generic interface type to contain various implementations of SnmpV3SecurityParameters
 type SnmpV3SecurityParameters interface {
 	Log()
+	LogSecurity(level LogLevel)
+	SafeString() string
 	Copy() SnmpV3SecurityParameters
 	Description() string
 	validate(flags SnmpV3MsgFlags) error
@@ -55,15 +64,61 @@ type SnmpV3SecurityParameters interface {
 	initSecurityKeys() error
 }
 
-func (x *GoSNMP) validateParametersV3() error {
-	// update following code if you implement a new security model
-	if x.SecurityModel != UserSecurityModel {
-		return errors.New("the SNMPV3 User Security Model is the only SNMPV3 security model currently implemented")
+// EngineState is an observation of an SNMPV3 authoritative engine's
+// snmpEngineBoots and snmpEngineTime.
+type EngineState struct {
+	Boots uint32
+	Time  uint32
+}
+
+// EngineChangeKind classifies the change between two EngineState
+// observations, see ClassifyEngineChange.
+type EngineChangeKind uint8
+
+const (
+	// EngineAdvanced is a normal change: same boots, time not going backwards.
+	EngineAdvanced EngineChangeKind = iota
+	// EngineRebooted means the engine has rebooted, as boots increased.
+	EngineRebooted
+	// EngineAnomaly means time went backwards with the same boots, or
+	// boots decreased, which may indicate a replay or misconfigured agent.
+	EngineAnomaly
+)
+
+// ClassifyEngineChange compares two observations of an engine's boots and
+// time, per the RFC 3414 time model, to detect reboots and rollbacks.
+func ClassifyEngineChange(prev, cur EngineState) EngineChangeKind {
+	switch {
+	case cur.Boots > prev.Boots:
+		return EngineRebooted
+	case cur.Boots < prev.Boots:
+		return EngineAnomaly
+	case cur.Time < prev.Time:
+		return EngineAnomaly
+	default:
+		return EngineAdvanced
 	}
+}
+
+func (x *GoSNMP) validateParametersV3() error {
 	if x.SecurityParameters == nil {
 		return errors.New("SNMPV3 SecurityParameters must be set")
 	}
 
+	// update following code if you implement a new security model
+	switch x.SecurityModel {
+	case UserSecurityModel:
+	case TransportSecurityModel:
+		if !x.tlsTransport() {
+			return fmt.Errorf("the SNMPV3 Transport Security Model requires the tls transport, not %s", x.Transport)
+		}
+		if _, ok := x.SecurityParameters.(*TsmSecurityParameters); !ok {
+			return errors.New("the SNMPV3 Transport Security Model requires TsmSecurityParameters")
+		}
+	default:
+		return errors.New("the SNMPV3 User and Transport Security Models are the only SNMPV3 security models currently implemented")
+	}
+
 	return x.SecurityParameters.validate(x.MsgFlags)
 }
 
@@ -89,11 +144,10 @@ func (packet *SnmpPacket) authenticate(msg []byte) ([]byte, error) {
 	return msg, nil
 }
 
-func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, useResponseSecurityParameters bool) error {
+func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, msgFlags SnmpV3MsgFlags, useResponseSecurityParameters bool) error {
 	if x.Version != Version3 {
 		return fmt.Errorf("testAuthentication called with non Version3 connection")
 	}
-	msgFlags := x.MsgFlags
 	if useResponseSecurityParameters {
 		msgFlags = result.MsgFlags
 	}
@@ -117,8 +171,22 @@ func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, useRespon
 	return nil
 }
 
+// relocalizeKeys localizes the keys of x.SecurityParameters again for the
+// authoritative engine of the usmStatsWrongDigests report in result.
+func (x *GoSNMP) relocalizeKeys(result *SnmpPacket) error {
+	usp, err := castUsmSecParams(x.SecurityParameters)
+	if err != nil {
+		return err
+	}
+	rsp, err := castUsmSecParams(result.SecurityParameters)
+	if err != nil {
+		return err
+	}
+	return usp.relocalizeKeys(rsp)
+}
+
 func (x *GoSNMP) initPacket(packetOut *SnmpPacket) error {
-	if x.MsgFlags&AuthPriv > AuthNoPriv {
+	if packetOut.MsgFlags&AuthPriv > AuthNoPriv {
 		return x.SecurityParameters.initPacket(packetOut)
 	}
 
@@ -140,11 +208,25 @@ func (x *GoSNMP) negotiateInitialSecurityParameters(packetOut *SnmpPacket) error
 	}
 
 	if discoveryPacket := packetOut.SecurityParameters.discoveryRequired(); discoveryPacket != nil {
+		if x.loadEngineDiscovery() {
+			x.Logger.Print("SEND using cached engine discovery")
+			return x.updatePktSecurityParameters(packetOut)
+		}
+
 		discoveryPacket.ContextName = x.ContextName
-		result, err := x.sendOneRequest(discoveryPacket, true)
+		timeout, retries := x.discoveryTimeout()
+		result, err := x.sendOneRequestRetrying(discoveryPacket, true, timeout, retries)
 
 		if err != nil {
-			return err
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", ErrEngineDiscoveryFailed, err)
+		}
+
+		// keys localized for an empty engine ID would fail authentication
+		if usp, ok := result.SecurityParameters.(*UsmSecurityParameters); ok && usp.AuthoritativeEngineID == "" {
+			return fmt.Errorf("%w in the engine discovery response", ErrEmptyEngineID)
 		}
 
 		err = x.storeSecurityParameters(result)
@@ -166,6 +248,290 @@ func (x *GoSNMP) negotiateInitialSecurityParameters(packetOut *SnmpPacket) error
 	return nil
 }
 
+// discoveryTimeout returns the timeout and retries of engine discovery
+// probes, see DiscoveryTimeout and DiscoveryRetries.
+func (x *GoSNMP) discoveryTimeout() (time.Duration, int) {
+	timeout, retries := x.Timeout, x.Retries
+	if x.DiscoveryTimeout > 0 {
+		timeout = x.DiscoveryTimeout
+	}
+	if x.DiscoveryRetries != 0 {
+		retries = x.DiscoveryRetries
+		if retries < 0 {
+			retries = 0
+		}
+	}
+	return timeout, retries
+}
+
+// startEngineKeepalive starts the EngineKeepalive goroutine. It probes over
+// a copy of x taken now, as x must not be used concurrently, and applies the
+// engine state learnt to SecurityParameters, which is safe for concurrent
+// use. The copy gets its own msgID and requestID sequence, so that its
+// probes don't reuse the IDs of x's requests.
+func (x *GoSNMP) startEngineKeepalive() error {
+	sp, ok := x.SecurityParameters.(*UsmSecurityParameters)
+	if !ok {
+		return nil
+	}
+	random, err := x.newRandom()
+	if err != nil {
+		return err
+	}
+	probe := *x
+	probe.random = random
+	probe.msgID = random
+	probe.requestID = random
+	probe.Conn = nil
+	probe.uaddr = nil
+	probe.rxBuf = new([rxBufSize]byte)
+	probe.sendSlots = nil
+	probe.SecurityParameters = sp.Copy()
+	probe.keepaliveStop = nil
+	stop := make(chan struct{})
+	x.keepaliveStop = stop
+
+	go func() {
+		defer func() {
+			if probe.Conn != nil {
+				probe.Conn.Close()
+			}
+		}()
+		var done <-chan struct{}
+		if probe.Context != nil {
+			done = probe.Context.Done()
+		}
+		ticker := time.NewTicker(probe.EngineKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := probe.refreshEngine(sp); err != nil {
+				probe.Logger.Printf("WARNING engine keepalive: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// refreshEngine sends a discovery probe and applies the engine boots and
+// time of the response to sp. The response is not authenticated, so it is
+// ignored unless it is from the engine already discovered and moves its
+// boots and time forward, as a replayed or spoofed one could otherwise
+// push later requests out of the time window.
+func (x *GoSNMP) refreshEngine(sp *UsmSecurityParameters) error {
+	if x.Conn == nil {
+		if err := x.netConnect(); err != nil {
+			return err
+		}
+	}
+	packet := (&UsmSecurityParameters{Logger: x.Logger}).discoveryRequired()
+	packet.ContextName = x.ContextName
+	timeout, retries := x.discoveryTimeout()
+	result, err := x.sendOneRequestRetrying(packet, true, timeout, retries)
+	if err != nil {
+		return err
+	}
+	usp, ok := result.SecurityParameters.(*UsmSecurityParameters)
+	if !ok || usp.AuthoritativeEngineID == "" {
+		return fmt.Errorf("%w in the engine discovery response", ErrEmptyEngineID)
+	}
+
+	sp.mu.Lock()
+	engineID, boots, engineTime := sp.AuthoritativeEngineID, sp.AuthoritativeEngineBoots, sp.AuthoritativeEngineTime
+	sp.mu.Unlock()
+	switch {
+	case engineID == "":
+		// not discovered yet
+	case usp.AuthoritativeEngineID != engineID:
+		return fmt.Errorf("ignoring the discovery response of engine %x, expected engine %x",
+			usp.AuthoritativeEngineID, engineID)
+	case usp.AuthoritativeEngineBoots < boots,
+		usp.AuthoritativeEngineBoots == boots && usp.AuthoritativeEngineTime < engineTime:
+		return fmt.Errorf("ignoring engine boots %d and time %d, older than boots %d and time %d",
+			usp.AuthoritativeEngineBoots, usp.AuthoritativeEngineTime, boots, engineTime)
+	}
+	return sp.setSecurityParameters(usp)
+}
+
+// stopEngineKeepalive stops the EngineKeepalive goroutine, if any.
+func (x *GoSNMP) stopEngineKeepalive() {
+	if x.keepaliveStop != nil {
+		close(x.keepaliveStop)
+		x.keepaliveStop = nil
+	}
+}
+
+// NewV3WithKeys returns a GoSNMP for the SNMPv3 USM user userName on target,
+// using secretKey and privacyKey already localized for the agent's
+// authoritative engineID, eg keys kept in a secrets store in place of the
+// passphrases they were derived from. The security level follows from the
+// protocols: NoAuth is NoAuthNoPriv, and NoPriv is AuthNoPriv. As the keys
+// are only valid for engineID they are not re-localized when the agent
+// reports another engine.
+//
+// As with ParseURI the remaining settings are copied from Default, and the
+// returned GoSNMP is not connected.
+func NewV3WithKeys(target, userName, engineID string, authProtocol SnmpV3AuthProtocol, secretKey []byte,
+	privProtocol SnmpV3PrivProtocol, privacyKey []byte) (*GoSNMP, error) {
+	if engineID == "" {
+		return nil, errors.New("localized keys require the engine ID they were localized for")
+	}
+	if authProtocol <= NoAuth {
+		authProtocol = NoAuth
+	}
+	if privProtocol <= NoPriv {
+		privProtocol = NoPriv
+	}
+	if authProtocol > NoAuth && len(secretKey) == 0 {
+		return nil, errors.New("an authentication protocol requires a secret key")
+	}
+	if privProtocol > NoPriv && len(privacyKey) == 0 {
+		return nil, errors.New("a privacy protocol requires a privacy key")
+	}
+
+	msgFlags := NoAuthNoPriv
+	if privProtocol > NoPriv {
+		msgFlags = AuthPriv
+	} else if authProtocol > NoAuth {
+		msgFlags = AuthNoPriv
+	}
+
+	sp := &UsmSecurityParameters{
+		UserName:               userName,
+		AuthoritativeEngineID:  engineID,
+		AuthenticationProtocol: authProtocol,
+		PrivacyProtocol:        privProtocol,
+		SecretKey:              append([]byte(nil), secretKey...),
+		PrivacyKey:             append([]byte(nil), privacyKey...),
+	}
+	if err := sp.validate(msgFlags); err != nil {
+		return nil, err
+	}
+
+	return &GoSNMP{
+		Target:             target,
+		Port:               Default.Port,
+		Transport:          Default.Transport,
+		Version:            Version3,
+		Timeout:            Default.Timeout,
+		Retries:            Default.Retries,
+		ExponentialTimeout: Default.ExponentialTimeout,
+		MaxOids:            Default.MaxOids,
+		SecurityModel:      UserSecurityModel,
+		MsgFlags:           msgFlags,
+		SecurityParameters: sp,
+	}, nil
+}
+
+// BuildDiscoveryPacket returns the marshalled SNMPV3 engine discovery probe
+// sent before the first request when the authoritative engine ID is unknown:
+// a blank, Reportable, NoAuthNoPriv GetRequest. It allows discovery over a
+// custom transport. Each call uses new request and message IDs.
+func (x *GoSNMP) BuildDiscoveryPacket() ([]byte, error) {
+	if x.Version != Version3 {
+		return nil, fmt.Errorf("BuildDiscoveryPacket called with non Version3 connection")
+	}
+	if x.SecurityModel != UserSecurityModel {
+		return nil, errors.New("the SNMPV3 User Security Model is the only SNMPV3 security model currently implemented")
+	}
+
+	packet := (&UsmSecurityParameters{Logger: x.Logger}).discoveryRequired()
+	packet.ContextName = x.ContextName
+	packet.RequestID = atomic.AddUint32(&(x.requestID), 1) & 0x7FFFFFFF
+	packet.MsgID = atomic.AddUint32(&(x.msgID), 1) & 0x7FFFFFFF
+	return packet.marshalMsg()
+}
+
+// ScopedPDU returns the raw ScopedPDU of the SNMPV3 message msg, decrypted
+// if need be: the contextEngineID, contextName and PDU, eg for a proxy to
+// forward with WrapScopedPDU. msg is authenticated with SecurityParameters
+// when its msgFlags ask for it, and is not modified.
+func (x *GoSNMP) ScopedPDU(msg []byte) ([]byte, error) {
+	if x.Version != Version3 || x.SecurityParameters == nil {
+		return nil, errors.New("ScopedPDU requires an SNMPV3 connection with SecurityParameters")
+	}
+	if err := x.validateParameters(); err != nil {
+		return nil, err
+	}
+	if err := x.SecurityParameters.initSecurityKeys(); err != nil {
+		return nil, err
+	}
+
+	// decryption is done in place
+	msg = append([]byte(nil), msg...)
+	result := &SnmpPacket{Logger: x.Logger, SecurityParameters: x.SecurityParameters.Copy()}
+	cursor, err := x.unmarshalHeader(msg, result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode packet header: %w", err)
+	}
+	if result.Version != Version3 {
+		return nil, fmt.Errorf("ScopedPDU called with an SNMP version %s message", result.Version)
+	}
+	if err = x.testAuthentication(msg, result, result.MsgFlags, false); err != nil {
+		return nil, err
+	}
+	start := cursor
+	if msg, _, err = x.decryptPacket(msg, cursor, result); err != nil {
+		return nil, err
+	}
+
+	scopedPDU := msg[start:]
+	if err = checkScopedPDU(scopedPDU); err != nil {
+		return nil, err
+	}
+	return scopedPDU, nil
+}
+
+// WrapScopedPDU returns a new SNMPV3 message carrying scopedPDU, as returned
+// by ScopedPDU, unchanged. The message has a new message ID and the MsgFlags
+// and SecurityParameters of x, and is authenticated and encrypted as they
+// require, so the authoritative engine must be known to x.
+func (x *GoSNMP) WrapScopedPDU(scopedPDU []byte) ([]byte, error) {
+	if x.Version != Version3 || x.SecurityParameters == nil {
+		return nil, errors.New("WrapScopedPDU requires an SNMPV3 connection with SecurityParameters")
+	}
+	if err := checkScopedPDU(scopedPDU); err != nil {
+		return nil, err
+	}
+	if err := x.validateParameters(); err != nil {
+		return nil, err
+	}
+	if err := x.SecurityParameters.initSecurityKeys(); err != nil {
+		return nil, err
+	}
+
+	packet := x.mkSnmpPacket(GetRequest, nil, 0, 0)
+	packet.Logger = x.Logger
+	packet.rawScopedPDU = scopedPDU
+	packet.MsgID = atomic.AddUint32(&(x.msgID), 1) & 0x7FFFFFFF
+	if err := x.initPacket(packet); err != nil {
+		return nil, err
+	}
+	return packet.marshalMsg()
+}
+
+// checkScopedPDU checks that b is a single plaintext ScopedPDU: a sequence
+// whose length is that of b.
+func checkScopedPDU(b []byte) error {
+	if len(b) == 0 || PDUType(b[0]) != Sequence {
+		return errors.New("invalid ScopedPDU: not a sequence")
+	}
+	header, length, err := peekHeader(b)
+	if err != nil {
+		return fmt.Errorf("invalid ScopedPDU: %w", err)
+	}
+	if header+length != len(b) {
+		return fmt.Errorf("invalid ScopedPDU: length %d, got %d bytes", header+length, len(b))
+	}
+	return nil
+}
+
 // save the connection security parameters after a request/response
 func (x *GoSNMP) storeSecurityParameters(result *SnmpPacket) error {
 	if x.Version != Version3 || result.Version != Version3 {
@@ -180,7 +546,149 @@ func (x *GoSNMP) storeSecurityParameters(result *SnmpPacket) error {
 		x.ContextEngineID = result.SecurityParameters.getDefaultContextEngineID()
 	}
 
-	return x.SecurityParameters.setSecurityParameters(result.SecurityParameters)
+	if err := x.SecurityParameters.setSecurityParameters(result.SecurityParameters); err != nil {
+		return err
+	}
+	x.storeEngineDiscovery()
+	return nil
+}
+
+// EngineCache holds the SNMPV3 authoritative engine ID, boots and time of
+// agents, keyed by their "host:port" address, see GoSNMP.EngineCache.
+// Implementations must be safe for concurrent use.
+type EngineCache interface {
+	// Get returns the engine of the agent at addr, with its current time.
+	Get(addr string) (engineID string, boots, time uint32, ok bool)
+	// Set stores the engine of the agent at addr.
+	Set(addr string, engineID string, boots, time uint32)
+	// Delete forgets the engine of the agent at addr.
+	Delete(addr string)
+}
+
+// MemoryEngineCache is an in-memory EngineCache, see NewMemoryEngineCache.
+type MemoryEngineCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]engineCacheEntry
+}
+
+// engineCacheEntry is an engine as stored in a MemoryEngineCache.
+type engineCacheEntry struct {
+	engineID string
+	boots    uint32
+	time     uint32
+	stored   time.Time
+}
+
+// NewMemoryEngineCache returns an empty MemoryEngineCache whose entries
+// expire ttl after they were stored, or never if ttl is zero.
+func NewMemoryEngineCache(ttl time.Duration) *MemoryEngineCache {
+	return &MemoryEngineCache{ttl: ttl, entries: make(map[string]engineCacheEntry)}
+}
+
+// Get returns the engine stored for addr, its time advanced by the time
+// elapsed since, unless it has expired.
+func (c *MemoryEngineCache) Get(addr string) (engineID string, boots, engineTime uint32, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[addr]
+	if !ok {
+		return "", 0, 0, false
+	}
+	elapsed := time.Since(entry.stored)
+	if c.ttl > 0 && elapsed > c.ttl {
+		delete(c.entries, addr)
+		return "", 0, 0, false
+	}
+	// the engine time has moved on since it was stored
+	return entry.engineID, entry.boots, entry.time + uint32(elapsed/time.Second), true
+}
+
+// Set stores the engine of addr.
+func (c *MemoryEngineCache) Set(addr string, engineID string, boots, engineTime uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[addr] = engineCacheEntry{engineID: engineID, boots: boots, time: engineTime, stored: time.Now()}
+}
+
+// Delete forgets the engine of addr.
+func (c *MemoryEngineCache) Delete(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, addr)
+}
+
+// engineDiscoveryCache is shared by GoSNMP instances with
+// ShareEngineDiscovery set.
+var engineDiscoveryCache = NewMemoryEngineCache(0) //nolint:gochecknoglobals
+
+// engineCache returns the EngineCache of the connection, nil if none.
+func (x *GoSNMP) engineCache() EngineCache {
+	switch {
+	case x.EngineCache != nil:
+		return x.EngineCache
+	case x.ShareEngineDiscovery:
+		return engineDiscoveryCache
+	}
+	return nil
+}
+
+func (x *GoSNMP) engineDiscoveryKey() string {
+	return net.JoinHostPort(x.Target, strconv.Itoa(int(x.Port)))
+}
+
+// loadEngineDiscovery applies a cached engine discovery to the connection's
+// security parameters, reporting whether one was found.
+func (x *GoSNMP) loadEngineDiscovery() bool {
+	cache := x.engineCache()
+	if cache == nil {
+		return false
+	}
+	engineID, boots, engineTime, ok := cache.Get(x.engineDiscoveryKey())
+	if !ok || engineID == "" {
+		return false
+	}
+
+	err := x.SecurityParameters.setSecurityParameters(&UsmSecurityParameters{
+		AuthoritativeEngineID:    engineID,
+		AuthoritativeEngineBoots: boots,
+		AuthoritativeEngineTime:  engineTime,
+	})
+	if err != nil {
+		x.Logger.Printf("ERROR applying cached engine discovery: %s", err)
+		return false
+	}
+	if x.ContextEngineID == "" {
+		x.ContextEngineID = engineID
+	}
+	return true
+}
+
+// storeEngineDiscovery caches the connection's current engine state.
+func (x *GoSNMP) storeEngineDiscovery() {
+	cache := x.engineCache()
+	if cache == nil {
+		return
+	}
+	usp, err := castUsmSecParams(x.SecurityParameters)
+	if err != nil {
+		return
+	}
+	usp.mu.Lock()
+	engineID, boots, engineTime := usp.AuthoritativeEngineID, usp.AuthoritativeEngineBoots, usp.AuthoritativeEngineTime
+	usp.mu.Unlock()
+	if engineID == "" {
+		return
+	}
+	cache.Set(x.engineDiscoveryKey(), engineID, boots, engineTime)
+}
+
+// invalidateEngineDiscovery forgets the cached engine state for the target,
+// so the next connection performs its own discovery.
+func (x *GoSNMP) invalidateEngineDiscovery() {
+	if cache := x.engineCache(); cache != nil {
+		cache.Delete(x.engineDiscoveryKey())
+	}
 }
 
 // update packet security parameters to match connection security parameters
@@ -221,8 +729,10 @@ func (packet *SnmpPacket) marshalV3(buf *bytes.Buffer) (*bytes.Buffer, error) {
 	if err != nil {
 		return emptyBuffer, err
 	}
-	packet.Logger.Printf("Marshal V3 SecurityParameters len=%d. Eaten Last 4 Bytes=%v",
-		len(securityParameters), securityParameters[len(securityParameters)-4:])
+	if len(securityParameters) >= 4 {
+		packet.Logger.Printf("Marshal V3 SecurityParameters len=%d. Eaten Last 4 Bytes=%v",
+			len(securityParameters), securityParameters[len(securityParameters)-4:])
+	}
 
 	buf.Write([]byte{byte(OctetString)})
 	secParamLen, err := marshalLength(len(securityParameters))
@@ -280,23 +790,31 @@ func (packet *SnmpPacket) marshalV3Header() ([]byte, error) {
 
 // marshal and encrypt (if necessary) a snmp version 3 Scoped PDU
 func (packet *SnmpPacket) marshalV3ScopedPDU() ([]byte, error) {
-	var b []byte
+	var err error
 
-	scopedPdu, err := packet.prepareV3ScopedPDU()
-	if err != nil {
-		return nil, err
-	}
-	pduLen, err := marshalLength(len(scopedPdu))
-	if err != nil {
-		return nil, err
+	scopedPdu := packet.rawScopedPDU
+	if scopedPdu == nil {
+		var pdu, pduLen []byte
+		pdu, err = packet.prepareV3ScopedPDU()
+		if err != nil {
+			return nil, err
+		}
+		pduLen, err = marshalLength(len(pdu))
+		if err != nil {
+			return nil, err
+		}
+		scopedPdu = append(append([]byte{byte(Sequence)}, pduLen...), pdu...)
 	}
-	b = append([]byte{byte(Sequence)}, pduLen...)
-	scopedPdu = append(b, scopedPdu...)
 	if packet.MsgFlags&AuthPriv > AuthNoPriv {
 		scopedPdu, err = packet.SecurityParameters.encryptPacket(scopedPdu)
 		if err != nil {
 			return nil, err
 		}
+		// never send an AuthPriv ScopedPDU in cleartext; only the TSM
+		// leaves encryption to the transport
+		if packet.SecurityModel != TransportSecurityModel && (len(scopedPdu) == 0 || scopedPdu[0] != byte(OctetString)) {
+			return nil, fmt.Errorf("%w: the ScopedPDU is about to be sent in cleartext", ErrNotEncrypted)
+		}
 	}
 
 	return scopedPdu, nil
@@ -394,9 +912,21 @@ func (x *GoSNMP) unmarshalV3Header(packet []byte,
 		return 0, errors.New("error parsing SNMPV3 message ID: truncted packet")
 	}
 
-	if SecModel, ok := rawSecModel.(int); ok {
-		response.SecurityModel = SnmpV3SecurityModel(SecModel)
-		x.Logger.Printf("Parsed security model %d", SecModel)
+	SecModel, ok := rawSecModel.(int)
+	if !ok {
+		return 0, fmt.Errorf("error parsing SNMPV3 msgSecModel: unexpected value %v", rawSecModel)
+	}
+	response.SecurityModel = SnmpV3SecurityModel(SecModel)
+	x.Logger.Printf("Parsed security model %d", SecModel)
+	switch response.SecurityModel {
+	case UserSecurityModel:
+	case TransportSecurityModel:
+		// RFC 5591 section 5.2: only accept TSM messages from a secure transport
+		if !x.tlsTransport() {
+			return 0, fmt.Errorf("%w: msgSecurityModel %d received over %s", ErrUnknownSecurityModels, SecModel, x.Transport)
+		}
+	default:
+		return 0, fmt.Errorf("%w: msgSecurityModel %d is not supported", ErrUnknownSecurityModels, SecModel)
 	}
 
 	if PDUType(packet[cursor]) != PDUType(OctetString) {
@@ -408,7 +938,11 @@ func (x *GoSNMP) unmarshalV3Header(packet []byte,
 		return 0, errors.New("error parsing SNMPV3 message ID: truncted packet")
 	}
 	if response.SecurityParameters == nil {
-		response.SecurityParameters = &UsmSecurityParameters{Logger: x.Logger}
+		if response.SecurityModel == TransportSecurityModel {
+			response.SecurityParameters = &TsmSecurityParameters{Logger: x.Logger}
+		} else {
+			response.SecurityParameters = &UsmSecurityParameters{Logger: x.Logger}
+		}
 	}
 
 	cursor, err = response.SecurityParameters.unmarshal(response.MsgFlags, packet, cursor)
@@ -430,7 +964,16 @@ func (x *GoSNMP) decryptPacket(packet []byte, cursor int, response *SnmpPacket)
 
 	switch PDUType(packet[cursor]) {
 	case PDUType(OctetString):
-		// pdu is encrypted
+		// pdu is encrypted. Check the length it claims before allocating for
+		// the plaintext: it can't be more than the maximum message size we
+		// advertise in msgMaxSize, nor than the bytes received.
+		length, _ := parseLength(packet[cursor:])
+		if length < 0 || length > rxBufSize {
+			return nil, 0, fmt.Errorf("error decrypting ScopedPDU: length %d exceeds the maximum message size of %d", length, rxBufSize)
+		}
+		if cursor+length > len(packet) {
+			return nil, 0, errors.New("error parsing SNMPV3: truncated packet")
+		}
 		packet, err = response.SecurityParameters.decryptPacket(packet, cursor)
 		if err != nil {
 			return nil, 0, err
@@ -443,7 +986,7 @@ func (x *GoSNMP) decryptPacket(packet []byte, cursor int, response *SnmpPacket)
 		if decrypted {
 			// truncate padding that might have been included with
 			// the encrypted PDU
-			if cursor+tlength > len(packet) {
+			if tlength < 0 || cursor+tlength > len(packet) {
 				return nil, 0, errors.New("error parsing SNMPV3: truncated packet")
 			}
 			packet = packet[:cursor+tlength]
@@ -0,0 +1,189 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TsmSecurityParameters is an implementation of SnmpV3SecurityParameters for
+// the TransportSecurityModel (RFC 5591), used with the "tls" transport (RFC
+// 6353). The message is protected by the TLS session, so the security
+// parameters of the message are empty and the security level in MsgFlags is
+// only bounded by what the session provides, which for TLS is AuthPriv.
+type TsmSecurityParameters struct {
+	// SecurityName is the tmSecurityName of the session. If empty, Connect
+	// sets it from CertToName once the TLS handshake is done.
+	SecurityName string
+
+	// CertToName maps the fingerprints, as returned by TLSFingerprint, of
+	// certificates the peer may present to the tmSecurityName of the session,
+	// as the snmpTlstmCertToTSNTable of RFC 6353. The peer's certificate is
+	// looked up first, then the CAs of its chain.
+	CertToName map[string]string
+
+	Logger Logger
+}
+
+// Description logs the security parameters to the provided GoSNMP Logger
+func (sp *TsmSecurityParameters) Description() string {
+	return "tmSecurityName=" + sp.SecurityName
+}
+
+// Log logs security paramater information to the provided GoSNMP Logger
+func (sp *TsmSecurityParameters) Log() {
+	sp.Logger.Printf("SECURITY PARAMETERS:%+v", sp)
+}
+
+// SafeString returns a description of the security parameters, which hold
+// no secrets.
+func (sp *TsmSecurityParameters) SafeString() string {
+	return sp.Description()
+}
+
+// LogSecurity logs the SafeString of the security parameters at level
+func (sp *TsmSecurityParameters) LogSecurity(level LogLevel) {
+	sp.Logger.Logf(level, "SECURITY PARAMETERS:%s", sp.SafeString())
+}
+
+// Copy method for TsmSecurityParameters used to copy a SnmpV3SecurityParameters without knowing it's implementation
+func (sp *TsmSecurityParameters) Copy() SnmpV3SecurityParameters {
+	return &TsmSecurityParameters{
+		SecurityName: sp.SecurityName,
+		CertToName:   sp.CertToName,
+		Logger:       sp.Logger,
+	}
+}
+
+func (sp *TsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) init(log Logger) error {
+	sp.Logger = log
+	return nil
+}
+
+func (sp *TsmSecurityParameters) initPacket(packet *SnmpPacket) error {
+	return nil
+}
+
+// discoveryRequired returns nil: the TSM has no authoritative engine to
+// discover before the first request.
+func (sp *TsmSecurityParameters) discoveryRequired() *SnmpPacket {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) getDefaultContextEngineID() string {
+	return ""
+}
+
+func (sp *TsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParameters) error {
+	insp, ok := in.(*TsmSecurityParameters)
+	if !ok || insp == nil {
+		return fmt.Errorf("param SnmpV3SecurityParameters is not of type *TsmSecurityParameters")
+	}
+	sp.SecurityName = insp.SecurityName
+	return nil
+}
+
+// marshal returns the empty msgSecurityParameters of RFC 5591 section 4.
+func (sp *TsmSecurityParameters) marshal(flags SnmpV3MsgFlags) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (sp *TsmSecurityParameters) unmarshal(flags SnmpV3MsgFlags, packet []byte, cursor int) (int, error) {
+	return cursor, nil
+}
+
+// authenticate, isAuthentic, encryptPacket and decryptPacket leave the
+// message as is, as the TLS session authenticates and encrypts it.
+func (sp *TsmSecurityParameters) authenticate(packet []byte) error {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) isAuthentic(packetBytes []byte, packet *SnmpPacket) (bool, error) {
+	return true, nil
+}
+
+func (sp *TsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error) {
+	return scopedPdu, nil
+}
+
+func (sp *TsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byte, error) {
+	return packet, nil
+}
+
+func (sp *TsmSecurityParameters) initSecurityKeys() error {
+	return nil
+}
+
+// tlsFingerprintSHA256 is the TLS HashAlgorithm identifier of SHA-256, the
+// first octet of an RFC 6353 SnmpTLSFingerprint.
+const tlsFingerprintSHA256 = 4
+
+// TLSFingerprint returns the SHA-256 fingerprint of cert in the
+// SnmpTLSFingerprint form of RFC 6353, as colon separated hex octets: the
+// hash algorithm identifier 04 followed by the hash, eg "04:3f:a2:...".
+func TLSFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	octets := make([]string, 0, 1+len(sum))
+	octets = append(octets, hex.EncodeToString([]byte{tlsFingerprintSHA256}))
+	for _, b := range sum {
+		octets = append(octets, hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(octets, ":")
+}
+
+// VerifyTLSFingerprints returns a function for tls.Config's
+// VerifyPeerCertificate pinning the peer to fingerprints, as returned by
+// TLSFingerprint: the handshake fails unless the peer presents a certificate,
+// or a CA in its chain, with one of them. Set InsecureSkipVerify as well to
+// pin a self-signed certificate without validating its chain.
+func VerifyTLSFingerprints(fingerprints ...string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	pinned := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		pinned[strings.ToLower(fingerprint)] = true
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if pinned[TLSFingerprint(cert)] {
+				return nil
+			}
+		}
+		return errors.New("no certificate of the TLS peer matches a pinned fingerprint")
+	}
+}
+
+// setTLSSecurityName sets the SecurityName of TsmSecurityParameters from
+// their CertToName and the certificates the peer presented in state.
+func (x *GoSNMP) setTLSSecurityName(state tls.ConnectionState) error {
+	sp, ok := x.SecurityParameters.(*TsmSecurityParameters)
+	if !ok || sp == nil || sp.SecurityName != "" || sp.CertToName == nil {
+		return nil
+	}
+	certToName := make(map[string]string, len(sp.CertToName))
+	for fingerprint, name := range sp.CertToName {
+		certToName[strings.ToLower(fingerprint)] = name
+	}
+	for _, cert := range state.PeerCertificates {
+		if name, ok := certToName[TLSFingerprint(cert)]; ok {
+			sp.SecurityName = name
+			x.Logger.Printf("TLS peer %s maps to tmSecurityName %s", TLSFingerprint(cert), name)
+			return nil
+		}
+	}
+	return errors.New("no certificate of the TLS peer maps to a tmSecurityName")
+}
@@ -10,14 +10,15 @@ package gosnmp
 
 import (
 	"bytes"
+	"container/list"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des" //nolint:gosec
 	"crypto/hmac"
-	"crypto/md5" //nolint:gosec
+	_ "crypto/md5" //nolint:gosec // Register hash function #2 (MD5)
 	crand "crypto/rand"
-	"crypto/sha1"     //nolint:gosec
+	_ "crypto/sha1"   //nolint:gosec // Register hash function #3 (SHA1)
 	_ "crypto/sha256" // Register hash function #4 (SHA224), #5 (SHA256)
 	_ "crypto/sha512" // Register hash function #6 (SHA384), #7 (SHA512)
 	"encoding/binary"
@@ -124,6 +125,11 @@ const (
 	AES256  SnmpV3PrivProtocol = 5 // Blumenthal-AES256
 	AES192C SnmpV3PrivProtocol = 6 // Reeder-AES192
 	AES256C SnmpV3PrivProtocol = 7 // Reeder-AES256
+
+	// AES256GCM is AES-256 in GCM mode, a vendor extension with no
+	// assigned usmPrivProtocol. Its key is extended as for AES256, and a
+	// ScopedPDU failing the GCM tag check is rejected with ErrDecryption.
+	AES256GCM SnmpV3PrivProtocol = 8
 )
 
 //go:generate stringer -type=SnmpV3PrivProtocol
@@ -134,6 +140,8 @@ type UsmSecurityParameters struct {
 	// localAESSalt must be 64bit aligned to use with atomic operations.
 	localAESSalt uint64
 	localDESSalt uint32
+	// localAESSaltPrefix pads salts longer than 8 bytes, see PrivacySaltLength.
+	localAESSaltPrefix []byte
 
 	AuthoritativeEngineID    string
 	AuthoritativeEngineBoots uint32
@@ -142,6 +150,12 @@ type UsmSecurityParameters struct {
 	AuthenticationParameters string
 	PrivacyParameters        []byte
 
+	// PrivacySaltLength is the length in bytes of the AES salt sent as
+	// msgPrivacyParameters. Zero means the 8 bytes of RFC 3826, with the IV
+	// formed from the engine boots, engine time and salt. Some agents use
+	// a 16 byte salt, which is then used as the IV.
+	PrivacySaltLength int
+
 	AuthenticationProtocol SnmpV3AuthProtocol
 	PrivacyProtocol        SnmpV3PrivProtocol
 
@@ -151,9 +165,91 @@ type UsmSecurityParameters struct {
 	SecretKey  []byte
 	PrivacyKey []byte
 
+	// KeyPolicy, if set, is called before keys are localized from the
+	// passphrases and may veto the protocol combination by returning an
+	// error. See StrongKeyPolicy.
+	KeyPolicy func(authProtocol SnmpV3AuthProtocol, privProtocol SnmpV3PrivProtocol) error
+
+	// CryptoProvider if set, constructs the hashes and ciphers used for key
+	// localization, authentication and privacy, eg from a FIPS validated
+	// module. Nil means StdlibCryptoProvider.
+	CryptoProvider CryptoProvider
+
+	// SaltSource if set, is read by Connect for the random seed of the
+	// privacy salts in place of crypto/rand.Read; the salts of successive
+	// messages then increment from it. A fixed source makes the encrypted
+	// packets reproducible, eg for test vectors, and must not be used
+	// otherwise.
+	SaltSource func([]byte) (int, error)
+
+	// OnEngineIDChange if set, is called when the authoritative engine ID
+	// of the connection changes from one engine to another, eg after the
+	// agent failed over, and the keys are localized again for the new
+	// engine. It is not called for the discovery of the first engine. It
+	// runs in a goroutine of its own, so that it doesn't hold up requests.
+	OnEngineIDChange func(old, new string)
+
 	Logger Logger
 }
 
+// CryptoProvider constructs the hash functions and block ciphers of the User
+// Security Model. Implementations must be safe for concurrent use.
+type CryptoProvider interface {
+	// NewHash returns a new hash.Hash computing h, one of MD5, SHA1, SHA224,
+	// SHA256, SHA384 or SHA512.
+	NewHash(h crypto.Hash) hash.Hash
+	// NewAESCipher returns an AES cipher.Block for a 16, 24 or 32 byte key.
+	NewAESCipher(key []byte) (cipher.Block, error)
+	// NewDESCipher returns a DES cipher.Block for an 8 byte key.
+	NewDESCipher(key []byte) (cipher.Block, error)
+}
+
+// StdlibCryptoProvider is the CryptoProvider of the Go standard library.
+type StdlibCryptoProvider struct{}
+
+// NewHash returns h.New().
+func (StdlibCryptoProvider) NewHash(h crypto.Hash) hash.Hash {
+	return h.New()
+}
+
+// NewAESCipher returns aes.NewCipher(key).
+func (StdlibCryptoProvider) NewAESCipher(key []byte) (cipher.Block, error) {
+	return aes.NewCipher(key)
+}
+
+// NewDESCipher returns des.NewCipher(key).
+func (StdlibCryptoProvider) NewDESCipher(key []byte) (cipher.Block, error) {
+	return des.NewCipher(key) //nolint:gosec
+}
+
+// cryptoProvider returns the CryptoProvider, StdlibCryptoProvider if none is set.
+func (sp *UsmSecurityParameters) cryptoProvider() CryptoProvider {
+	if sp.CryptoProvider == nil {
+		return StdlibCryptoProvider{}
+	}
+	return sp.CryptoProvider
+}
+
+// StrongKeyPolicy is a KeyPolicy rejecting privacy protocols whose key is
+// longer than the authentication protocol's digest, eg AES256 with MD5. Such
+// keys are extended from the digest, so have no more entropy than it.
+func StrongKeyPolicy(authProtocol SnmpV3AuthProtocol, privProtocol SnmpV3PrivProtocol) error {
+	var keylen int
+	switch privProtocol {
+	case AES192, AES192C:
+		keylen = 24
+	case AES256, AES256C, AES256GCM:
+		keylen = 32
+	default:
+		return nil
+	}
+	if digestlen := authProtocol.HashType().Size(); digestlen < keylen {
+		return fmt.Errorf("%w: %v key of %d bytes derived from %v digest of %d bytes",
+			ErrWeakKeyDerivation, privProtocol, keylen, authProtocol, digestlen)
+	}
+	return nil
+}
+
 // Description logs authentication paramater information to the provided GoSNMP Logger
 func (sp *UsmSecurityParameters) Description() string {
 	var sb strings.Builder
@@ -199,6 +295,8 @@ func (sp *UsmSecurityParameters) Description() string {
 		sb.WriteString(",priv=AES192C")
 	case AES256C:
 		sb.WriteString(",priv=AES256C")
+	case AES256GCM:
+		sb.WriteString(",priv=AES256GCM")
 	}
 	sb.WriteString(",privPass=")
 	sb.WriteString(sp.PrivacyPassphrase)
@@ -213,6 +311,22 @@ func (sp *UsmSecurityParameters) Log() {
 	sp.Logger.Printf("SECURITY PARAMETERS:%+v", sp)
 }
 
+// SafeString returns a description of the security parameters that omits the
+// passphrases and keys, so is safe to log.
+func (sp *UsmSecurityParameters) SafeString() string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return fmt.Sprintf("user=%s,engine=(%s),boots=%d,time=%d,auth=%v,priv=%v",
+		sp.UserName, hex.EncodeToString([]byte(sp.AuthoritativeEngineID)),
+		sp.AuthoritativeEngineBoots, sp.AuthoritativeEngineTime,
+		sp.AuthenticationProtocol, sp.PrivacyProtocol)
+}
+
+// LogSecurity logs the SafeString of the security parameters at level
+func (sp *UsmSecurityParameters) LogSecurity(level LogLevel) {
+	sp.Logger.Logf(level, "SECURITY PARAMETERS:%s", sp.SafeString())
+}
+
 // Copy method for UsmSecurityParameters used to copy a SnmpV3SecurityParameters without knowing it's implementation
 func (sp *UsmSecurityParameters) Copy() SnmpV3SecurityParameters {
 	sp.mu.Lock()
@@ -223,14 +337,20 @@ func (sp *UsmSecurityParameters) Copy() SnmpV3SecurityParameters {
 		UserName:                 sp.UserName,
 		AuthenticationParameters: sp.AuthenticationParameters,
 		PrivacyParameters:        sp.PrivacyParameters,
+		PrivacySaltLength:        sp.PrivacySaltLength,
 		AuthenticationProtocol:   sp.AuthenticationProtocol,
 		PrivacyProtocol:          sp.PrivacyProtocol,
 		AuthenticationPassphrase: sp.AuthenticationPassphrase,
 		PrivacyPassphrase:        sp.PrivacyPassphrase,
 		SecretKey:                sp.SecretKey,
 		PrivacyKey:               sp.PrivacyKey,
+		KeyPolicy:                sp.KeyPolicy,
+		CryptoProvider:           sp.CryptoProvider,
+		SaltSource:               sp.SaltSource,
+		OnEngineIDChange:         sp.OnEngineIDChange,
 		localDESSalt:             sp.localDESSalt,
 		localAESSalt:             sp.localAESSalt,
+		localAESSaltPrefix:       sp.localAESSaltPrefix,
 		Logger:                   sp.Logger,
 	}
 }
@@ -248,8 +368,14 @@ func (sp *UsmSecurityParameters) initSecurityKeys() error {
 func (sp *UsmSecurityParameters) initSecurityKeysNoLock() error {
 	var err error
 
+	if sp.KeyPolicy != nil && sp.PrivacyProtocol > NoPriv && len(sp.PrivacyKey) == 0 {
+		if err = sp.KeyPolicy(sp.AuthenticationProtocol, sp.PrivacyProtocol); err != nil {
+			return err
+		}
+	}
+
 	if sp.AuthenticationProtocol > NoAuth && len(sp.SecretKey) == 0 {
-		sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
+		sp.SecretKey, err = genlocalkey(sp.cryptoProvider(), sp.AuthenticationProtocol,
 			sp.AuthenticationPassphrase,
 			sp.AuthoritativeEngineID)
 		if err != nil {
@@ -257,28 +383,120 @@ func (sp *UsmSecurityParameters) initSecurityKeysNoLock() error {
 		}
 	}
 	if sp.PrivacyProtocol > NoPriv && len(sp.PrivacyKey) == 0 {
-		switch sp.PrivacyProtocol {
-		// Changed: The Output of SHA1 is a 20 octets array, therefore for AES128 (16 octets) either key extension algorithm can be used.
-		case AES, AES192, AES256, AES192C, AES256C:
-			// Use abstract AES key localization algorithms.
-			sp.PrivacyKey, err = genlocalPrivKey(sp.PrivacyProtocol, sp.AuthenticationProtocol,
-				sp.PrivacyPassphrase,
-				sp.AuthoritativeEngineID)
-			if err != nil {
-				return err
-			}
-		default:
-			sp.PrivacyKey, err = genlocalkey(sp.AuthenticationProtocol,
-				sp.PrivacyPassphrase,
-				sp.AuthoritativeEngineID)
-			if err != nil {
-				return err
-			}
+		sp.PrivacyKey, err = localizePrivKey(sp.cryptoProvider(), sp.PrivacyProtocol, sp.AuthenticationProtocol,
+			sp.PrivacyPassphrase,
+			sp.AuthoritativeEngineID)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// localizePrivKey localizes the privacy key of privProtocol.
+func localizePrivKey(cp CryptoProvider, privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, passphrase string, engineID string) ([]byte, error) {
+	switch privProtocol {
+	// Changed: The Output of SHA1 is a 20 octets array, therefore for AES128 (16 octets) either key extension algorithm can be used.
+	case AES, AES192, AES256, AES192C, AES256C, AES256GCM:
+		// Use abstract AES key localization algorithms.
+		return genlocalPrivKey(cp, privProtocol, authProtocol, passphrase, engineID)
+	default:
+		return genlocalkey(cp, authProtocol, passphrase, engineID)
+	}
+}
+
+// LocalizeKey returns the authentication key localized from passphrase for
+// the authoritative engineID (RFC 3414 section 2.6), as Connect computes it
+// for UsmSecurityParameters. Keys can so be computed and cached ahead of
+// time, then given as SecretKey. The hash is that of StdlibCryptoProvider,
+// see LocalizeKeyWithProvider for another CryptoProvider.
+func LocalizeKey(authProtocol SnmpV3AuthProtocol, passphrase, engineID string) ([]byte, error) {
+	return LocalizeKeyWithProvider(StdlibCryptoProvider{}, authProtocol, passphrase, engineID)
+}
+
+// LocalizeKeyWithProvider is LocalizeKey with the hash constructed by cp, eg
+// the CryptoProvider of the UsmSecurityParameters the key is for. A nil cp
+// means StdlibCryptoProvider.
+func LocalizeKeyWithProvider(cp CryptoProvider, authProtocol SnmpV3AuthProtocol, passphrase, engineID string) ([]byte, error) {
+	if cp == nil {
+		cp = StdlibCryptoProvider{}
+	}
+	if authProtocol <= NoAuth {
+		return nil, fmt.Errorf("no key to localize for authentication protocol %v", authProtocol)
+	}
+	if passphrase == "" {
+		return nil, errors.New("the passphrase to localize a key from is empty")
+	}
+	return genlocalkey(cp, authProtocol, passphrase, engineID)
+}
+
+// LocalizePrivKey returns the privacy key of privProtocol localized from
+// passphrase for the authoritative engineID, as Connect computes it for
+// UsmSecurityParameters, including the key extension of the AES192 and
+// AES256 variants. Keys can so be computed and cached ahead of time, then
+// given as PrivacyKey. The hash is that of StdlibCryptoProvider, see
+// LocalizePrivKeyWithProvider for another CryptoProvider.
+func LocalizePrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, passphrase, engineID string) ([]byte, error) {
+	return LocalizePrivKeyWithProvider(StdlibCryptoProvider{}, privProtocol, authProtocol, passphrase, engineID)
+}
+
+// LocalizePrivKeyWithProvider is LocalizePrivKey with the hash constructed by
+// cp. A nil cp means StdlibCryptoProvider.
+func LocalizePrivKeyWithProvider(cp CryptoProvider, privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol,
+	passphrase, engineID string) ([]byte, error) {
+	if cp == nil {
+		cp = StdlibCryptoProvider{}
+	}
+	if privProtocol <= NoPriv {
+		return nil, fmt.Errorf("no key to localize for privacy protocol %v", privProtocol)
+	}
+	if authProtocol <= NoAuth {
+		return nil, fmt.Errorf("privacy protocol %v requires an authentication protocol", privProtocol)
+	}
+	if passphrase == "" {
+		return nil, errors.New("the passphrase to localize a key from is empty")
+	}
+	return localizePrivKey(cp, privProtocol, authProtocol, passphrase, engineID)
+}
+
+// relocalizeKeys discards SecretKey and PrivacyKey and localizes them again
+// from the passphrases, for the authoritative engine of in.
+func (sp *UsmSecurityParameters) relocalizeKeys(in *UsmSecurityParameters) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.AuthenticationProtocol > NoAuth && sp.AuthenticationPassphrase == "" {
+		return fmt.Errorf("keys cannot be re-localized without an AuthenticationPassphrase")
+	}
+	if sp.PrivacyProtocol > NoPriv && sp.PrivacyPassphrase == "" {
+		return fmt.Errorf("keys cannot be re-localized without a PrivacyPassphrase")
+	}
+
+	sp.engineIDChanged(sp.AuthoritativeEngineID, in.AuthoritativeEngineID)
+	sp.AuthoritativeEngineID = in.AuthoritativeEngineID
+	sp.AuthoritativeEngineBoots = in.AuthoritativeEngineBoots
+	sp.AuthoritativeEngineTime = in.AuthoritativeEngineTime
+	sp.SecretKey = nil
+	sp.PrivacyKey = nil
+
+	return sp.initSecurityKeysNoLock()
+}
+
+// engineIDChanged calls OnEngineIDChange, if set, for a change of the
+// authoritative engine ID from oldID to newID.
+func (sp *UsmSecurityParameters) engineIDChanged(oldID, newID string) {
+	if sp.OnEngineIDChange != nil && oldID != "" && oldID != newID {
+		go sp.OnEngineIDChange(oldID, newID)
+	}
+}
+
+// canLocalizeKeys reports whether the keys the protocols need can be
+// localized from passphrases, rather than only given as localized keys.
+func (sp *UsmSecurityParameters) canLocalizeKeys() bool {
+	return (sp.AuthenticationProtocol <= NoAuth || sp.AuthenticationPassphrase != "") &&
+		(sp.PrivacyProtocol <= NoPriv || sp.PrivacyPassphrase != "")
+}
+
 func (sp *UsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParameters) error {
 	var insp *UsmSecurityParameters
 	var err error
@@ -291,6 +509,11 @@ func (sp *UsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParamete
 	}
 
 	if sp.AuthoritativeEngineID != insp.AuthoritativeEngineID {
+		if sp.AuthoritativeEngineID != "" && !sp.canLocalizeKeys() {
+			return fmt.Errorf("keys localized for engine %x cannot be used with engine %x",
+				sp.AuthoritativeEngineID, insp.AuthoritativeEngineID)
+		}
+		sp.engineIDChanged(sp.AuthoritativeEngineID, insp.AuthoritativeEngineID)
 		sp.AuthoritativeEngineID = insp.AuthoritativeEngineID
 		sp.SecretKey = nil
 		sp.PrivacyKey = nil
@@ -300,6 +523,18 @@ func (sp *UsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParamete
 			return err
 		}
 	}
+	if sp.AuthoritativeEngineBoots != 0 && sp.AuthoritativeEngineBoots != insp.AuthoritativeEngineBoots &&
+		sp.PrivacyProtocol == DES {
+		// The DES salt is boots|counter, so a new boots value starts a fresh
+		// sequence of salts, from a new random seed so that it doesn't
+		// repeat that of another session. The seed set by init is kept on
+		// discovery, when boots was not known yet.
+		salt := make([]byte, 4)
+		if err = sp.readSalt(salt); err != nil {
+			return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
+		}
+		atomic.StoreUint32(&sp.localDESSalt, binary.BigEndian.Uint32(salt))
+	}
 	sp.AuthoritativeEngineBoots = insp.AuthoritativeEngineBoots
 	sp.AuthoritativeEngineTime = insp.AuthoritativeEngineTime
 
@@ -328,6 +563,12 @@ func (sp *UsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
 		return fmt.Errorf("validate: MsgFlags must be populated with an appropriate security level")
 	}
 
+	switch sp.PrivacySaltLength {
+	case 0, 8, aes.BlockSize:
+	default:
+		return fmt.Errorf("securityParameters.PrivacySaltLength must be 8 or %d", aes.BlockSize)
+	}
+
 	if sp.PrivacyProtocol > NoPriv && len(sp.PrivacyKey) == 0 {
 		if sp.PrivacyPassphrase == "" {
 			return fmt.Errorf("securityParameters.PrivacyPassphrase is required when a privacy protocol is specified")
@@ -340,6 +581,26 @@ func (sp *UsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
 		}
 	}
 
+	if !sp.canLocalizeKeys() && sp.AuthoritativeEngineID == "" {
+		return fmt.Errorf("securityParameters.AuthoritativeEngineID is required with keys given without their passphrases")
+	}
+
+	return nil
+}
+
+// readSalt fills salt from SaltSource, or crypto/rand if unset.
+func (sp *UsmSecurityParameters) readSalt(salt []byte) error {
+	read := sp.SaltSource
+	if read == nil {
+		read = crand.Read
+	}
+	n, err := read(salt)
+	if err != nil {
+		return err
+	}
+	if n < len(salt) {
+		return fmt.Errorf("short read of %d bytes out of %d", n, len(salt))
+	}
 	return nil
 }
 
@@ -349,22 +610,44 @@ func (sp *UsmSecurityParameters) init(log Logger) error {
 	sp.Logger = log
 
 	switch sp.PrivacyProtocol {
-	case AES, AES192, AES256, AES192C, AES256C:
+	case AES, AES192, AES256, AES192C, AES256C, AES256GCM:
 		salt := make([]byte, 8)
-		_, err = crand.Read(salt)
+		err = sp.readSalt(salt)
 		if err != nil {
 			return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
 		}
 		sp.localAESSalt = binary.BigEndian.Uint64(salt)
+		if sp.PrivacySaltLength > len(salt) {
+			sp.localAESSaltPrefix = make([]byte, sp.PrivacySaltLength-len(salt))
+			if err = sp.readSalt(sp.localAESSaltPrefix); err != nil {
+				return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
+			}
+		}
 	case DES:
 		salt := make([]byte, 4)
-		_, err = crand.Read(salt)
+		err = sp.readSalt(salt)
 		if err != nil {
 			return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
 		}
 		sp.localDESSalt = binary.BigEndian.Uint32(salt)
 	}
 
+	// Localizing the keys for an engine is cheap once the passphrases are
+	// hashed, so hash them now rather than when the engine is discovered or
+	// changes, while a response is being parsed.
+	if sp.AuthoritativeEngineID == "" && sp.AuthenticationProtocol > NoAuth {
+		for _, passphrase := range []string{sp.AuthenticationPassphrase, sp.PrivacyPassphrase} {
+			if passphrase == "" {
+				continue
+			}
+			_, err = cachedPasswordToKey(sp.cryptoProvider().NewHash(sp.AuthenticationProtocol.HashType()),
+				cacheKey(sp.AuthenticationProtocol, passphrase), passphrase)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -376,10 +659,95 @@ func castUsmSecParams(secParams SnmpV3SecurityParameters) (*UsmSecurityParameter
 	return s, nil
 }
 
-var (
-	passwordKeyHashCache = make(map[string][]byte) //nolint:gochecknoglobals
-	passwordKeyHashMutex sync.RWMutex              //nolint:gochecknoglobals
-)
+// passwordKeyCache caches the passwordToKey digests of passphrases, the
+// costly part of key localization. Once it holds more than size entries the
+// least recently used ones are evicted.
+type passwordKeyCache struct {
+	sync.Mutex
+	size      int
+	entries   map[string]*list.Element // of *passwordKeyCacheEntry
+	lru       *list.List               // most recently used first
+	bytes     int
+	evictions uint64
+}
+
+type passwordKeyCacheEntry struct {
+	key    string
+	hashed []byte
+}
+
+//nolint:gochecknoglobals
+var passwordKeyHashCache = &passwordKeyCache{
+	entries: make(map[string]*list.Element),
+	lru:     list.New(),
+}
+
+func (c *passwordKeyCache) get(key string) []byte {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*passwordKeyCacheEntry).hashed
+}
+
+func (c *passwordKeyCache) put(key string, hashed []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&passwordKeyCacheEntry{key: key, hashed: hashed})
+	c.bytes += len(key) + len(hashed)
+	c.evict()
+}
+
+// evict removes the least recently used entries beyond size.
+func (c *passwordKeyCache) evict() {
+	for c.size > 0 && c.lru.Len() > c.size {
+		entry := c.lru.Remove(c.lru.Back()).(*passwordKeyCacheEntry)
+		delete(c.entries, entry.key)
+		c.bytes -= len(entry.key) + len(entry.hashed)
+		c.evictions++
+	}
+}
+
+// SetPasswordKeyCacheSize bounds the number of passphrase digests cached
+// for key localization, shared by all connections, evicting the least
+// recently used ones beyond size. Zero, the default, leaves the cache
+// unbounded.
+func SetPasswordKeyCacheSize(size int) {
+	passwordKeyHashCache.Lock()
+	defer passwordKeyHashCache.Unlock()
+	passwordKeyHashCache.size = size
+	passwordKeyHashCache.evict()
+}
+
+// PasswordKeyCacheStats are statistics of the passphrase digest cache, to
+// tune SetPasswordKeyCacheSize.
+type PasswordKeyCacheStats struct {
+	// Entries is the number of digests cached.
+	Entries int
+	// Bytes approximates the memory held, as the size of the cached digests
+	// and of their keys, which hold the passphrases.
+	Bytes int
+	// Evictions is the number of digests evicted since the program started.
+	Evictions uint64
+}
+
+// PasswordKeyCacheStatistics returns the current PasswordKeyCacheStats.
+func PasswordKeyCacheStatistics() PasswordKeyCacheStats {
+	passwordKeyHashCache.Lock()
+	defer passwordKeyHashCache.Unlock()
+	return PasswordKeyCacheStats{
+		Entries:   passwordKeyHashCache.lru.Len(),
+		Bytes:     passwordKeyHashCache.bytes,
+		Evictions: passwordKeyHashCache.evictions,
+	}
+}
 
 func hashPassword(hash hash.Hash, password string) ([]byte, error) {
 	if len(password) == 0 {
@@ -402,10 +770,7 @@ func hashPassword(hash hash.Hash, password string) ([]byte, error) {
 
 // Common passwordToKey algorithm, "caches" the result to avoid extra computation each reuse
 func cachedPasswordToKey(hash hash.Hash, cacheKey string, password string) ([]byte, error) {
-	passwordKeyHashMutex.RLock()
-	value := passwordKeyHashCache[cacheKey]
-	passwordKeyHashMutex.RUnlock()
-
+	value := passwordKeyHashCache.get(cacheKey)
 	if value != nil {
 		return value, nil
 	}
@@ -415,20 +780,18 @@ func cachedPasswordToKey(hash hash.Hash, cacheKey string, password string) ([]by
 		return nil, err
 	}
 
-	passwordKeyHashMutex.Lock()
-	passwordKeyHashCache[cacheKey] = hashed
-	passwordKeyHashMutex.Unlock()
+	passwordKeyHashCache.put(cacheKey, hashed)
 
 	return hashed, nil
 }
 
-func hMAC(hash crypto.Hash, cacheKey string, password string, engineID string) ([]byte, error) {
-	hashed, err := cachedPasswordToKey(hash.New(), cacheKey, password)
+func hMAC(cp CryptoProvider, hash crypto.Hash, cacheKey string, password string, engineID string) ([]byte, error) {
+	hashed, err := cachedPasswordToKey(cp.NewHash(hash), cacheKey, password)
 	if err != nil {
 		return []byte{}, nil
 	}
 
-	local := hash.New()
+	local := cp.NewHash(hash)
 	_, err = local.Write(hashed)
 	if err != nil {
 		return []byte{}, err
@@ -460,17 +823,17 @@ func cacheKey(authProtocol SnmpV3AuthProtocol, passphrase string) string {
 // Many vendors, including Cisco, use the 3DES key extension algorithm to extend the privacy keys that are too short when using AES,AES192 and AES256.
 // Previously implemented in net-snmp and pysnmp libraries.
 // Tested for AES128 and AES256
-func extendKeyReeder(authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+func extendKeyReeder(cp CryptoProvider, authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
 	var key []byte
 	var err error
 
-	key, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, password), password, engineID)
+	key, err = hMAC(cp, authProtocol.HashType(), cacheKey(authProtocol, password), password, engineID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	newkey, err := hMAC(authProtocol.HashType(), cacheKey(authProtocol, string(key)), string(key), engineID)
+	newkey, err := hMAC(cp, authProtocol.HashType(), cacheKey(authProtocol, string(key)), string(key), engineID)
 
 	return append(key, newkey...), err
 }
@@ -479,24 +842,23 @@ func extendKeyReeder(authProtocol SnmpV3AuthProtocol, password string, engineID
 // https://tools.ietf.org/html/draft-blumenthal-aes-usm-04#page-7
 // Not many vendors use this algorithm.
 // Previously implemented in the net-snmp and pysnmp libraries.
-// Not tested
-func extendKeyBlumenthal(authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+func extendKeyBlumenthal(cp CryptoProvider, authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
 	var key []byte
 	var err error
 
-	key, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, ""), password, engineID)
+	key, err = hMAC(cp, authProtocol.HashType(), cacheKey(authProtocol, password), password, engineID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	newkey := authProtocol.HashType().New()
+	newkey := cp.NewHash(authProtocol.HashType())
 	_, _ = newkey.Write(key)
 	return append(key, newkey.Sum(nil)...), err
 }
 
 // Changed: New function to calculate the Privacy Key for abstract AES
-func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+func genlocalPrivKey(cp CryptoProvider, privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
 	var keylen int
 	var localPrivKey []byte
 	var err error
@@ -506,19 +868,19 @@ func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthPro
 		keylen = 16
 	case AES192, AES192C:
 		keylen = 24
-	case AES256, AES256C:
+	case AES256, AES256C, AES256GCM:
 		keylen = 32
 	}
 
 	switch privProtocol {
 	case AES, AES192C, AES256C:
-		localPrivKey, err = extendKeyReeder(authProtocol, password, engineID)
+		localPrivKey, err = extendKeyReeder(cp, authProtocol, password, engineID)
 
-	case AES192, AES256:
-		localPrivKey, err = extendKeyBlumenthal(authProtocol, password, engineID)
+	case AES192, AES256, AES256GCM:
+		localPrivKey, err = extendKeyBlumenthal(cp, authProtocol, password, engineID)
 
 	default:
-		localPrivKey, err = genlocalkey(authProtocol, password, engineID)
+		localPrivKey, err = genlocalkey(cp, authProtocol, password, engineID)
 	}
 
 	if err != nil {
@@ -533,11 +895,11 @@ func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthPro
 	return localPrivKey[:keylen], nil
 }
 
-func genlocalkey(authProtocol SnmpV3AuthProtocol, passphrase string, engineID string) ([]byte, error) {
+func genlocalkey(cp CryptoProvider, authProtocol SnmpV3AuthProtocol, passphrase string, engineID string) ([]byte, error) {
 	var secretKey []byte
 	var err error
 
-	secretKey, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, passphrase), passphrase, engineID)
+	secretKey, err = hMAC(cp, authProtocol.HashType(), cacheKey(authProtocol, passphrase), passphrase, engineID)
 
 	if err != nil {
 		return []byte{}, err
@@ -554,7 +916,7 @@ func (sp *UsmSecurityParameters) usmAllocateNewSalt() interface{} {
 	var newSalt interface{}
 
 	switch sp.PrivacyProtocol {
-	case AES, AES192, AES256, AES192C, AES256C:
+	case AES, AES192, AES256, AES192C, AES256C, AES256GCM:
 		newSalt = atomic.AddUint64(&(sp.localAESSalt), 1)
 	default:
 		newSalt = atomic.AddUint32(&(sp.localDESSalt), 1)
@@ -566,13 +928,14 @@ func (sp *UsmSecurityParameters) usmSetSalt(newSalt interface{}) error {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
 	switch sp.PrivacyProtocol {
-	case AES, AES192, AES256, AES192C, AES256C:
+	case AES, AES192, AES256, AES192C, AES256C, AES256GCM:
 		aesSalt, ok := newSalt.(uint64)
 		if !ok {
 			return fmt.Errorf("salt provided to usmSetSalt is not the correct type for the AES privacy protocol")
 		}
-		var salt = make([]byte, 8)
-		binary.BigEndian.PutUint64(salt, aesSalt)
+		var salt = make([]byte, 8+len(sp.localAESSaltPrefix))
+		copy(salt, sp.localAESSaltPrefix)
+		binary.BigEndian.PutUint64(salt[len(sp.localAESSaltPrefix):], aesSalt)
 		sp.PrivacyParameters = salt
 	default:
 		desSalt, ok := newSalt.(uint32)
@@ -635,11 +998,13 @@ func calcPacketDigest(packetBytes []byte, secParams *UsmSecurityParameters) ([]b
 	switch secParams.AuthenticationProtocol {
 	case MD5, SHA:
 		digest, err = digestRFC3414(
+			secParams.cryptoProvider(),
 			secParams.AuthenticationProtocol,
 			packetBytes,
 			secParams.SecretKey)
 	case SHA224, SHA256, SHA384, SHA512:
 		digest, err = digestRFC7860(
+			secParams.cryptoProvider(),
 			secParams.AuthenticationProtocol,
 			packetBytes,
 			secParams.SecretKey)
@@ -650,8 +1015,8 @@ func calcPacketDigest(packetBytes []byte, secParams *UsmSecurityParameters) ([]b
 
 // digestRFC7860 calculate digest for incoming messages using HMAC-SHA2 protcols
 // according to RFC7860 4.2.2
-func digestRFC7860(h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte, error) {
-	mac := hmac.New(h.HashType().New, authKey)
+func digestRFC7860(cp CryptoProvider, h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte, error) {
+	mac := hmac.New(func() hash.Hash { return cp.NewHash(h.HashType()) }, authKey)
 	_, err := mac.Write(packet)
 	if err != nil {
 		return []byte{}, err
@@ -662,7 +1027,7 @@ func digestRFC7860(h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte,
 
 // digestRFC3414 calculate digest for incoming messages using MD5 or SHA1
 // according to RFC3414 6.3.2 and 7.3.2
-func digestRFC3414(h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte, error) {
+func digestRFC3414(cp CryptoProvider, h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte, error) {
 	var extkey [64]byte
 	var err error
 	var k1, k2 [64]byte
@@ -671,12 +1036,9 @@ func digestRFC3414(h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte,
 	copy(extkey[:], authKey)
 
 	switch h {
-	case MD5:
-		h1 = md5.New() //nolint:gosec
-		h2 = md5.New() //nolint:gosec
-	case SHA:
-		h1 = sha1.New() //nolint:gosec
-		h2 = sha1.New() //nolint:gosec
+	case MD5, SHA:
+		h1 = cp.NewHash(h.HashType())
+		h2 = cp.NewHash(h.HashType())
 	}
 
 	for i := 0; i < 64; i++ {
@@ -749,17 +1111,55 @@ func (sp *UsmSecurityParameters) isAuthentic(packetBytes []byte, packet *SnmpPac
 	return true, nil
 }
 
+// aesIV returns the IV for the AES privacy protocols: the salt itself if it
+// is a full block, otherwise engine boots, engine time and salt (RFC 3826).
+func (sp *UsmSecurityParameters) aesIV() [aes.BlockSize]byte {
+	var iv [aes.BlockSize]byte
+	if len(sp.PrivacyParameters) == aes.BlockSize {
+		copy(iv[:], sp.PrivacyParameters)
+		return iv
+	}
+	binary.BigEndian.PutUint32(iv[:], sp.AuthoritativeEngineBoots)
+	binary.BigEndian.PutUint32(iv[4:], sp.AuthoritativeEngineTime)
+	copy(iv[8:], sp.PrivacyParameters)
+	return iv
+}
+
+// newGCM returns the AES-GCM AEAD of AES256GCM, whose nonce is the 16 byte
+// IV of the CFB modes: engine boots, engine time and the salt.
+func (sp *UsmSecurityParameters) newGCM() (cipher.AEAD, error) {
+	block, err := sp.cryptoProvider().NewAESCipher(sp.PrivacyKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, aes.BlockSize)
+}
+
 func (sp *UsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error) {
 	var b []byte
 
+	if len(sp.PrivacyKey) == 0 {
+		return nil, fmt.Errorf("%w: securityParameters.PrivacyKey is empty", ErrNotEncrypted)
+	}
+
 	switch sp.PrivacyProtocol {
+	case AES256GCM:
+		iv := sp.aesIV()
+		aead, err := sp.newGCM()
+		if err != nil {
+			return nil, err
+		}
+		ciphertext := aead.Seal(nil, iv[:], scopedPdu, nil)
+		pduLen, err := marshalLength(len(ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		b = append([]byte{byte(OctetString)}, pduLen...)
+		scopedPdu = append(b, ciphertext...) //nolint:gocritic
 	case AES, AES192, AES256, AES192C, AES256C:
-		var iv [16]byte
-		binary.BigEndian.PutUint32(iv[:], sp.AuthoritativeEngineBoots)
-		binary.BigEndian.PutUint32(iv[4:], sp.AuthoritativeEngineTime)
-		copy(iv[8:], sp.PrivacyParameters)
+		iv := sp.aesIV()
 		// aes.NewCipher(sp.PrivacyKey[:16]) changed to aes.NewCipher(sp.PrivacyKey)
-		block, err := aes.NewCipher(sp.PrivacyKey)
+		block, err := sp.cryptoProvider().NewAESCipher(sp.PrivacyKey)
 		if err != nil {
 			return nil, err
 		}
@@ -773,12 +1173,15 @@ func (sp *UsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error)
 		b = append([]byte{byte(OctetString)}, pduLen...)
 		scopedPdu = append(b, ciphertext...) //nolint:gocritic
 	default:
+		if len(sp.PrivacyKey) < 16 {
+			return nil, fmt.Errorf("%w: securityParameters.PrivacyKey of %d bytes is too short for DES", ErrNotEncrypted, len(sp.PrivacyKey))
+		}
 		preiv := sp.PrivacyKey[8:]
 		var iv [8]byte
 		for i := 0; i < len(iv); i++ {
 			iv[i] = preiv[i] ^ sp.PrivacyParameters[i]
 		}
-		block, err := des.NewCipher(sp.PrivacyKey[:8]) //nolint:gosec
+		block, err := sp.cryptoProvider().NewDESCipher(sp.PrivacyKey[:8])
 		if err != nil {
 			return nil, err
 		}
@@ -808,13 +1211,27 @@ func (sp *UsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byt
 	}
 
 	switch sp.PrivacyProtocol {
+	case AES256GCM:
+		iv := sp.aesIV()
+		aead, err := sp.newGCM()
+		if err != nil {
+			return nil, err
+		}
+		// the tag is checked over exactly the ciphertext of the OCTET STRING
+		length, _ := parseLength(packet[cursor:])
+		if length < cursorTmp-cursor || cursor+length > len(packet) {
+			return nil, errors.New("error decrypting ScopedPDU: truncated packet")
+		}
+		plaintext, err := aead.Open(nil, iv[:], packet[cursorTmp:cursor+length], nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the ScopedPDU failed the AES-GCM authentication check", ErrDecryption)
+		}
+		copy(packet[cursor:], plaintext)
+		packet = packet[:cursor+len(plaintext)]
 	case AES, AES192, AES256, AES192C, AES256C:
-		var iv [16]byte
-		binary.BigEndian.PutUint32(iv[:], sp.AuthoritativeEngineBoots)
-		binary.BigEndian.PutUint32(iv[4:], sp.AuthoritativeEngineTime)
-		copy(iv[8:], sp.PrivacyParameters)
+		iv := sp.aesIV()
 
-		block, err := aes.NewCipher(sp.PrivacyKey)
+		block, err := sp.cryptoProvider().NewAESCipher(sp.PrivacyKey)
 		if err != nil {
 			return nil, err
 		}
@@ -832,7 +1249,7 @@ func (sp *UsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byt
 		for i := 0; i < len(iv); i++ {
 			iv[i] = preiv[i] ^ sp.PrivacyParameters[i]
 		}
-		block, err := des.NewCipher(sp.PrivacyKey[:8]) //nolint:gosec
+		block, err := sp.cryptoProvider().NewDESCipher(sp.PrivacyKey[:8])
 		if err != nil {
 			return nil, err
 		}
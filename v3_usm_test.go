@@ -1,10 +1,18 @@
 package gosnmp
 
 import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/sha1"
 	"encoding/hex"
+	"errors"
+	"hash"
 	"io/ioutil"
 	"log"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -70,7 +78,7 @@ func TestAuthenticationSHA224(t *testing.T) {
 		PrivacyKey:               nil,
 	}
 
-	sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
+	sp.SecretKey, err = genlocalkey(StdlibCryptoProvider{}, sp.AuthenticationProtocol,
 		sp.AuthenticationPassphrase,
 		sp.AuthoritativeEngineID)
 
@@ -105,7 +113,7 @@ func TestIsAuthenticaSHA224(t *testing.T) {
 		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
 	}
 
-	sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
+	sp.SecretKey, err = genlocalkey(StdlibCryptoProvider{}, sp.AuthenticationProtocol,
 		sp.AuthenticationPassphrase,
 		sp.AuthoritativeEngineID)
 
@@ -172,7 +180,7 @@ func TestAuthenticationSHA512(t *testing.T) {
 		Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
 	}
 
-	sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
+	sp.SecretKey, err = genlocalkey(StdlibCryptoProvider{}, sp.AuthenticationProtocol,
 		sp.AuthenticationPassphrase,
 		sp.AuthoritativeEngineID)
 
@@ -207,7 +215,7 @@ func TestIsAuthenticaSHA512(t *testing.T) {
 		PrivacyKey:               nil,
 	}
 
-	sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
+	sp.SecretKey, err = genlocalkey(StdlibCryptoProvider{}, sp.AuthenticationProtocol,
 		sp.AuthenticationPassphrase,
 		sp.AuthoritativeEngineID)
 
@@ -224,3 +232,562 @@ func TestIsAuthenticaSHA512(t *testing.T) {
 	require.NoError(t, err, "Authentication check of key failed")
 	require.True(t, authentic, "Packet was not considered to be authentic")
 }
+
+func TestKeyPolicy(t *testing.T) {
+	sp := UsmSecurityParameters{
+		AuthoritativeEngineID:    authorativeEngineID(t),
+		AuthenticationProtocol:   MD5,
+		AuthenticationPassphrase: "authkey1",
+		PrivacyProtocol:          AES256,
+		PrivacyPassphrase:        "privkey1",
+		KeyPolicy:                StrongKeyPolicy,
+	}
+	err := sp.initSecurityKeys()
+	require.True(t, errors.Is(err, ErrWeakKeyDerivation), "MD5 with AES256 should be rejected, got %v", err)
+	require.Empty(t, sp.PrivacyKey)
+
+	sp.AuthenticationProtocol = SHA512
+	require.NoError(t, sp.initSecurityKeys())
+	require.Len(t, sp.PrivacyKey, 32)
+
+	veto := errors.New("DES not allowed")
+	sp = UsmSecurityParameters{
+		AuthoritativeEngineID:    authorativeEngineID(t),
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authkey1",
+		PrivacyProtocol:          DES,
+		PrivacyPassphrase:        "privkey1",
+		KeyPolicy: func(authProtocol SnmpV3AuthProtocol, privProtocol SnmpV3PrivProtocol) error {
+			if privProtocol == DES {
+				return veto
+			}
+			return nil
+		},
+	}
+	require.Equal(t, veto, sp.initSecurityKeys())
+	require.Equal(t, veto, sp.Copy().initSecurityKeys())
+}
+
+func TestAESSaltLength(t *testing.T) {
+	for _, saltLength := range []int{0, 16} {
+		sp := &UsmSecurityParameters{
+			AuthoritativeEngineID:    authorativeEngineID(t),
+			AuthoritativeEngineBoots: 4,
+			AuthoritativeEngineTime:  1234,
+			UserName:                 "usr-sha-aes",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authkey1",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privkey1",
+			PrivacySaltLength:        saltLength,
+		}
+		require.NoError(t, sp.validate(AuthPriv))
+		require.NoError(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+		require.NoError(t, sp.initSecurityKeys())
+		require.NoError(t, sp.usmSetSalt(sp.usmAllocateNewSalt()))
+
+		expectedLength := saltLength
+		if expectedLength == 0 {
+			expectedLength = 8
+		}
+		require.Len(t, sp.PrivacyParameters, expectedLength)
+
+		scopedPdu := []byte{0x30, 0x05, 0x04, 0x00, 0x04, 0x01, 0x41}
+		encrypted, err := sp.encryptPacket(append([]byte{}, scopedPdu...))
+		require.NoError(t, err)
+		require.NotEqual(t, scopedPdu, encrypted[2:])
+
+		receiver := sp.Copy().(*UsmSecurityParameters)
+		decrypted, err := receiver.decryptPacket(encrypted, 0)
+		require.NoError(t, err)
+		require.Equal(t, scopedPdu, decrypted, "salt length %d", saltLength)
+	}
+
+	sp := &UsmSecurityParameters{UserName: "usr", PrivacySaltLength: 12}
+	require.Error(t, sp.validate(NoAuthNoPriv))
+}
+
+func TestEncryptFullBlockSalt(t *testing.T) {
+	// NIST SP 800-38A F.3.13 CFB128-AES128.Encrypt, first block: a salt of
+	// a full block is the IV itself, see PrivacySaltLength
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	iv, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	plaintext, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172a")
+	ciphertext, _ := hex.DecodeString("3b3fd92eb72dad20333449f8e83cfb4a")
+
+	sp := &UsmSecurityParameters{
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  2,
+		PrivacyProtocol:          AES,
+		PrivacyKey:               key,
+		PrivacyParameters:        iv,
+	}
+	encrypted, err := sp.encryptPacket(append([]byte(nil), plaintext...))
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{byte(OctetString), 16}, ciphertext...), encrypted)
+
+	// the receiver derives the same IV from msgPrivacyParameters
+	receiver := sp.Copy().(*UsmSecurityParameters)
+	decrypted, err := receiver.decryptPacket(encrypted, 0)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+}
+
+func TestDESSaltBootsChange(t *testing.T) {
+	sp := &UsmSecurityParameters{
+		AuthoritativeEngineID:    authorativeEngineID(t),
+		AuthoritativeEngineBoots: 3,
+		AuthoritativeEngineTime:  1234,
+		UserName:                 "usr-md5-des",
+		AuthenticationProtocol:   MD5,
+		AuthenticationPassphrase: "authkey1",
+		PrivacyProtocol:          DES,
+		PrivacyPassphrase:        "privkey1",
+		localDESSalt:             41,
+	}
+	pkt := &SnmpPacket{MsgFlags: AuthPriv, SecurityParameters: sp}
+
+	require.NoError(t, sp.initPacket(pkt))
+	require.Equal(t, []byte{0, 0, 0, 3, 0, 0, 0, 42}, sp.PrivacyParameters)
+
+	same := sp.Copy().(*UsmSecurityParameters)
+	same.AuthoritativeEngineTime = 1300
+	require.NoError(t, sp.setSecurityParameters(same))
+	require.NoError(t, sp.initPacket(pkt))
+	require.Equal(t, []byte{0, 0, 0, 3, 0, 0, 0, 43}, sp.PrivacyParameters, "counter continues for the same boots")
+
+	seed := []byte{0x12, 0x34, 0x56, 0x78}
+	sp.SaltSource = func(b []byte) (int, error) { return copy(b, seed), nil }
+	rebooted := sp.Copy().(*UsmSecurityParameters)
+	rebooted.AuthoritativeEngineBoots = 4
+	rebooted.AuthoritativeEngineTime = 5
+	require.NoError(t, sp.setSecurityParameters(rebooted))
+	require.NoError(t, sp.initPacket(pkt))
+	require.Equal(t, []byte{0, 0, 0, 4}, sp.PrivacyParameters[:4], "boots half of the salt follows the new boots")
+	require.Equal(t, []byte{0x12, 0x34, 0x56, 0x79}, sp.PrivacyParameters[4:], "counter is reseeded for the new boots")
+
+	// on discovery the random seed set by init is kept
+	discovering := &UsmSecurityParameters{
+		UserName:                 "usr-md5-des",
+		AuthenticationProtocol:   MD5,
+		AuthenticationPassphrase: "authkey1",
+		PrivacyProtocol:          DES,
+		PrivacyPassphrase:        "privkey1",
+		localDESSalt:             41,
+	}
+	discovered := sp.Copy().(*UsmSecurityParameters)
+	require.NoError(t, discovering.setSecurityParameters(discovered))
+	require.Equal(t, uint32(41), discovering.localDESSalt)
+}
+
+func TestDecryptPacketLengthClaims(t *testing.T) {
+	x := &GoSNMP{
+		Version: Version3,
+		Logger:  NewLogger(log.New(ioutil.Discard, "", 0)),
+	}
+	ciphertext := make([]byte, 32)
+	for name, header := range map[string][]byte{
+		"absurd":    {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"overflow":  {0x04, 0x89, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"oversize":  {0x04, 0x83, 0x01, 0x00, 0x00},
+		"truncated": {0x04, 0x82, 0x10, 0x00},
+	} {
+		packet := append(append([]byte{0x30, 0x00}, header...), ciphertext...)
+		response := &SnmpPacket{SecurityParameters: &UsmSecurityParameters{
+			PrivacyProtocol:   AES,
+			PrivacyKey:        make([]byte, 16),
+			PrivacyParameters: make([]byte, 8),
+		}}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := x.decryptPacket(packet, 2, response)
+		runtime.ReadMemStats(&after)
+
+		require.Error(t, err, name)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20), name)
+	}
+}
+
+// recordingCryptoProvider is a StdlibCryptoProvider recording the primitives
+// requested from it.
+type recordingCryptoProvider struct {
+	StdlibCryptoProvider
+	mu      sync.Mutex
+	hashes  map[crypto.Hash]int
+	ciphers map[string]int
+}
+
+func newRecordingCryptoProvider() *recordingCryptoProvider {
+	return &recordingCryptoProvider{hashes: make(map[crypto.Hash]int), ciphers: make(map[string]int)}
+}
+
+func (p *recordingCryptoProvider) NewHash(h crypto.Hash) hash.Hash {
+	p.mu.Lock()
+	p.hashes[h]++
+	p.mu.Unlock()
+	return p.StdlibCryptoProvider.NewHash(h)
+}
+
+func (p *recordingCryptoProvider) NewAESCipher(key []byte) (cipher.Block, error) {
+	p.mu.Lock()
+	p.ciphers["AES"]++
+	p.mu.Unlock()
+	return p.StdlibCryptoProvider.NewAESCipher(key)
+}
+
+func (p *recordingCryptoProvider) NewDESCipher(key []byte) (cipher.Block, error) {
+	p.mu.Lock()
+	p.ciphers["DES"]++
+	p.mu.Unlock()
+	return p.StdlibCryptoProvider.NewDESCipher(key)
+}
+
+func TestCryptoProvider(t *testing.T) {
+	tests := []struct {
+		auth   SnmpV3AuthProtocol
+		priv   SnmpV3PrivProtocol
+		hash   crypto.Hash
+		cipher string
+	}{
+		{MD5, DES, crypto.MD5, "DES"},
+		{SHA, AES, crypto.SHA1, "AES"},
+		{SHA256, AES256, crypto.SHA256, "AES"},
+		{SHA512, AES256C, crypto.SHA512, "AES"},
+	}
+	for _, test := range tests {
+		provider := newRecordingCryptoProvider()
+		newSp := func(cp CryptoProvider) *UsmSecurityParameters {
+			return &UsmSecurityParameters{
+				AuthoritativeEngineID:    authorativeEngineID(t),
+				AuthoritativeEngineBoots: 4,
+				AuthoritativeEngineTime:  1234,
+				UserName:                 "usr",
+				AuthenticationProtocol:   test.auth,
+				AuthenticationPassphrase: "authkey1",
+				PrivacyProtocol:          test.priv,
+				PrivacyPassphrase:        "privkey1",
+				CryptoProvider:           cp,
+				Logger:                   NewLogger(log.New(ioutil.Discard, "", 0)),
+			}
+		}
+		sp, stdSp := newSp(provider), newSp(nil)
+		require.NoError(t, sp.initSecurityKeys())
+		require.NoError(t, stdSp.initSecurityKeys())
+		require.Equal(t, stdSp.SecretKey, sp.SecretKey, "%v", test.auth)
+		require.Equal(t, stdSp.PrivacyKey, sp.PrivacyKey, "%v", test.priv)
+
+		packet := []byte("some packet")
+		digest, err := sp.calcPacketDigest(packet)
+		require.NoError(t, err)
+		stdDigest, err := stdSp.calcPacketDigest(packet)
+		require.NoError(t, err)
+		require.Equal(t, stdDigest, digest)
+
+		require.NoError(t, sp.usmSetSalt(sp.usmAllocateNewSalt()))
+		scopedPdu := []byte{0x30, 0x05, 0x04, 0x00, 0x04, 0x01, 0x41}
+		encrypted, err := sp.encryptPacket(append([]byte{}, scopedPdu...))
+		require.NoError(t, err)
+		receiver := sp.Copy().(*UsmSecurityParameters)
+		decrypted, err := receiver.decryptPacket(encrypted, 0)
+		require.NoError(t, err)
+		require.Equal(t, scopedPdu, decrypted[:len(scopedPdu)])
+
+		require.NotZero(t, provider.hashes[test.hash], "%v", test.auth)
+		require.Len(t, provider.hashes, 1, "%v", test.auth)
+		require.Equal(t, map[string]int{test.cipher: 2}, provider.ciphers, "%v", test.priv)
+	}
+}
+
+func TestLocalizeKey(t *testing.T) {
+	// RFC 3414 appendix A.3.1
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	key, err := LocalizeKey(MD5, "maplesyrup", string(engineID))
+	require.NoError(t, err)
+	require.Equal(t, "526f5eed9fcce26f8964c2930787d82b", hex.EncodeToString(key))
+
+	for _, authProtocol := range []SnmpV3AuthProtocol{MD5, SHA, SHA224, SHA256, SHA384, SHA512} {
+		for _, privProtocol := range []SnmpV3PrivProtocol{DES, AES, AES192, AES256, AES192C, AES256C} {
+			sp := &UsmSecurityParameters{
+				AuthenticationProtocol:   authProtocol,
+				AuthenticationPassphrase: "authpassword",
+				PrivacyProtocol:          privProtocol,
+				PrivacyPassphrase:        "privpassword",
+				AuthoritativeEngineID:    "testengine",
+			}
+			require.NoError(t, sp.initSecurityKeys())
+
+			secretKey, err := LocalizeKey(authProtocol, "authpassword", "testengine")
+			require.NoError(t, err)
+			require.Equal(t, sp.SecretKey, secretKey, "%v", authProtocol)
+			privacyKey, err := LocalizePrivKey(privProtocol, authProtocol, "privpassword", "testengine")
+			require.NoError(t, err)
+			require.Equal(t, sp.PrivacyKey, privacyKey, "%v/%v", authProtocol, privProtocol)
+		}
+	}
+
+	_, err = LocalizeKey(NoAuth, "authpassword", "testengine")
+	require.Error(t, err)
+	_, err = LocalizeKey(SHA, "", "testengine")
+	require.Error(t, err)
+	_, err = LocalizePrivKey(NoPriv, SHA, "privpassword", "testengine")
+	require.Error(t, err)
+	_, err = LocalizePrivKey(AES, NoAuth, "privpassword", "testengine")
+	require.Error(t, err)
+
+	provider := newRecordingCryptoProvider()
+	secretKey, err := LocalizeKeyWithProvider(provider, SHA256, "authpassword", "testengine")
+	require.NoError(t, err)
+	stdlibKey, err := LocalizeKey(SHA256, "authpassword", "testengine")
+	require.NoError(t, err)
+	require.Equal(t, stdlibKey, secretKey)
+	privacyKey, err := LocalizePrivKeyWithProvider(provider, AES256C, SHA256, "privpassword", "testengine")
+	require.NoError(t, err)
+	stdlibKey, err = LocalizePrivKey(AES256C, SHA256, "privpassword", "testengine")
+	require.NoError(t, err)
+	require.Equal(t, stdlibKey, privacyKey)
+	require.NotZero(t, provider.hashes[crypto.SHA256])
+	require.Len(t, provider.hashes, 1)
+
+	nilKey, err := LocalizeKeyWithProvider(nil, SHA256, "authpassword", "testengine")
+	require.NoError(t, err)
+	require.Equal(t, secretKey, nilKey)
+}
+
+func TestLocalizePrivKeyBlumenthal(t *testing.T) {
+	// RFC 3414 appendix A.3.2
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	localized, _ := hex.DecodeString("6695febc9288e36282235fc7151f128497b38f3f")
+	extension := sha1.Sum(localized)
+
+	for _, privProtocol := range []SnmpV3PrivProtocol{AES192, AES256} {
+		key, err := LocalizePrivKey(privProtocol, SHA, "maplesyrup", string(engineID))
+		require.NoError(t, err)
+		require.Equal(t, append(localized, extension[:]...)[:len(key)], key, "%v", privProtocol)
+
+		keyA, err := LocalizePrivKey(privProtocol, SHA, "passphraseA", "testengine")
+		require.NoError(t, err)
+		keyB, err := LocalizePrivKey(privProtocol, SHA, "passphraseB", "testengine")
+		require.NoError(t, err)
+		require.NotEqual(t, keyA, keyB, "%v", privProtocol)
+	}
+}
+
+func TestAES256GCM(t *testing.T) {
+	sp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA512,
+		AuthenticationPassphrase: "authpassword",
+		PrivacyProtocol:          AES256GCM,
+		PrivacyPassphrase:        "privpassword",
+		AuthoritativeEngineID:    "testengine",
+		AuthoritativeEngineBoots: 3,
+		AuthoritativeEngineTime:  100,
+	}
+	require.NoError(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+	require.NoError(t, sp.initSecurityKeys())
+	require.Len(t, sp.PrivacyKey, 32)
+	require.NoError(t, sp.usmSetSalt(sp.usmAllocateNewSalt()))
+
+	scopedPdu := []byte{byte(Sequence), 6, byte(OctetString), 0, byte(OctetString), 0, byte(Null), 0}
+	encrypted, err := sp.encryptPacket(append([]byte(nil), scopedPdu...))
+	require.NoError(t, err)
+	require.Equal(t, byte(OctetString), encrypted[0])
+	require.Len(t, encrypted, 2+len(scopedPdu)+16, "the GCM tag follows the ciphertext")
+
+	// the message header before the ScopedPDU is left as is
+	header := []byte{0xa5, 0xa5}
+	packet := append(append([]byte(nil), header...), encrypted...)
+	decrypted, err := sp.decryptPacket(packet, len(header))
+	require.NoError(t, err)
+	require.Equal(t, append(header, scopedPdu...), decrypted)
+
+	for _, tamper := range []int{len(header) + 2, len(header) + len(encrypted) - 1} {
+		packet = append(append([]byte(nil), header...), encrypted...)
+		packet[tamper] ^= 1
+		_, err = sp.decryptPacket(packet, len(header))
+		require.True(t, errors.Is(err, ErrDecryption), "tampered byte %d: %v", tamper, err)
+	}
+
+	// another engine time is another nonce
+	other := sp.Copy().(*UsmSecurityParameters)
+	other.AuthoritativeEngineTime++
+	packet = append(append([]byte(nil), header...), encrypted...)
+	_, err = other.decryptPacket(packet, len(header))
+	require.True(t, errors.Is(err, ErrDecryption))
+}
+
+func TestAES256GCMUsers(t *testing.T) {
+	user := func(name, privPassphrase string) *UsmSecurityParameters {
+		sp := &UsmSecurityParameters{
+			UserName:                 name,
+			AuthenticationProtocol:   SHA512,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES256GCM,
+			PrivacyPassphrase:        privPassphrase,
+			AuthoritativeEngineID:    "testengine",
+			AuthoritativeEngineBoots: 3,
+			AuthoritativeEngineTime:  100,
+		}
+		require.NoError(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+		require.NoError(t, sp.initSecurityKeys())
+		require.NoError(t, sp.usmSetSalt(sp.usmAllocateNewSalt()))
+		return sp
+	}
+	alice, bob := user("alice", "alicepassword"), user("bob", "bobpassword")
+	require.NotEqual(t, alice.PrivacyKey, bob.PrivacyKey)
+
+	scopedPdu := []byte{byte(Sequence), 6, byte(OctetString), 0, byte(OctetString), 0, byte(Null), 0}
+	for _, sender := range []*UsmSecurityParameters{alice, bob} {
+		encrypted, err := sender.encryptPacket(append([]byte(nil), scopedPdu...))
+		require.NoError(t, err)
+
+		// the agent localizes the keys of the user afresh, and takes the
+		// salt from the message
+		receiver := user(sender.UserName, sender.PrivacyPassphrase)
+		receiver.PrivacyParameters = sender.PrivacyParameters
+		decrypted, err := receiver.decryptPacket(append([]byte(nil), encrypted...), 0)
+		require.NoError(t, err, sender.UserName)
+		require.Equal(t, scopedPdu, decrypted, sender.UserName)
+
+		other := alice
+		if sender == alice {
+			other = bob
+		}
+		other = other.Copy().(*UsmSecurityParameters)
+		other.PrivacyParameters = sender.PrivacyParameters
+		_, err = other.decryptPacket(append([]byte(nil), encrypted...), 0)
+		require.True(t, errors.Is(err, ErrDecryption), "%s decrypting for %s: %v", other.UserName, sender.UserName, err)
+	}
+}
+
+func TestPasswordKeyCacheEvictions(t *testing.T) {
+	defer SetPasswordKeyCacheSize(0)
+	SetPasswordKeyCacheSize(2)
+	before := PasswordKeyCacheStatistics()
+	require.LessOrEqual(t, before.Entries, 2)
+
+	for _, passphrase := range []string{"evict-one", "evict-two", "evict-three", "evict-four"} {
+		_, err := LocalizeKey(SHA, passphrase, "testengine")
+		require.NoError(t, err)
+	}
+	stats := PasswordKeyCacheStatistics()
+	require.Equal(t, 2, stats.Entries)
+	require.GreaterOrEqual(t, stats.Evictions, before.Evictions+2)
+	// two SHA1 digests and their keys
+	require.Equal(t, 2*20+len(cacheKey(SHA, "evict-three"))+len(cacheKey(SHA, "evict-four")), stats.Bytes)
+
+	// the most recently used digest is kept
+	_, err := LocalizeKey(SHA, "evict-three", "testengine")
+	require.NoError(t, err)
+	_, err = LocalizeKey(SHA, "evict-five", "testengine")
+	require.NoError(t, err)
+	require.NotNil(t, passwordKeyHashCache.get(cacheKey(SHA, "evict-three")))
+	require.Nil(t, passwordKeyHashCache.get(cacheKey(SHA, "evict-four")))
+
+	SetPasswordKeyCacheSize(1)
+	require.Equal(t, 1, PasswordKeyCacheStatistics().Entries)
+
+	// an evicted digest is computed again from its own passphrase, so the
+	// keys of a passphrase don't change with the use of others
+	for _, privProtocol := range []SnmpV3PrivProtocol{AES, AES192, AES256, AES256GCM} {
+		first, err := LocalizePrivKey(privProtocol, SHA, "evict-priv-one", "testengine")
+		require.NoError(t, err)
+		second, err := LocalizePrivKey(privProtocol, SHA, "evict-priv-two", "testengine")
+		require.NoError(t, err)
+		require.NotEqual(t, first, second, "%v", privProtocol)
+		_, err = LocalizeKey(SHA, "evict-auth", "testengine")
+		require.NoError(t, err)
+		require.Nil(t, passwordKeyHashCache.get(cacheKey(SHA, "evict-priv-one")))
+		again, err := LocalizePrivKey(privProtocol, SHA, "evict-priv-one", "testengine")
+		require.NoError(t, err)
+		require.Equal(t, first, again, "%v", privProtocol)
+	}
+}
+
+func TestSaltSource(t *testing.T) {
+	seed := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	saltSource := func(b []byte) (int, error) {
+		return copy(b, seed), nil
+	}
+	scopedPdu := []byte{0x30, 0x05, 0x04, 0x00, 0x04, 0x01, 0x41}
+
+	for _, privProtocol := range []SnmpV3PrivProtocol{DES, AES, AES256C} {
+		var packets [][]byte
+		for i := 0; i < 2; i++ {
+			sp := &UsmSecurityParameters{
+				AuthoritativeEngineID:    "testengine",
+				AuthoritativeEngineBoots: 4,
+				AuthoritativeEngineTime:  1234,
+				UserName:                 "test",
+				AuthenticationProtocol:   SHA,
+				AuthenticationPassphrase: "authpassword",
+				PrivacyProtocol:          privProtocol,
+				PrivacyPassphrase:        "privpassword",
+				SaltSource:               saltSource,
+			}
+			require.NoError(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+			require.NoError(t, sp.initSecurityKeys())
+			require.NoError(t, sp.usmSetSalt(sp.usmAllocateNewSalt()))
+			if privProtocol == DES {
+				// the engine boots, then the salt incremented from the seed
+				require.Equal(t, []byte{0, 0, 0, 4, 1, 2, 3, 5}, sp.PrivacyParameters)
+			} else {
+				require.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 9}, sp.PrivacyParameters)
+			}
+
+			encrypted, err := sp.encryptPacket(append([]byte{}, scopedPdu...))
+			require.NoError(t, err)
+			packets = append(packets, encrypted)
+		}
+		require.Equal(t, packets[0], packets[1], "%v", privProtocol)
+	}
+
+	sp := &UsmSecurityParameters{
+		PrivacyProtocol: AES,
+		SaltSource: func(b []byte) (int, error) {
+			return 0, errors.New("no entropy")
+		},
+	}
+	require.Error(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+}
+
+func TestOnEngineIDChange(t *testing.T) {
+	changes := make(chan [2]string, 4)
+	sp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "engine-change-auth",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "engine-change-priv",
+		OnEngineIDChange: func(oldID, newID string) {
+			changes <- [2]string{oldID, newID}
+		},
+	}
+	require.NoError(t, sp.init(NewLogger(log.New(ioutil.Discard, "", 0))))
+	require.NotNil(t, passwordKeyHashCache.get(cacheKey(SHA, "engine-change-auth")), "hashed by init")
+	require.NotNil(t, passwordKeyHashCache.get(cacheKey(SHA, "engine-change-priv")), "hashed by init")
+
+	// discovery
+	require.NoError(t, sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: "engine-a"}))
+	keyA := append([]byte(nil), sp.SecretKey...)
+
+	// failover
+	require.NoError(t, sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: "engine-b"}))
+	require.Equal(t, [2]string{"engine-a", "engine-b"}, <-changes)
+	require.NotEqual(t, keyA, sp.SecretKey)
+	key, err := LocalizeKey(SHA, "engine-change-auth", "engine-b")
+	require.NoError(t, err)
+	require.Equal(t, key, sp.SecretKey)
+
+	require.NoError(t, sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: "engine-b", AuthoritativeEngineBoots: 2}))
+	require.NoError(t, sp.relocalizeKeys(&UsmSecurityParameters{AuthoritativeEngineID: "engine-a"}))
+	require.Equal(t, [2]string{"engine-b", "engine-a"}, <-changes)
+	require.Equal(t, keyA, sp.SecretKey)
+
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %v", change)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -5,14 +5,160 @@
 package gosnmp
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ErrStopWalk can be returned by a WalkFunc to end the walk early, eg once
+// the value looked for is found. The walk then returns nil rather than the
+// error.
+var ErrStopWalk = errors.New("stop walk") //nolint:gochecknoglobals
+
+// errMaxRows ends a walk once WalkOptions.MaxRows values were reported.
+var errMaxRows = errors.New("walk reached MaxRows")
+
+// rxReuse holds the buffers reused between the requests of a walk.
+type rxReuse struct {
+	buf    []byte
+	packet SnmpPacket
+}
+
+// next returns the packet to decode the next response into.
+func (r *rxReuse) next() *SnmpPacket {
+	r.packet = SnmpPacket{Variables: r.packet.Variables[:0]}
+	return &r.packet
+}
+
 func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) error {
+	if getRequestType == GetNextRequest && x.NextCacheTTL > 0 && x.nextCache != nil {
+		return x.walkCached(rootOid, x.ReuseWalkBuffers, walkFn)
+	}
+	return x.walkThrottled(getRequestType, rootOid, x.MaxRepetitions, 0, x.ReuseWalkBuffers, walkFn)
+}
+
+// nextCache holds the OIDs found by GETNEXT walks, keyed by root OID, see
+// GoSNMP.NextCacheTTL.
+type nextCache struct {
+	sync.Mutex
+	entries map[string]nextCacheEntry
+}
+
+type nextCacheEntry struct {
+	oids   []string
+	stored time.Time
+}
+
+// walkCached walks like walk with GETNEXT, using and filling x.nextCache.
+func (x *GoSNMP) walkCached(rootOid string, reuse bool, walkFn WalkFunc) error {
+	x.nextCache.Lock()
+	entry, ok := x.nextCache.entries[rootOid]
+	x.nextCache.Unlock()
+
+	if ok && time.Since(entry.stored) < x.NextCacheTTL {
+		pdus, unchanged, err := x.getCached(rootOid, entry.oids)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			for _, pdu := range pdus {
+				if err := walkFn(pdu); err != nil {
+					if errors.Is(err, ErrStopWalk) {
+						return nil
+					}
+					return err
+				}
+			}
+			return nil
+		}
+		x.Logger.Printf("Walk of %s changed size, dropping its cached OIDs", rootOid)
+	}
+
+	var oids []string
+	stopped := false
+	err := x.walkThrottled(GetNextRequest, rootOid, x.MaxRepetitions, 0, reuse, func(pdu SnmpPDU) error {
+		oids = append(oids, pdu.Name)
+		err := walkFn(pdu)
+		stopped = errors.Is(err, ErrStopWalk)
+		return err
+	})
+
+	x.nextCache.Lock()
+	switch {
+	case stopped:
+		// the OIDs after the stop are unknown, keep any earlier entry
+	case err == nil:
+		x.nextCache.entries[rootOid] = nextCacheEntry{oids: oids, stored: time.Now()}
+	default:
+		delete(x.nextCache.entries, rootOid)
+	}
+	x.nextCache.Unlock()
+	return err
+}
+
+// getCached fetches the cached oids of a walk of rootOid, and reports whether
+// the subtree still holds exactly those OIDs: each is found, and a GETNEXT
+// from the last one leaves the subtree.
+func (x *GoSNMP) getCached(rootOid string, oids []string) ([]SnmpPDU, bool, error) {
+	pdus := make([]SnmpPDU, 0, len(oids))
+	for start := 0; start < len(oids); start += x.MaxOids {
+		end := start + x.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		response, err := x.Get(oids[start:end])
+		if err != nil {
+			return nil, false, err
+		}
+		if response.Error != NoError || len(response.Variables) != end-start {
+			return nil, false, nil
+		}
+		for _, pdu := range response.Variables {
+			if pdu.Type == NoSuchObject || pdu.Type == NoSuchInstance || pdu.Type == EndOfMibView {
+				return nil, false, nil
+			}
+		}
+		pdus = append(pdus, response.Variables...)
+	}
+
+	root := rootOid
+	if root == "" || root == "." {
+		root = baseOid
+	}
+	root = "." + strings.Trim(x.rewriteOID(root), ".")
+	last := root
+	if len(oids) > 0 {
+		last = oids[len(oids)-1]
+	}
+	response, err := x.GetNext([]string{last})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, pdu := range response.Variables {
+		if response.Error == NoError && pdu.Type != EndOfMibView && strings.HasPrefix(pdu.Name, root+".") {
+			return nil, false, nil
+		}
+	}
+	return pdus, true, nil
+}
+
+// walkThrottled walks like walk, using maxReps as the GETBULK max-repetitions
+// and sleeping for pause between requests. If reuse is set the responses are
+// decoded into the same buffers.
+func (x *GoSNMP) walkThrottled(getRequestType PDUType, rootOid string, maxReps uint32, pause time.Duration, reuse bool, walkFn WalkFunc) error {
 	if rootOid == "" || rootOid == "." {
+		x.Logger.Printf("WARNING walk with empty root OID, walking the whole tree from %s", baseOid)
+		if x.OnFullTreeWalk != nil {
+			if err := x.OnFullTreeWalk(x); err != nil {
+				return err
+			}
+		}
 		rootOid = baseOid
 	}
+	rootOid = x.rewriteOID(rootOid)
 
 	if !strings.HasPrefix(rootOid, ".") {
 		rootOid = string(".") + rootOid
@@ -20,9 +166,14 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 
 	oid := rootOid
 	requests := 0
-	maxReps := x.MaxRepetitions
+	var received int64
 	if maxReps == 0 {
-		maxReps = defaultMaxRepetitions
+		maxReps = DefaultMaxRepetitions
+	}
+
+	var buffers *rxReuse
+	if reuse {
+		buffers = &rxReuse{}
 	}
 
 	// AppOpt 'c: do not check returned OIDs are increasing'
@@ -37,17 +188,27 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 
 RequestLoop:
 	for {
+		if err := x.Context.Err(); err != nil {
+			return err
+		}
+		if requests > 0 {
+			if err := x.sleep(pause); err != nil {
+				return err
+			}
+		}
 		requests++
 
 		var response *SnmpPacket
 		var err error
 
-		switch getRequestType {
-		case GetBulkRequest:
+		switch {
+		case buffers != nil && getRequestType != GetRequest:
+			response, err = x.sendReusing(getRequestType, oid, maxReps, buffers)
+		case getRequestType == GetBulkRequest:
 			response, err = x.GetBulk([]string{oid}, uint8(x.NonRepeaters), maxReps)
-		case GetNextRequest:
+		case getRequestType == GetNextRequest:
 			response, err = x.GetNext([]string{oid})
-		case GetRequest:
+		case getRequestType == GetRequest:
 			response, err = x.Get([]string{oid})
 		default:
 			response, err = nil, fmt.Errorf("unsupported request type: %d", getRequestType)
@@ -56,6 +217,10 @@ RequestLoop:
 		if err != nil {
 			return err
 		}
+		received += int64(response.size)
+		if x.WalkMaxBytes > 0 && received > x.WalkMaxBytes {
+			return fmt.Errorf("%w: received %d bytes in %d requests", ErrWalkMaxBytes, received, requests)
+		}
 		if len(response.Variables) == 0 {
 			break RequestLoop
 		}
@@ -117,6 +282,14 @@ RequestLoop:
 			break RequestLoop
 		case NoError:
 			x.Logger.Print("Walk completed with NoError")
+		default:
+			return fmt.Errorf("walk terminated with unknown error status %d at index %d", response.Error, response.ErrorIndex)
+		}
+
+		if x.walkResponse != nil {
+			if err := x.walkResponse(requests); err != nil {
+				return x.walkStopped(err, requests)
+			}
 		}
 
 		for i, pdu := range response.Variables {
@@ -134,11 +307,12 @@ RequestLoop:
 				if requests == 1 && i == 0 {
 					getRequestType = GetRequest
 					continue RequestLoop
-				} else if pdu.Name == rootOid && pdu.Type != NoSuchInstance {
+				} else if pdu.Name == rootOid && pdu.Type != NoSuchInstance &&
+					x.walkDenied(pdu.Name) == "" && x.walkAllowed(pdu.Name) {
 					// Call walk function if the pdu instance is found
 					// considering that the rootOid is a leafOid
 					if err := walkFn(pdu); err != nil {
-						return err
+						return x.walkStopped(err, requests)
 					}
 				}
 				break RequestLoop
@@ -148,9 +322,26 @@ RequestLoop:
 				return fmt.Errorf("OID not increasing: %s", pdu.Name)
 			}
 
+			if x.isWalkEnd(pdu) {
+				x.Logger.Printf("Walk terminated at end sentinel %s", pdu.Name)
+				break RequestLoop
+			}
+
+			if denied := x.walkDenied(pdu.Name); denied != "" {
+				if x.WalkSkipDenied {
+					// resume after the last possible sub-identifier
+					oid = denied + ".4294967295"
+					continue RequestLoop
+				}
+				continue
+			}
+			if !x.walkAllowed(pdu.Name) {
+				continue
+			}
+
 			// Report our pdu
 			if err := walkFn(pdu); err != nil {
-				return err
+				return x.walkStopped(err, requests)
 			}
 		}
 		// Save last oid for next request
@@ -160,10 +351,76 @@ RequestLoop:
 	return nil
 }
 
+// walkStopped returns the error of a walk whose WalkFunc returned err: nil
+// for ErrStopWalk.
+func (x *GoSNMP) walkStopped(err error, requests int) error {
+	if errors.Is(err, ErrStopWalk) {
+		x.Logger.Printf("Walk stopped by its WalkFunc after %d requests", requests)
+		return nil
+	}
+	return err
+}
+
+// walkDenied returns the WalkDeny prefix name is under, with a leading dot,
+// or "" if there is none.
+func (x *GoSNMP) walkDenied(name string) string {
+	for _, prefix := range x.WalkDeny {
+		if prefix = "." + strings.Trim(prefix, "."); isUnderOID(name, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// walkAllowed reports whether name is under one of the WalkAllow prefixes,
+// or WalkAllow is empty.
+func (x *GoSNMP) walkAllowed(name string) bool {
+	for _, prefix := range x.WalkAllow {
+		if isUnderOID(name, "."+strings.Trim(prefix, ".")) {
+			return true
+		}
+	}
+	return len(x.WalkAllow) == 0
+}
+
+// isUnderOID reports whether name is prefix or in the subtree under it.
+func isUnderOID(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+".")
+}
+
+// isWalkEnd reports whether pdu matches the WalkEndSentinel.
+func (x *GoSNMP) isWalkEnd(pdu SnmpPDU) bool {
+	end := x.WalkEndSentinel
+	return end != nil && pdu.Type == end.Type && reflect.DeepEqual(pdu.Value, end.Value)
+}
+
+// sendReusing sends a GETBULK or GETNEXT for oid like GetBulk and GetNext,
+// decoding the response into buffers.
+func (x *GoSNMP) sendReusing(getRequestType PDUType, oid string, maxReps uint32, buffers *rxReuse) (*SnmpPacket, error) {
+	pdus := []SnmpPDU{{x.rewriteOID(oid), Null, nil}}
+	var packetOut *SnmpPacket
+	if getRequestType == GetBulkRequest {
+		if x.Version == Version1 {
+			return nil, fmt.Errorf("GETBULK not supported in SNMPv1")
+		}
+		packetOut = x.mkSnmpPacket(GetBulkRequest, pdus, uint8(x.NonRepeaters), maxReps)
+	} else {
+		packetOut = x.mkSnmpPacket(GetNextRequest, pdus, 0, 0)
+	}
+	packetOut.reuse = buffers
+	return x.send(packetOut, true)
+}
+
 func (x *GoSNMP) walkAll(getRequestType PDUType, rootOid string) (results []SnmpPDU, err error) {
-	err = x.walk(getRequestType, rootOid, func(dataUnit SnmpPDU) error {
+	collect := func(dataUnit SnmpPDU) error {
 		results = append(results, dataUnit)
 		return nil
-	})
+	}
+	// results are retained, so buffers can't be reused
+	if getRequestType == GetNextRequest && x.NextCacheTTL > 0 && x.nextCache != nil {
+		err = x.walkCached(rootOid, false, collect)
+		return results, err
+	}
+	err = x.walkThrottled(getRequestType, rootOid, x.MaxRepetitions, 0, false, collect)
 	return results, err
 }
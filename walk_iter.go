@@ -0,0 +1,39 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build go1.23
+// +build go1.23
+
+package gosnmp
+
+import "iter"
+
+// All returns an iterator over the subtree of values under rootOid, for use
+// with range:
+//
+//	for pdu, err := range x.All(".1.3.6.1.2.1.2.2") {
+//		...
+//	}
+//
+// The subtree is walked lazily with GETBULK, or GETNEXT for SNMPv1, so
+// breaking out of the loop stops the walk without further requests. An
+// error ends the iteration, and is yielded with an empty SnmpPDU.
+func (x *GoSNMP) All(rootOid string) iter.Seq2[SnmpPDU, error] {
+	return func(yield func(SnmpPDU, error) bool) {
+		getRequestType := GetBulkRequest
+		if x.Version == Version1 {
+			getRequestType = GetNextRequest
+		}
+
+		err := x.walk(getRequestType, rootOid, func(dataUnit SnmpPDU) error {
+			if !yield(dataUnit, nil) {
+				return ErrStopWalk
+			}
+			return nil
+		})
+		if err != nil {
+			yield(SnmpPDU{}, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+//go:build go1.23 && (all || marshal)
+// +build go1.23
+// +build all marshal
+
+package gosnmp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllStopsWalk(t *testing.T) {
+	mib := testMib{
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.3", Type: Integer, Value: 3},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.4", Type: Integer, Value: 4},
+	}
+	var mu sync.Mutex
+	requests := 0
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		return mib.handle(req)
+	})
+	defer closeFn()
+	// walk with GETNEXT, so each PDU costs one request
+	x.Version = Version1
+
+	var names []string
+	x.All(".1.3.6.1.2.1.2.2")(func(pdu SnmpPDU, err error) bool {
+		require.NoError(t, err)
+		names = append(names, pdu.Name)
+		return len(names) < 2
+	})
+	require.Equal(t, []string{".1.3.6.1.2.1.2.2.1.1.1", ".1.3.6.1.2.1.2.2.1.1.2"}, names)
+	mu.Lock()
+	require.Equal(t, 2, requests, "walk should stop when iteration stops")
+	mu.Unlock()
+
+	names = nil
+	x.All(".1.3.6.1.2.1.2.2")(func(pdu SnmpPDU, err error) bool {
+		require.NoError(t, err)
+		names = append(names, pdu.Name)
+		return true
+	})
+	require.Len(t, names, 4)
+}
+
+func TestAllYieldsError(t *testing.T) {
+	x, closeFn := newTestAgent(t, func(req *SnmpPacket) *SnmpPacket {
+		return nil
+	})
+	defer closeFn()
+	x.Retries = 0
+
+	var errs []error
+	x.All(".1.3.6.1.2.1.2.2")(func(pdu SnmpPDU, err error) bool {
+		errs = append(errs, err)
+		return true
+	})
+	require.Len(t, errs, 1)
+	require.Error(t, errs[0])
+}
@@ -0,0 +1,100 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// walkTreeNode is an OID arc in the tree built by FormatWalkTree.
+type walkTreeNode struct {
+	arc      string
+	pdu      *SnmpPDU
+	children []*walkTreeNode
+	index    map[string]*walkTreeNode
+}
+
+func (n *walkTreeNode) child(arc string) *walkTreeNode {
+	if c, ok := n.index[arc]; ok {
+		return c
+	}
+	if n.index == nil {
+		n.index = make(map[string]*walkTreeNode)
+	}
+	c := &walkTreeNode{arc: arc}
+	n.index[arc] = c
+	n.children = append(n.children, c)
+	return c
+}
+
+// FormatWalkTree renders the results of a walk as an indented tree of OID
+// arcs, in the style of a MIB browser. Arcs without a value of their own and
+// with a single child are joined onto one line, values are annotated with
+// their type:
+//
+//	.1.3.6.1.2.1.1
+//	  1.0 = OctetString: "Linux router"
+//	  3.0 = TimeTicks: 1234
+//
+// Children keep the order in which they appear in pdus.
+func FormatWalkTree(pdus []SnmpPDU) string {
+	root := &walkTreeNode{}
+	for i := range pdus {
+		node := root
+		for _, arc := range strings.Split(strings.Trim(pdus[i].Name, "."), ".") {
+			node = node.child(arc)
+		}
+		node.pdu = &pdus[i]
+	}
+
+	var b strings.Builder
+	for _, c := range root.children {
+		c.format(&b, ".", 0)
+	}
+	return b.String()
+}
+
+func (n *walkTreeNode) format(b *strings.Builder, prefix string, depth int) {
+	label := prefix + n.arc
+	for n.pdu == nil && len(n.children) == 1 {
+		n = n.children[0]
+		label += "." + n.arc
+	}
+
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(label)
+	if n.pdu != nil {
+		fmt.Fprintf(b, " = %s", n.pdu.Type)
+		if n.pdu.Value != nil {
+			fmt.Fprintf(b, ": %s", formatWalkTreeValue(n.pdu.Value))
+		}
+	}
+	b.WriteByte('\n')
+
+	for _, c := range n.children {
+		c.format(b, "", depth+1)
+	}
+}
+
+// formatWalkTreeValue quotes printable strings and shows other byte values
+// in hex.
+func formatWalkTreeValue(value interface{}) string {
+	v, ok := value.([]byte)
+	if !ok {
+		return fmt.Sprintf("%v", value)
+	}
+	if !utf8.Valid(v) {
+		return fmt.Sprintf("% x", v)
+	}
+	for _, r := range string(v) {
+		if !unicode.IsPrint(r) {
+			return fmt.Sprintf("% x", v)
+		}
+	}
+	return fmt.Sprintf("%q", v)
+}